@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+)
+
+// runConfig implements the config command:
+//
+//	library config print [flags]
+//
+// It prints the configuration the server would run with as YAML, secrets
+// are left out. It exits with 1 when the configuration is invalid.
+func runConfig(args []string) int {
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	loader := config.NewLoader(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s config print [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "print" {
+		flags.Usage()
+		return 2
+	}
+	flags.Parse(args[1:])
+
+	cfg, err := loader.Load()
+	var invalid *config.ValidationError
+	if err != nil && !errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := config.Write(os.Stdout, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if invalid != nil {
+		fmt.Fprintln(os.Stderr, invalid)
+		return 1
+	}
+	return 0
+}
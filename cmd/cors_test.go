@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+)
+
+var templateParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// route is a method and path router serves, the path variables are set to 1.
+type route struct {
+	method, path string
+}
+
+func (r route) String() string { return r.method + " " + r.path }
+
+// routes lists the method and path of every route of router.
+func routes(t *testing.T, router *mux.Router) []route {
+	t.Helper()
+	var routes []route
+	err := router.Walk(func(r *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := r.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// subrouter prefixes match every method
+		methods, err := r.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routes = append(routes, route{method, templateParam.ReplaceAllString(template, "1")})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+// TestCORSPreflight sends the preflight request of every route of newRouter
+// from allowed and foreign origins.
+func TestCORSPreflight(t *testing.T) {
+	cfg := config.Default()
+	router := newRouter(nil, nil, nil, &cfg)
+
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		status      int
+		allowOrigin string
+	}{
+		{"configured origin", []string{"https://localhost"}, false, "https://localhost", http.StatusNoContent, "https://localhost"},
+		{"configured origin with credentials", []string{"https://localhost"}, true, "https://localhost", http.StatusNoContent, "https://localhost"},
+		{"wildcard subdomain", []string{"https://*.example.com"}, false, "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"any origin", []string{"*"}, false, "https://anywhere.test", http.StatusNoContent, "*"},
+		{"foreign origin", []string{"https://localhost"}, false, "https://evil.test", http.StatusForbidden, ""},
+		{"foreign subdomain", []string{"https://*.example.com"}, false, "https://example.com.evil.test", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corsCfg := cfg.CORS
+			corsCfg.AllowedOrigins = tt.origins
+			corsCfg.AllowCredentials = tt.credentials
+			handler := newCORS(corsCfg)(router)
+
+			for _, route := range routes(t, router) {
+				req := httptest.NewRequest(http.MethodOptions, route.path, nil)
+				req.Header.Set("Origin", tt.origin)
+				req.Header.Set("Access-Control-Request-Method", route.method)
+				req.Header.Set("Access-Control-Request-Headers", "content-type")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.status {
+					t.Errorf("%s: status %d, want %d", route, rec.Code, tt.status)
+					continue
+				}
+				header := rec.Header()
+				if got := header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+					t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", route, got, tt.allowOrigin)
+				}
+				if tt.status != http.StatusNoContent {
+					continue
+				}
+				if methods := header.Get("Access-Control-Allow-Methods"); !strings.Contains(methods, route.method) {
+					t.Errorf("%s: Access-Control-Allow-Methods %q", route, methods)
+				}
+				if headers := header.Get("Access-Control-Allow-Headers"); headers != "Content-Type" {
+					t.Errorf("%s: Access-Control-Allow-Headers %q", route, headers)
+				}
+				if got := header.Get("Access-Control-Allow-Credentials"); (got == "true") != tt.credentials {
+					t.Errorf("%s: Access-Control-Allow-Credentials %q", route, got)
+				}
+				if header.Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("%s: Access-Control-Max-Age %q", route, header.Get("Access-Control-Max-Age"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/librarypb"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/repos"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"gorm.io/gorm"
+)
+
+// newGRPCServer registers the library service on a new gRPC server, calls
+// are identified, logged and bounded by the request timeout like the
+// requests of the REST api
+func newGRPCServer(db *gorm.DB, broker *events.Broker, cfg *config.Config) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			middleware.UnaryRequestID,
+			middleware.UnaryAccessLog,
+			identifyUnary(cfg.Auth.APIKeys, cfg.Auth.AdminKeys),
+			middleware.UnaryDeadline(cfg.Server.RequestTimeout),
+		),
+		grpc.ChainStreamInterceptor(
+			middleware.StreamRequestID,
+			middleware.StreamAccessLog,
+			identifyStream(cfg.Auth.APIKeys, cfg.Auth.AdminKeys),
+		),
+	)
+
+	books := repos.NewBookRepository(db)
+	authors := repos.NewAuthorRepository(db, trash.Policy(cfg.Trash.AuthorPolicy))
+	librarypb.RegisterLibraryServiceServer(srv, repos.NewGRPCRepository(books, authors, broker, cfg.Stream.ReplayLimit))
+	if cfg.GRPC.Reflection {
+		reflection.Register(srv)
+	}
+	return srv
+}
+
+// identifyUnary records who made a call like identify, from the
+// authorization and x-api-key metadata
+func identifyUnary(keys, adminKeys []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		identifyCall(ctx, keys, adminKeys)
+		return handler(ctx, req)
+	}
+}
+
+// identifyStream is identifyUnary for streaming calls
+func identifyStream(keys, adminKeys []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		identifyCall(ss.Context(), keys, adminKeys)
+		return handler(srv, ss)
+	}
+}
+
+func identifyCall(ctx context.Context, keys, adminKeys []string) {
+	authorization := middleware.MetadataValue(ctx, "authorization")
+	key := middleware.MetadataValue(ctx, "x-api-key")
+	if principal, ok := principalOf(authorization, key, keys, adminKeys); ok {
+		middleware.SetPrincipal(ctx, principal)
+	}
+}
+
+// shutdownGRPC stops accepting calls and waits for the running ones until
+// ctx expires, their connections are closed then. The event streams are
+// ended first as they never finish on their own.
+func shutdownGRPC(ctx context.Context, srv *grpc.Server, broker *events.Broker) error {
+	broker.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
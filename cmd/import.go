@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/importer"
+)
+
+// runImport implements the import command:
+//
+//	library import [flags] [file]
+//
+// It reads the file (or stdin when no file or "-" is given), prints the
+// import report as json and exits with 1 when a row failed.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	entity := flags.String("entity", string(importer.Books), "what the rows are: books or authors")
+	format := flags.String("format", "", "csv, jsonl or json, read from the file extension when empty")
+	dryRun := flags.Bool("dry-run", false, "validate and write every row, then roll everything back")
+	createAuthors := flags.Bool("create-authors", false, "create the authors referenced by name which do not exist")
+	onConflict := flags.String("on-conflict", string(importer.Skip), "skip or update rows matching an existing record")
+	batchSize := flags.Int("batch-size", importer.DefaultBatchSize, "number of rows written in one transaction")
+	loader := config.NewLoader(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [flags] [file]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	cfg := mustLoad(loader)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	name := flags.Arg(0)
+	if name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+
+	opts := importer.Options{
+		Entity:        importer.Entity(*entity),
+		Format:        importer.Format(*format),
+		DryRun:        *dryRun,
+		CreateAuthors: *createAuthors,
+		OnConflict:    importer.Conflict(*onConflict),
+		BatchSize:     *batchSize,
+	}
+	if opts.Format == "" {
+		opts.Format = importer.FormatFromFileName(name)
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// the report goes to stdout, so logs default to stderr
+	logFile := setupLogging(cfg.Log, "stderr")
+	defer logFile.Close()
+
+	db := openDB(cfg.Database)
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	if err := migrate(db); err != nil {
+		fmt.Fprintln(os.Stderr, "migration failed:", err)
+		return 1
+	}
+
+	// SIGINT or SIGTERM stop the import, the batch being written is rolled
+	// back and the report shows the rows written before
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := importer.New(db).Import(ctx, in, opts)
+	if report != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -2,111 +2,353 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	_ "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/docs" // This line is necessary for go-swagger to find your docs!
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/audit"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
 	postgres "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/db"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/health"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/lifecycle"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/metrics"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
 	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/repos"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/tracing"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/webhooks"
 	"github.com/joho/godotenv"
+	"gorm.io/gorm"
 )
 
 func main() {
-	// Set Environment Variables
-	err := godotenv.Load()
-	if err != nil {
+	// Environment variables may also be set in an optional .env file
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("Error loading .env file: %s", err)
 	}
 
-	db, err := postgres.NewPsqlDB()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "webhook-receiver":
+			os.Exit(runReceiver(os.Args[2:]))
+		case "openapi":
+			os.Exit(runOpenAPI(os.Args[2:]))
+		}
+	}
+
+	flags := flag.NewFlagSet("library", flag.ExitOnError)
+	loader := config.NewLoader(flags)
+	flags.Parse(os.Args[1:])
+	cfg := mustLoad(loader)
+
+	os.Exit(serve(cfg))
+}
+
+// serve runs the api until it receives SIGINT or SIGTERM and returns the
+// exit code of the process, 1 when it could not stop in time.
+func serve(cfg *config.Config) int {
+	logFile := setupLogging(cfg.Log, "stdout")
+	defer logFile.Close()
+
+	app := lifecycle.New()
+	app.OnClose("tracing", setupTracing(cfg.Tracing))
+
+	db := openDB(cfg.Database)
+	if err := migrate(db); err != nil {
+		log.Fatalf("Migration failed: %s", err)
+	}
+
+	sqlDB, err := db.DB()
 	if err != nil {
 		log.Fatalf("Postgres cannot init: %s", err)
 	}
-	log.Printf("Connected to Postgres Database.")
+	app.OnClose("database", func(context.Context) error {
+		return sqlDB.Close()
+	})
+	checker := health.NewChecker(
+		health.Check{Name: "database", Run: sqlDB.PingContext},
+		health.Check{Name: "migrations", Run: func(ctx context.Context) error {
+			return migrated(ctx, db)
+		}},
+	)
 
-	// Initialize Repositories
-	authorRepo := repos.NewAuthorRepository(db)
-	authorRepo.Migration()
-	bookRepo := repos.NewBookRepository(db)
-	bookRepo.Migration()
-	// bookRepo.InsertSampleData()
-	// authorRepo.InsertSampleData()
-
-	r := mux.NewRouter()
-
-	r.Use(loggingMiddleware)
-	r.Use(authenticationMiddleware)
-
-	handlers.AllowedOrigins([]string{"https://localhost"})
-	handlers.AllowedHeaders([]string{"Content-Type", "Authorization"})
-	handlers.AllowedMethods([]string{"POST", "GET", "PUT", "PATCH"})
-
-	b := r.PathPrefix("/books").Subrouter()
-
-    b.HandleFunc("/", bookRepo.GetAllBooks).Methods(http.MethodGet)
-	b.HandleFunc("/withauthors", bookRepo.GetAllBooksWithAuthorById).Methods(http.MethodGet)
-    b.HandleFunc("/{id}", bookRepo.GetBookByID).Methods(http.MethodGet)
-    b.HandleFunc("/{id}/withauthors", bookRepo.GetBooksWithAuthorById).Methods(http.MethodGet)
-	b.HandleFunc("/", bookRepo.AddBook).Methods(http.MethodPost)
-    b.HandleFunc("/find/{name}", bookRepo.FindBookByName).Methods(http.MethodGet)
-	b.HandleFunc("/{id}", bookRepo.UpdateBook).Methods(http.MethodPut)
-	b.HandleFunc("/buy/{id}/{quantity}", bookRepo.BuyBookByID).Methods(http.MethodPatch)
-	b.HandleFunc("/{id}", bookRepo.DeleteBook).Methods(http.MethodDelete)
-    r.HandleFunc("/bookcount", bookRepo.GetBooksCount).Methods(http.MethodGet)
-    b.HandleFunc("/lessthen/{pages}", bookRepo.GetBooksByPagesLessThenWithAuthorInformation).Methods(http.MethodGet)
-
-	a := r.PathPrefix("/authors").Subrouter()
-	
-	a.HandleFunc("/", authorRepo.GetAllAuthors).Methods(http.MethodGet)
-	a.HandleFunc("/withbooks", authorRepo.GetAllAuthorsWithBooksById).Methods(http.MethodGet)
-	a.HandleFunc("/{id}", authorRepo.GetAuthorByID).Methods(http.MethodGet)
-    a.HandleFunc("/{id}/withbooks", authorRepo.GetAuthorWithBooksById).Methods(http.MethodGet)
-	a.HandleFunc("/", authorRepo.AddAuthor).Methods(http.MethodPost)
-    a.HandleFunc("/find/{name}", authorRepo.FindAuthorByName).Methods(http.MethodGet)
-	a.HandleFunc("/{id}", authorRepo.UpdateAuthor).Methods(http.MethodPut)
-	a.HandleFunc("/{id}", authorRepo.DeleteAuthor).Methods(http.MethodDelete)
-    r.HandleFunc("/authorcount", authorRepo.GetAuthorsCount).Methods(http.MethodGet)
+	broker := events.NewBroker(db, cfg.Stream.PollInterval, cfg.Stream.Buffer)
+	r := newRouter(db, checker, broker, cfg)
+
+	cors := newCORS(cfg.CORS)
 
 	srv := &http.Server{
-		Addr:         "127.0.0.1:4000",
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      r,
+		Addr:         cfg.Server.Addr,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		Handler:      middleware.RequestID(tracing.Middleware(middleware.AccessLog(metrics.Middleware(cors(r))))),
+	}
+	// event streams never finish on their own, they end when the server
+	// starts shutting down after the drain delay
+	srv.RegisterOnShutdown(broker.Close)
+	app.Go("event-stream", broker.Run)
+	if cfg.Trash.RetentionDays > 0 {
+		retention := time.Duration(cfg.Trash.RetentionDays) * 24 * time.Hour
+		purger := trash.New(db, trash.Policy(cfg.Trash.AuthorPolicy))
+		app.Go("trash-purge", func(ctx context.Context) {
+			purger.Run(ctx, retention, cfg.Trash.PurgeInterval)
+		})
+	}
+
+	if cfg.Webhooks.Enabled {
+		dispatcher := webhooks.NewDispatcher(db, webhooks.Options{
+			Interval:    cfg.Webhooks.PollInterval,
+			Timeout:     cfg.Webhooks.Timeout,
+			MaxAttempts: cfg.Webhooks.MaxAttempts,
+			RetryBase:   cfg.Webhooks.RetryBase,
+			RetryMax:    cfg.Webhooks.RetryMax,
+			BatchSize:   cfg.Webhooks.BatchSize,
+		})
+		app.Go("webhooks", dispatcher.Run)
+	}
+
+	// the gRPC api is registered first so it stops after the REST api
+	// drained, its event streams end when the REST api closes the broker
+	failed := make(chan error, 2)
+	if cfg.GRPC.Enabled {
+		grpcSrv := newGRPCServer(db, broker, cfg)
+		lis, err := net.Listen("tcp", cfg.GRPC.Addr)
+		if err != nil {
+			log.Fatalf("gRPC api cannot listen: %s", err)
+		}
+		app.OnShutdown("grpc", func(ctx context.Context) error {
+			return shutdownGRPC(ctx, grpcSrv, broker)
+		})
+		go func() {
+			log.Printf("gRPC API is running on %s", lis.Addr())
+
+			if err := grpcSrv.Serve(lis); err != nil {
+				failed <- err
+			}
+		}()
 	}
+	app.OnShutdown("http", func(ctx context.Context) error {
+		return shutdownServer(ctx, srv, checker, cfg.Server.DrainDelay)
+	})
 
 	// start server
 	go func() {
 		log.Println("API is running!")
 
-		if err := srv.ListenAndServe(); err != nil {
-			log.Println(err)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
 		}
 	}()
 
-	ShutdownServer(srv, time.Second*10)
+	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C) or
+	// SIGTERM. SIGKILL cannot be caught.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	code := 0
+	select {
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	case err := <-failed:
+		log.Printf("server failed: %s", err)
+		code = 1
+	}
+	// a second signal kills the process right away
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := app.Stop(ctx); err != nil {
+		log.Printf("shutdown incomplete: %s", err)
+		return 1
+	}
+	log.Println("shut down")
+	return code
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Do stuff here
-		log.Println(r.RequestURI)
-		// Call the next handler, which can be another middleware in the chain, or the final handler.
-		next.ServeHTTP(w, r)
+// mustLoad loads the configuration once the command's flags are parsed,
+// the command cannot start with an invalid configuration.
+func mustLoad(loader *config.Loader) *config.Config {
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatalf("Configuration cannot be loaded: %s", err)
+	}
+	return cfg
+}
+
+// setupLogging makes the logger configured by cfg the default one, the
+// standard log package writes through it as well. Logs go to defaultOutput
+// when no output is configured. The returned closer releases the log file.
+func setupLogging(cfg config.Log, defaultOutput string) io.Closer {
+	output := cfg.Output
+	if output == "" {
+		output = defaultOutput
+	}
+
+	logger, closer, err := logging.New(logging.Config{
+		Level:  cfg.Level,
+		Format: cfg.Format,
+		Output: output,
+	})
+	if err != nil {
+		log.Fatalf("Logger cannot init: %s", err)
+	}
+	slog.SetDefault(logger)
+	return closer
+}
+
+// setupTracing installs the trace exporter configured by cfg, spans are not
+// exported unless the exporter is stdout, file or otlp. The returned
+// function flushes the pending spans.
+func setupTracing(cfg config.Tracing) func(context.Context) error {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Exporter,
+		File:        cfg.File,
+		Endpoint:    cfg.Endpoint,
+		Insecure:    cfg.Insecure,
+		ServiceName: cfg.ServiceName,
+		SampleRatio: cfg.SampleRatio,
 	})
+	if err != nil {
+		log.Fatalf("Tracing cannot init: %s", err)
+	}
+	return shutdown
+}
+
+// openDB connects to the database, queries are logged with the logger of
+// their context, measured and traced, changes are audited
+func openDB(cfg config.Database) *gorm.DB {
+	db, err := postgres.NewPsqlDB(cfg)
+	if err != nil {
+		log.Fatalf("Postgres cannot init: %s", err)
+	}
+	db.Logger = logging.NewGormLogger(cfg.SlowThreshold)
+	if err := metrics.RegisterDB(db, cfg.Name); err != nil {
+		log.Fatalf("Database metrics cannot init: %s", err)
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Fatalf("Database tracing cannot init: %s", err)
+	}
+	// the outbox and the deliveries only track what was sent where
+	ignored := []string{events.Event{}.TableName(), webhooks.Delivery{}.TableName()}
+	if err := db.Use(audit.GormPlugin{Ignored: ignored}); err != nil {
+		log.Fatalf("Database audit cannot init: %s", err)
+	}
+	log.Printf("Connected to Postgres Database.")
+	return db
+}
+
+// migrate creates or updates the tables of every entity, referenced tables
+// are migrated first
+func migrate(db *gorm.DB) error {
+	for _, migration := range []func() error{
+		repos.NewAuthorRepository(db, trash.Block).Migration,
+		repos.NewPublisherRepository(db).Migration,
+		repos.NewSeriesRepository(db).Migration,
+		repos.NewWorkRepository(db).Migration,
+		repos.NewCategoryRepository(db).Migration,
+		repos.NewTagRepository(db).Migration,
+		repos.NewBookRepository(db).Migration,
+		repos.NewAuditRepository(db).Migration,
+		repos.NewWebhookRepository(db).Migration,
+	} {
+		if err := migration(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrated reports the first table or index created by migrate which is
+// missing, so an instance running against an outdated schema is not ready
+func migrated(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+	for _, table := range []string{
+		"authors", "publishers", "series", "works", "categories", "tags",
+		"books", "book_categories", "book_tags", "audit_entries",
+		"outbox_events", "webhooks", "webhook_deliveries",
+	} {
+		if !migrator.HasTable(table) {
+			return fmt.Errorf("table %s is missing", table)
+		}
+	}
+	if !migrator.HasIndex("books", "idx_books_isbn") {
+		return fmt.Errorf("index idx_books_isbn is missing")
+	}
+	return nil
+}
+
+// identify records who sent the request, it never rejects one. Requests
+// with the author token act as the author user, requests with one of the
+// keys in X-API-Key as that key, admin keys as an admin. Only verified
+// principals are recorded, so the rate limiter can key its buckets by them.
+func identify(keys, adminKeys []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal, ok := principalOf(r.Header.Get("Authorization"), r.Header.Get("X-API-Key"), keys, adminKeys); ok {
+				middleware.SetPrincipal(r.Context(), principal)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// principalOf returns the client the credentials sent with a request
+// belong to, ok is false for anonymous requests and unknown credentials.
+func principalOf(authorization, key string, keys, adminKeys []string) (p middleware.Principal, ok bool) {
+	switch {
+	case authorization == "Bearer authortoken":
+		return middleware.Principal{Kind: "user", ID: "author"}, true
+	case key == "":
+		return p, false
+	case knownKey(key, adminKeys):
+		return middleware.Principal{Kind: "admin", ID: keyID(key)}, true
+	case knownKey(key, keys):
+		return middleware.Principal{Kind: "apikey", ID: keyID(key)}, true
+	}
+	return p, false
+}
+
+func knownKey(key string, keys []string) bool {
+	for _, known := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(known)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// keyID identifies an api key in logs and rate limits without revealing it
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
 }
 
 func authenticationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
 		if strings.HasPrefix(r.URL.Path, "/authors/") {
-			if token == "Bearer authortoken" {
+			if repos.CanAccessAuthors(r.Context()) {
 				next.ServeHTTP(w, r)
 			} else {
 				http.Error(w, "Token not found", http.StatusUnauthorized)
@@ -117,24 +359,36 @@ func authenticationMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func ShutdownServer(srv *http.Server, timeout time.Duration) {
-	c := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(c, os.Interrupt)
+// adminOnly lets only admins through, e.g. to purge records for good
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromContext(r.Context())
+		switch {
+		case !ok:
+			http.Error(w, "Token not found", http.StatusUnauthorized)
+		case principal.Kind != "admin":
+			http.Error(w, "Admin key required", http.StatusForbidden)
+		default:
+			next(w, r)
+		}
+	}
+}
 
-	// Block until we receive our signal.
-	<-c
+// shutdownServer fails the readiness probe first and gives the load
+// balancer drainDelay to notice before the listener is closed. It then
+// waits for the in-flight requests until ctx expires and closes their
+// connections if they did not finish.
+func shutdownServer(ctx context.Context, srv *http.Server, checker *health.Checker, drainDelay time.Duration) error {
+	checker.ShutDown()
+	log.Printf("draining for %s", drainDelay)
+	select {
+	case <-time.After(drainDelay):
+	case <-ctx.Done():
+	}
 
-	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	// Doesn't block if no connections, but will otherwise wait
-	// until the timeout deadline.
-	srv.Shutdown(ctx)
-	// Optionally, you could run srv.Shutdown in a goroutine and block on
-	// <-ctx.Done() if your application should wait for other services
-	// to finalize based on context cancellation.
-	log.Println("shutting down")
-	os.Exit(0)
-}
\ No newline at end of file
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/openapi"
+)
+
+// runOpenAPI implements the openapi command:
+//
+//	library openapi print|check
+//
+// print writes the OpenAPI document the server serves at /openapi.json.
+// check compares the document with the routes of the router and exits with
+// 1 when a route is not documented, it needs no database.
+func runOpenAPI(args []string) int {
+	if len(args) != 1 || (args[0] != "print" && args[0] != "check") {
+		fmt.Fprintf(os.Stderr, "Usage: %s openapi print|check\n", os.Args[0])
+		return 2
+	}
+
+	cfg := config.Default()
+	router := newRouter(nil, nil, nil, &cfg)
+	spec := routerSpec(router)
+	if args[0] == "print" {
+		if err := spec.Write(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	routes, err := openapi.Routes(router)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	problems := spec.Check(routes)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Printf("all %d routes are documented\n", len(routes))
+	return 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/openapi"
+)
+
+// TestSpecDocumentsEveryRoute fails when a route of newRouter is missing
+// from the OpenAPI document or was never documented in apiSpec.
+func TestSpecDocumentsEveryRoute(t *testing.T) {
+	cfg := config.Default()
+	router := newRouter(nil, nil, nil, &cfg)
+	routes, err := openapi.Routes(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) == 0 {
+		t.Fatal("the router has no routes")
+	}
+	for _, problem := range routerSpec(router).Check(routes) {
+		t.Error(problem)
+	}
+}
+
+func TestSpecReportsUndocumentedRoutes(t *testing.T) {
+	cfg := config.Default()
+	router := newRouter(nil, nil, nil, &cfg)
+	spec := routerSpec(router)
+	// added after the document was generated
+	router.HandleFunc("/bookcount2", func(http.ResponseWriter, *http.Request) {}).Methods(http.MethodGet)
+	routes, err := openapi.Routes(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := spec.Check(routes); len(problems) != 1 || !strings.Contains(problems[0], "GET /bookcount2 is not described") {
+		t.Errorf("problems %q, want the route added later", problems)
+	}
+
+	// generated but not documented
+	if err := spec.AddRoutes(router); err != nil {
+		t.Fatal(err)
+	}
+	if problems := spec.Check(routes); len(problems) == 0 || !strings.Contains(problems[0], "GET /bookcount2 is not documented") {
+		t.Errorf("problems %q, want the generated operation", problems)
+	}
+}
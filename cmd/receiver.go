@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/webhooks"
+)
+
+// runReceiver implements the webhook-receiver command:
+//
+//	library webhook-receiver [flags]
+//
+// It is a local endpoint to register as a webhook while testing the
+// delivery: it checks the signature of every delivery and prints the
+// verified events to stdout, one json document per line. With -fail-rate
+// it answers a share of the deliveries with 500 to exercise the retries.
+func runReceiver(args []string) int {
+	flags := flag.NewFlagSet("webhook-receiver", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:9090", "address the receiver listens on")
+	secret := flags.String("secret", os.Getenv("WEBHOOK_SECRET"), "secret of the webhook, defaults to WEBHOOK_SECRET")
+	failRate := flags.Float64("fail-rate", 0, "share of deliveries answered with 500, between 0 and 1")
+	tolerance := flags.Duration("tolerance", 5*time.Minute, "how old a delivery's signature may be")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s webhook-receiver [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *secret == "" || flags.NArg() > 0 || *failRate < 0 || *failRate > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler:           receiver(*secret, *failRate, *tolerance, os.Stdout),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Receiving webhooks on http://%s/", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// receiver verifies and prints the deliveries posted to it
+func receiver(secret string, failRate float64, tolerance time.Duration, out io.Writer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Deliveries are posted", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		delivery := r.Header.Get(webhooks.DeliveryHeader)
+		err = webhooks.Verify(secret, r.Header.Get(webhooks.SignatureHeader), r.Header.Get(webhooks.TimestampHeader),
+			body, time.Now(), tolerance)
+		if err != nil {
+			log.Printf("delivery %s rejected: %s", delivery, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if rand.Float64() < failRate {
+			log.Printf("delivery %s failed on purpose", delivery)
+			http.Error(w, "Failed on purpose", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("delivery %s: %s", delivery, r.Header.Get(webhooks.EventHeader))
+		fmt.Fprintf(out, "%s\n", body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
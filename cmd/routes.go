@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/health"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/metrics"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/repos"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/openapi"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/ratelimit"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/tracing"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"gorm.io/gorm"
+)
+
+// newRouter registers the routes of every repository
+func newRouter(db *gorm.DB, checker *health.Checker, broker *events.Broker, cfg *config.Config) *mux.Router {
+	// Initialize Repositories
+	deletePolicy := trash.Policy(cfg.Trash.AuthorPolicy)
+	authorRepo := repos.NewAuthorRepository(db, deletePolicy)
+	publisherRepo := repos.NewPublisherRepository(db)
+	seriesRepo := repos.NewSeriesRepository(db)
+	workRepo := repos.NewWorkRepository(db)
+	categoryRepo := repos.NewCategoryRepository(db)
+	tagRepo := repos.NewTagRepository(db)
+	bookRepo := repos.NewBookRepository(db)
+	importRepo := repos.NewImportRepository(db)
+	exportRepo := repos.NewExportRepository(db, cfg.Server.WriteTimeout)
+	trashRepo := repos.NewTrashRepository(db, trash.New(db, deletePolicy))
+	auditRepo := repos.NewAuditRepository(db)
+	webhookRepo := repos.NewWebhookRepository(db)
+	graphqlRepo := repos.NewGraphQLRepository(bookRepo, authorRepo)
+	eventRepo := repos.NewEventRepository(broker, repos.StreamOptions{
+		Heartbeat:    cfg.Stream.Heartbeat,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ReplayLimit:  cfg.Stream.ReplayLimit,
+	})
+	// authorRepo.InsertSampleData()
+	// bookRepo.InsertSampleData()
+
+	r := mux.NewRouter()
+
+	r.Use(middleware.Route)
+	r.Use(tracing.Route)
+	r.Use(identify(cfg.Auth.APIKeys, cfg.Auth.AdminKeys))
+	if cfg.RateLimit.Enabled {
+		r.Use(newLimiter(cfg.RateLimit).Middleware)
+	}
+	r.Use(authenticationMiddleware)
+	r.Use(middleware.Deadline(cfg.Server.RequestTimeout, routeDeadlines(cfg.Server.RouteTimeouts)))
+
+	b := r.PathPrefix("/books").Subrouter()
+
+	b.HandleFunc("/", bookRepo.GetAllBooks).Methods(http.MethodGet)
+	b.HandleFunc("/withauthors", bookRepo.GetAllBooksWithAuthorById).Methods(http.MethodGet)
+	b.HandleFunc("/trash", trashRepo.GetDeletedBooks).Methods(http.MethodGet)
+	b.HandleFunc("/{id}", bookRepo.GetBookByID).Methods(http.MethodGet)
+	b.HandleFunc("/{id}/withauthors", bookRepo.GetBooksWithAuthorById).Methods(http.MethodGet)
+	b.HandleFunc("/", bookRepo.AddBook).Methods(http.MethodPost)
+	b.HandleFunc("/find/{name}", bookRepo.FindBookByName).Methods(http.MethodGet)
+	b.HandleFunc("/isbn/{isbn}", bookRepo.GetBookByISBN).Methods(http.MethodGet)
+	b.HandleFunc("/{id}", bookRepo.UpdateBook).Methods(http.MethodPut)
+	b.HandleFunc("/{id}", bookRepo.PatchBook).Methods(http.MethodPatch)
+	b.HandleFunc("/{id}/categories", bookRepo.SetBookCategories).Methods(http.MethodPut)
+	b.HandleFunc("/{id}/tags", bookRepo.SetBookTags).Methods(http.MethodPut)
+	b.HandleFunc("/buy/{id}/{quantity}", bookRepo.BuyBookByID).Methods(http.MethodPatch)
+	b.HandleFunc("/{id}", adminOnly(trashRepo.PurgeBook)).Methods(http.MethodDelete).MatcherFunc(purgeRequested)
+	b.HandleFunc("/{id}", bookRepo.DeleteBook).Methods(http.MethodDelete)
+	b.HandleFunc("/{id}/restore", trashRepo.RestoreBook).Methods(http.MethodPost)
+	r.HandleFunc("/bookcount", bookRepo.GetBooksCount).Methods(http.MethodGet)
+	b.HandleFunc("/lessthen/{pages}", bookRepo.GetBooksByPagesLessThenWithAuthorInformation).Methods(http.MethodGet)
+
+	a := r.PathPrefix("/authors").Subrouter()
+
+	a.HandleFunc("/", authorRepo.GetAllAuthors).Methods(http.MethodGet)
+	a.HandleFunc("/withbooks", authorRepo.GetAllAuthorsWithBooksById).Methods(http.MethodGet)
+	a.HandleFunc("/trash", trashRepo.GetDeletedAuthors).Methods(http.MethodGet)
+	a.HandleFunc("/{id}", authorRepo.GetAuthorByID).Methods(http.MethodGet)
+	a.HandleFunc("/{id}/withbooks", authorRepo.GetAuthorWithBooksById).Methods(http.MethodGet)
+	a.HandleFunc("/", authorRepo.AddAuthor).Methods(http.MethodPost)
+	a.HandleFunc("/find/{name}", authorRepo.FindAuthorByName).Methods(http.MethodGet)
+	a.HandleFunc("/{id}", authorRepo.UpdateAuthor).Methods(http.MethodPut)
+	a.HandleFunc("/{id}", authorRepo.PatchAuthor).Methods(http.MethodPatch)
+	a.HandleFunc("/{id}", adminOnly(trashRepo.PurgeAuthor)).Methods(http.MethodDelete).MatcherFunc(purgeRequested)
+	a.HandleFunc("/{id}", authorRepo.DeleteAuthor).Methods(http.MethodDelete)
+	a.HandleFunc("/{id}/restore", trashRepo.RestoreAuthor).Methods(http.MethodPost)
+	r.HandleFunc("/authorcount", authorRepo.GetAuthorsCount).Methods(http.MethodGet)
+
+	r.HandleFunc("/import", importRepo.Import).Methods(http.MethodPost)
+	r.HandleFunc("/export/books", exportRepo.ExportBooks).Methods(http.MethodGet)
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", checker.Live).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", checker.Ready).Methods(http.MethodGet)
+	// the document is generated from the router once every route is added
+	specEndpoint := r.NewRoute().Name(specRoute).Path(specPath).Methods(http.MethodGet)
+	docsEndpoint := r.NewRoute().Path(docsPath).Methods(http.MethodGet)
+
+	p := r.PathPrefix("/publishers").Subrouter()
+
+	p.HandleFunc("/", publisherRepo.GetAllPublishers).Methods(http.MethodGet)
+	p.HandleFunc("/{id}", publisherRepo.GetPublisherByID).Methods(http.MethodGet)
+	p.HandleFunc("/", publisherRepo.AddPublisher).Methods(http.MethodPost)
+	p.HandleFunc("/find/{name}", publisherRepo.FindPublisherByName).Methods(http.MethodGet)
+	p.HandleFunc("/{id}", publisherRepo.UpdatePublisher).Methods(http.MethodPut)
+	p.HandleFunc("/{id}", publisherRepo.DeletePublisher).Methods(http.MethodDelete)
+
+	s := r.PathPrefix("/series").Subrouter()
+
+	s.HandleFunc("/", seriesRepo.GetAllSeries).Methods(http.MethodGet)
+	s.HandleFunc("/{id}", seriesRepo.GetSeriesByID).Methods(http.MethodGet)
+	s.HandleFunc("/", seriesRepo.AddSeries).Methods(http.MethodPost)
+	s.HandleFunc("/find/{name}", seriesRepo.FindSeriesByName).Methods(http.MethodGet)
+	s.HandleFunc("/{id}", seriesRepo.UpdateSeries).Methods(http.MethodPut)
+	s.HandleFunc("/{id}", seriesRepo.DeleteSeries).Methods(http.MethodDelete)
+
+	c := r.PathPrefix("/categories").Subrouter()
+
+	c.HandleFunc("/", categoryRepo.GetAllCategories).Methods(http.MethodGet)
+	c.HandleFunc("/tree", categoryRepo.GetCategoryTree).Methods(http.MethodGet)
+	c.HandleFunc("/counts", categoryRepo.GetCategoryCounts).Methods(http.MethodGet)
+	c.HandleFunc("/{id}", categoryRepo.GetCategoryByID).Methods(http.MethodGet)
+	c.HandleFunc("/{id}/books", categoryRepo.GetCategoryBooks).Methods(http.MethodGet)
+	c.HandleFunc("/", categoryRepo.AddCategory).Methods(http.MethodPost)
+	c.HandleFunc("/find/{name}", categoryRepo.FindCategoryByName).Methods(http.MethodGet)
+	c.HandleFunc("/{id}", categoryRepo.UpdateCategory).Methods(http.MethodPut)
+	c.HandleFunc("/{id}", categoryRepo.DeleteCategory).Methods(http.MethodDelete)
+
+	t := r.PathPrefix("/tags").Subrouter()
+
+	t.HandleFunc("/", tagRepo.GetAllTags).Methods(http.MethodGet)
+	t.HandleFunc("/{name}/books", tagRepo.GetTagBooks).Methods(http.MethodGet)
+
+	wk := r.PathPrefix("/works").Subrouter()
+
+	wk.HandleFunc("/", workRepo.GetAllWorks).Methods(http.MethodGet)
+	wk.HandleFunc("/{id}", workRepo.GetWorkByID).Methods(http.MethodGet)
+	wk.HandleFunc("/{id}/editions", workRepo.GetWorkEditions).Methods(http.MethodGet)
+	wk.HandleFunc("/", workRepo.AddWork).Methods(http.MethodPost)
+	wk.HandleFunc("/find/{name}", workRepo.FindWorkByName).Methods(http.MethodGet)
+	wk.HandleFunc("/{id}", workRepo.UpdateWork).Methods(http.MethodPut)
+	wk.HandleFunc("/{id}", workRepo.DeleteWork).Methods(http.MethodDelete)
+
+	r.HandleFunc("/events", eventRepo.StreamEvents).Methods(http.MethodGet)
+	r.HandleFunc("/graphql", graphqlRepo.Query).Methods(http.MethodPost)
+	r.HandleFunc("/audit", adminOnly(auditRepo.GetAuditEntries)).Methods(http.MethodGet)
+
+	wh := r.PathPrefix("/webhooks").Subrouter()
+
+	wh.HandleFunc("/", adminOnly(webhookRepo.GetWebhooks)).Methods(http.MethodGet)
+	wh.HandleFunc("/", adminOnly(webhookRepo.AddWebhook)).Methods(http.MethodPost)
+	wh.HandleFunc("/deliveries", adminOnly(webhookRepo.GetDeliveries)).Methods(http.MethodGet)
+	wh.HandleFunc("/deliveries/{id}/retry", adminOnly(webhookRepo.RetryDelivery)).Methods(http.MethodPost)
+	wh.HandleFunc("/{id}", adminOnly(webhookRepo.DeleteWebhook)).Methods(http.MethodDelete)
+
+	spec := apiSpec(r)
+	specEndpoint.Handler(spec)
+	docsEndpoint.Handler(openapi.Docs(spec.Info.Title, specPath))
+	if cfg.Validation.Enabled {
+		// registered last, the middlewares still run in the order they were added
+		r.Use(spec.Validator(cfg.Server.Development()))
+	}
+
+	return r
+}
+
+// routerSpec returns the document r serves at specPath.
+func routerSpec(r *mux.Router) *openapi.Document {
+	return r.Get(specRoute).GetHandler().(*openapi.Document)
+}
+
+// purgeRequested matches the deletes which purge the record for good,
+// ?purge=true
+func purgeRequested(r *http.Request, _ *mux.RouteMatch) bool {
+	purge, _ := strconv.ParseBool(r.URL.Query().Get("purge"))
+	return purge
+}
+
+// newLimiter builds the rate limiter of the validated configuration, the
+// probes and the metrics scraper are never limited.
+func newLimiter(cfg config.RateLimit) *ratelimit.Limiter {
+	limit, _ := ratelimit.ParseLimit(cfg.Default)
+	routes, _ := ratelimit.ParseRoutes(cfg.Routes)
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), limit, routes)
+	limiter.Exempt("/healthz", "/readyz", "/metrics")
+	return limiter
+}
+
+// streams are the routes which run without a deadline unless one is
+// configured for them: the event stream runs until the client or the server
+// goes away, an export until every book is written.
+var streams = []string{"GET /events", "GET /export/books"}
+
+// routeDeadlines parses the validated route timeouts and disables the
+// deadline of the streams the timeouts leave out.
+func routeDeadlines(routes []string) map[string]time.Duration {
+	deadlines := mustParseDeadlines(routes)
+	for _, stream := range streams {
+		_, method := deadlines[stream]
+		_, template := deadlines[strings.SplitN(stream, " ", 2)[1]]
+		if !method && !template {
+			deadlines[stream] = 0
+		}
+	}
+	return deadlines
+}
+
+// mustParseDeadlines parses the validated route timeouts.
+func mustParseDeadlines(routes []string) map[string]time.Duration {
+	deadlines, err := middleware.ParseDeadlines(routes)
+	if err != nil {
+		panic(err)
+	}
+	return deadlines
+}
+
+// newCORS returns the CORS middleware wrapping the router.
+func newCORS(cfg config.CORS) func(http.Handler) http.Handler {
+	return middleware.CORS(middleware.CORSOptions{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   cfg.AllowedHeaders,
+		ExposedHeaders:   cfg.ExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteDeadlines(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		want   map[string]time.Duration
+	}{
+		{"streams have no deadline", nil,
+			map[string]time.Duration{"GET /events": 0, "GET /export/books": 0}},
+		{"configured export deadline", []string{"GET /export/books=1m", "/books/withauthors=3s"},
+			map[string]time.Duration{"GET /events": 0, "GET /export/books": time.Minute, "/books/withauthors": 3 * time.Second}},
+		{"configured for every method", []string{"/export/books=30s"},
+			map[string]time.Duration{"GET /events": 0, "/export/books": 30 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := routeDeadlines(tt.routes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/audit"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/export"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/health"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/importer"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/repos"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/openapi"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/webhooks"
+	"gorm.io/gorm"
+)
+
+const (
+	specPath = "/openapi.json"
+	docsPath = "/docs"
+	// specRoute names the route serving the document
+	specRoute = "openapi"
+)
+
+var (
+	// authorsAccess is the security of the author routes, only the author
+	// token and admin keys are let through
+	authorsAccess = []openapi.SecurityRequirement{{"authorToken": {}}, {"apiKey": {}}}
+	// adminAccess is the security of the routes behind adminOnly
+	adminAccess = []openapi.SecurityRequirement{{"apiKey": {}}}
+	// purgeAccess is the security of deletes which purge with ?purge=true,
+	// only those need an admin key
+	purgeAccess = []openapi.SecurityRequirement{{}, {"apiKey": {}}}
+)
+
+// apiSpec describes the routes of router. The operations are generated
+// from the routes, this adds their summaries, parameters, bodies and
+// responses with the schemas reflected from the entities the handlers
+// encode. Describing a route the router does not serve panics, the tests
+// and `library openapi check` report the routes left undocumented.
+func apiSpec(router *mux.Router) *openapi.Document {
+	doc := apiDoc{openapi.New(openapi.Info{
+		Title:       "Library API",
+		Version:     "1.0.0",
+		Description: "CRUD operations on the books and authors of a library, with their works, publishers, series, categories and tags.",
+		License:     &openapi.License{Name: "MIT", Identifier: "MIT"},
+	})}
+	doc.Define(gorm.DeletedAt{}, openapi.DateTime().OrNull())
+	doc.Components.SecuritySchemes["authorToken"] = &openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer",
+		Description: "The author token, `Authorization: Bearer authortoken`, gives access to the authors.",
+	}
+	doc.Components.SecuritySchemes["apiKey"] = &openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "X-API-Key",
+		Description: "One of `auth.api_keys` or `auth.admin_keys`. Admin keys give access to the authors, the audit trail, the webhooks and purging.",
+	}
+	doc.Tags = []openapi.Tag{
+		{Name: "books", Description: "Editions with their stock, price and ISBN."},
+		{Name: "authors", Description: "Require the author token or an admin key."},
+		{Name: "works"}, {Name: "publishers"}, {Name: "series"}, {Name: "categories"}, {Name: "tags"},
+		{Name: "data", Description: "Bulk import and export."},
+		{Name: "events", Description: "Domain events, the audit trail and webhooks."},
+		{Name: "graphql"},
+		{Name: "operations", Description: "Probes, metrics and this document."},
+	}
+	if err := doc.AddRoutes(router); err != nil {
+		panic(err)
+	}
+	doc.Schema(http_errors.RestError{})
+	doc.Component("ImportReport", importer.Report{})
+	doc.Component("HealthReport", health.Report{})
+	doc.Component("AuditChange", audit.Change{})
+	doc.Component("AuditEntry", audit.Entry{})
+
+	book := doc.Schema(models.Book{})
+	books := openapi.ArrayOf(book)
+	bookWithAuthor := doc.Schema(models.Books{})
+	author := doc.Schema(models.Author{})
+	bookPreload := preloadParam(repos.BookPreloads...)
+	bookFilters := []openapi.Parameter{
+		queryParam("category", "Books in the category or any of its descendants.", openapi.ID()),
+		queryParam("tag", "Books carrying the tag, can be repeated.", openapi.ArrayOf(openapi.String())),
+	}
+
+	doc.add("GET /books/", "listBooks", "books", "Lists the books",
+		params(append([]openapi.Parameter{bookPreload}, bookFilters...)...),
+		reply(http.StatusOK, "The books.", books))
+	doc.add("GET /books/withauthors", "listBooksWithAuthors", "books", "Lists the books with their authors",
+		reply(http.StatusOK, "The books.", openapi.ArrayOf(bookWithAuthor)))
+	doc.add("GET /books/trash", "listDeletedBooks", "books", "Lists the deleted books, most recently deleted first",
+		reply(http.StatusOK, "The deleted books.", books))
+	doc.add("GET /books/{id}", "getBook", "books", "Returns a book",
+		params(idParam("book"), bookPreload, ifNoneMatch),
+		reply(http.StatusOK, "The book, its ETag is its version unless associations are preloaded.", book, "ETag"),
+		notModified)
+	doc.add("GET /books/{id}/withauthors", "getBookWithAuthor", "books", "Returns a book with its author",
+		params(idParam("book")),
+		reply(http.StatusOK, "The book.", bookWithAuthor))
+	doc.add("POST /books/", "createBook", "books", "Creates a book",
+		describe("The ISBN is validated and stored in its hyphenated ISBN-13 form (unhyphenated when the ranges of its registration group are unknown), it must be unique among the books which are not deleted."),
+		jsonBody("The book, read only fields are ignored.", book),
+		reply(http.StatusCreated, "The created book.", book),
+		reply(http.StatusConflict, "A book with the ISBN exists.", errorSchema))
+	doc.add("GET /books/find/{name}", "findBooks", "books", "Finds the books whose title contains name",
+		params(pathParam("name", "Part of the title, case is ignored.", openapi.String())),
+		reply(http.StatusOK, "The books.", books))
+	doc.add("GET /books/isbn/{isbn}", "getBookByISBN", "books", "Returns the book with an ISBN",
+		params(pathParam("isbn", "ISBN-10 or ISBN-13, with or without hyphens.", openapi.String()), bookPreload, ifNoneMatch),
+		reply(http.StatusOK, "The book.", book, "ETag"),
+		notModified)
+	doc.add("PUT /books/{id}", "replaceBook", "books", "Replaces a book",
+		describe("Every writable field must be sent, fields which may be empty are sent as null."),
+		params(idParam("book"), ifMatch(true)),
+		jsonBody("The whole book, read only fields are ignored.", book),
+		reply(http.StatusCreated, "The changed book.", book, "ETag"),
+		preconditions)
+	doc.add("PATCH /books/{id}", "patchBook", "books", "Changes single fields of a book",
+		params(idParam("book"), ifMatch(true)),
+		patchBody,
+		reply(http.StatusCreated, "The changed book.", book, "ETag", "Accept-Patch"),
+		reply(http.StatusConflict, "The JSON Patch does not fit the book.", errorSchema),
+		reply(http.StatusUnsupportedMediaType, "The body is no patch.", errorSchema),
+		preconditions)
+	doc.add("PUT /books/{id}/categories", "setBookCategories", "books", "Replaces the categories of a book",
+		params(idParam("book")),
+		jsonBody("The ids of the categories.", openapi.ArrayOf(openapi.ID())),
+		reply(http.StatusOK, "The book with its categories.", book))
+	doc.add("PUT /books/{id}/tags", "setBookTags", "books", "Replaces the tags of a book",
+		describe("Tag names are trimmed and lower cased, unknown tags are created."),
+		params(idParam("book")),
+		jsonBody("The names of the tags.", openapi.ArrayOf(openapi.String())),
+		reply(http.StatusOK, "The book with its tags.", book))
+	doc.add("PATCH /books/buy/{id}/{quantity}", "buyBook", "books", "Takes copies of a book from the stock",
+		describe("Concurrent purchases are all counted. Clients sending If-Match only buy the version they have seen."),
+		params(idParam("book"), pathParam("quantity", "Number of copies.", openapi.ID()), ifMatch(false)),
+		reply(http.StatusCreated, "The bought book.", book, "ETag"),
+		reply(http.StatusConflict, "The book has fewer copies in stock than asked for.", errorSchema),
+		reply(http.StatusPreconditionFailed, "The book was changed since the version of If-Match.", errorSchema))
+	doc.add("DELETE /books/{id}", "deleteBook", "books", "Moves a book to the trash, or purges it",
+		describe("With ?purge=true the book is deleted for good, which requires an admin key."),
+		params(idParam("book"), purgeParam, deleteIfMatch),
+		reply(http.StatusCreated, "The book was moved to the trash.", openapi.Enum("Deleted")),
+		reply(http.StatusOK, "The book was purged.", openapi.Enum("Purged")),
+		secured(purgeAccess...), forbidden,
+		preconditions, deleteWithoutIfMatch)
+	doc.add("POST /books/{id}/restore", "restoreBook", "books", "Brings back a deleted book",
+		params(idParam("book")),
+		reply(http.StatusOK, "The restored book.", book, "ETag"))
+	doc.add("GET /bookcount", "countBooks", "books", "Counts the books",
+		reply(http.StatusOK, "The number of books.", openapi.Integer().Min(0)))
+	doc.add("GET /books/lessthen/{pages}", "listShortBooks", "books", "Lists the books with fewer pages, with their authors",
+		params(pathParam("pages", "Upper bound of the page count.", openapi.ID())),
+		reply(http.StatusOK, "The books.", openapi.ArrayOf(bookWithAuthor)))
+
+	authors := openapi.ArrayOf(author)
+	authorRoute := func(route, id, summary string, options ...opOption) {
+		doc.add(route, id, "authors", summary, append(options, secured(authorsAccess...))...)
+	}
+	authorRoute("GET /authors/", "listAuthors", "Lists the authors",
+		reply(http.StatusOK, "The authors.", authors))
+	authorRoute("GET /authors/withbooks", "listAuthorsWithBooks", "Lists the authors with their books",
+		reply(http.StatusOK, "The authors.", authors))
+	authorRoute("GET /authors/trash", "listDeletedAuthors", "Lists the deleted authors, most recently deleted first",
+		reply(http.StatusOK, "The deleted authors.", authors))
+	authorRoute("GET /authors/{id}", "getAuthor", "Returns an author",
+		params(idParam("author"), ifNoneMatch),
+		reply(http.StatusOK, "The author.", author, "ETag"),
+		notModified)
+	authorRoute("GET /authors/{id}/withbooks", "getAuthorWithBooks", "Returns an author with the books",
+		params(idParam("author")),
+		reply(http.StatusOK, "The author.", author))
+	authorRoute("POST /authors/", "createAuthor", "Creates an author",
+		jsonBody("The author, read only fields are ignored.", author),
+		reply(http.StatusCreated, "The created author.", author))
+	authorRoute("GET /authors/find/{name}", "findAuthors", "Finds the authors whose name contains name",
+		params(pathParam("name", "Part of the name, case is ignored.", openapi.String())),
+		reply(http.StatusOK, "The authors.", authors))
+	authorRoute("PUT /authors/{id}", "replaceAuthor", "Replaces an author",
+		params(idParam("author"), ifMatch(true)),
+		jsonBody("The whole author, read only fields are ignored.", author),
+		reply(http.StatusCreated, "The changed author.", author, "ETag"),
+		preconditions)
+	authorRoute("PATCH /authors/{id}", "patchAuthor", "Changes single fields of an author",
+		params(idParam("author"), ifMatch(true)),
+		patchBody,
+		reply(http.StatusCreated, "The changed author.", author, "ETag", "Accept-Patch"),
+		reply(http.StatusConflict, "The JSON Patch does not fit the author.", errorSchema),
+		reply(http.StatusUnsupportedMediaType, "The body is no patch.", errorSchema),
+		preconditions)
+	authorRoute("DELETE /authors/{id}", "deleteAuthor", "Moves an author to the trash, or purges it",
+		describe("The books of the author are handled according to `trash.author_policy`. With ?purge=true the author is deleted for good, which requires an admin key."),
+		params(idParam("author"), purgeParam, deleteIfMatch),
+		reply(http.StatusOK, "The author was moved to the trash or purged.", openapi.Enum("Deleted", "Purged")),
+		reply(http.StatusConflict, "The author still has books and the policy blocks the delete.", errorSchema),
+		forbidden, preconditions, deleteWithoutIfMatch)
+	authorRoute("POST /authors/{id}/restore", "restoreAuthor", "Brings back a deleted author",
+		params(idParam("author"), queryParam("books", "Also restore the deleted books of the author.", openapi.Boolean())),
+		reply(http.StatusOK, "The restored author.", author, "ETag"))
+	doc.add("GET /authorcount", "countAuthors", "authors", "Counts the authors",
+		reply(http.StatusOK, "The number of authors.", openapi.Integer().Min(0)))
+
+	doc.add("POST /import", "importRecords", "data", "Imports books or authors",
+		describe("Every row is validated and written in batches, the report lists the outcome of every row. The format is read from ?format or the Content-Type."),
+		params(
+			queryParam("entity", "What the rows are.", openapi.Enum(importer.Books, importer.Authors)),
+			queryParam("format", "Format of the body, read from the Content-Type when missing.", openapi.Enum(importer.CSV, importer.JSONLines, importer.JSON)),
+			queryParam("on_conflict", "What happens to rows matching an existing record.", openapi.Enum(importer.Skip, importer.Update)),
+			queryParam("dry_run", "Validate and write every row, then roll everything back.", openapi.Boolean()),
+			queryParam("create_authors", "Create the authors referenced by name which do not exist.", openapi.Boolean()),
+			queryParam("batch_size", "Number of rows written in one transaction.", openapi.Integer().Min(1).Max(10000)),
+		),
+		body("The rows.", map[string]*openapi.Schema{
+			"text/csv":             openapi.String(),
+			"application/x-ndjson": openapi.String(),
+			"application/json":     openapi.ArrayOf(openapi.Object(nil)),
+		}),
+		reply(http.StatusOK, "Every row was imported.", doc.Schema(importer.Report{})),
+		reply(http.StatusUnprocessableEntity, "The input broke off, the report lists the rows read so far.", doc.Schema(importer.Report{})))
+	doc.add("GET /export/books", "exportBooks", "data", "Exports the books",
+		describe("Rows are streamed, an error after the first row truncates the document."),
+		params(append([]openapi.Parameter{
+			queryParam("format", "Format of the document.", openapi.Enum(export.CSV, export.JSONLines, export.MARCXML)),
+		}, bookFilters...)...),
+		replyAs(http.StatusOK, "The books.", map[string]*openapi.Schema{
+			"text/csv":                openapi.String(),
+			"application/x-ndjson":    openapi.String(),
+			"application/marcxml+xml": openapi.String(),
+		}, "Content-Disposition"))
+
+	doc.add("GET /metrics", "metrics", "operations", "Prometheus metrics",
+		replyAs(http.StatusOK, "The metrics in the Prometheus text format.", map[string]*openapi.Schema{"text/plain": openapi.String()}))
+	doc.add("GET /healthz", "live", "operations", "Liveness probe",
+		reply(http.StatusOK, "The process is up.", doc.Schema(health.Report{})))
+	doc.add("GET /readyz", "ready", "operations", "Readiness probe",
+		reply(http.StatusOK, "Every dependency is available.", doc.Schema(health.Report{})),
+		reply(http.StatusServiceUnavailable, "A dependency failed or the server is shutting down.", doc.Schema(health.Report{})))
+	doc.add("GET "+specPath, "openAPI", "operations", "This document",
+		replyAs(http.StatusOK, "The OpenAPI document.", map[string]*openapi.Schema{"application/json": openapi.Object(nil)}))
+	doc.add("GET "+docsPath, "docs", "operations", "Browses this document",
+		replyAs(http.StatusOK, "The documentation page.", map[string]*openapi.Schema{"text/html": openapi.String()}))
+
+	doc.crud("publisher", "publishers", doc.Schema(models.Publisher{}), preloadParam("Books"))
+	doc.crud("series", "series", doc.Schema(models.Series{}), preloadParam("Works"))
+
+	category := doc.Schema(models.Category{})
+	categories := openapi.ArrayOf(category)
+	doc.add("GET /categories/tree", "getCategoryTree", "categories", "Returns the category hierarchy",
+		describe("Every node carries the number of books directly in it and in its whole subtree."),
+		reply(http.StatusOK, "The root categories with their descendants.", categories))
+	doc.add("GET /categories/counts", "countCategoryBooks", "categories", "Lists the categories with their book counts",
+		reply(http.StatusOK, "The categories.", categories))
+	doc.add("GET /categories/{id}/books", "listCategoryBooks", "categories", "Lists the books of a category and its descendants",
+		params(idParam("category"), bookPreload),
+		reply(http.StatusOK, "The books.", books))
+	doc.crud("category", "categories", category, preloadParam("Children"))
+
+	doc.add("GET /tags/", "listTags", "tags", "Lists the tags with their number of books",
+		reply(http.StatusOK, "The tags.", openapi.ArrayOf(doc.Schema(repos.TagCount{}))))
+	doc.add("GET /tags/{name}/books", "listTagBooks", "tags", "Lists the books carrying a tag",
+		params(pathParam("name", "Name of the tag, case is ignored.", openapi.String()), bookPreload),
+		reply(http.StatusOK, "The books.", books))
+
+	work := doc.Schema(models.Work{})
+	doc.add("GET /works/{id}/editions", "listWorkEditions", "works", "Lists the editions of a work",
+		params(idParam("work"), preloadParam("Publisher")),
+		reply(http.StatusOK, "The books which are editions of the work.", books))
+	doc.crud("work", "works", work, preloadParam(repos.WorkPreloads...))
+
+	doc.add("GET /events", "streamEvents", "events", "Streams the domain events",
+		describe("Events are sent as server-sent events with their outbox id as id, their type as event and their json as data. Clients which missed more than `stream.replay_limit` events get a reset event instead and should reload."),
+		params(
+			queryParam("entity", "Only the events of books or of authors.", openapi.Enum("book", "author")),
+			listParam("book", "Only the events of the books.", openapi.ID()),
+			listParam("type", "Only the events of the types.", openapi.Enum(events.Types...)),
+			headerParam("Last-Event-ID", "Id of the last event received, the missed events are sent first.", false, openapi.Integer().Min(0)),
+		),
+		replyAs(http.StatusOK, "The event stream.", map[string]*openapi.Schema{"text/event-stream": openapi.String()}),
+		reply(http.StatusServiceUnavailable, "The server is shutting down.", errorSchema))
+	doc.add("POST /graphql", "graphql", "graphql", "Runs a GraphQL query",
+		describe("The schema serves the books and authors with their relations. Failed fields are listed in errors with the status the REST api would answer in extensions.status."),
+		jsonBody("The GraphQL request.", openapi.Object(map[string]*openapi.Schema{
+			"query":         openapi.String(),
+			"operationName": openapi.String(),
+			"variables":     openapi.Object(nil).OrNull(),
+		}, "query")),
+		reply(http.StatusOK, "The result, with the errors of failed fields.", openapi.Object(map[string]*openapi.Schema{
+			"data": openapi.Any(),
+			"errors": openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{
+				"message":    openapi.String(),
+				"path":       openapi.ArrayOf(openapi.Any()),
+				"extensions": openapi.Object(nil),
+			})),
+		})))
+	doc.add("GET /audit", "listAuditEntries", "events", "Lists the audit trail, newest first",
+		params(append([]openapi.Parameter{
+			queryParam("entity", "Only the changes of the entity, e.g. book.", openapi.String()),
+			queryParam("id", "Only the changes of the record, requires entity.", openapi.ID()),
+			queryParam("action", "Only the changes of the action.", openapi.Enum(audit.Actions...)),
+			queryParam("actor", "Only the changes of the principal.", openapi.String()),
+			queryParam("request_id", "Only the changes of the request.", openapi.String()),
+			queryParam("since", "Only the changes from the time on.", openapi.DateTime()),
+			queryParam("until", "Only the changes before the time.", openapi.DateTime()),
+		}, pagingParams()...)...),
+		reply(http.StatusOK, "A page of the entries.", openapi.ArrayOf(doc.Schema(audit.Entry{})), "X-Total-Count"),
+		secured(adminAccess...), forbidden)
+
+	webhook := doc.Schema(webhooks.Webhook{})
+	delivery := doc.Schema(webhooks.Delivery{})
+	webhookRoute := func(route, id, summary string, options ...opOption) {
+		doc.add(route, id, "events", summary, append(options, secured(adminAccess...), forbidden)...)
+	}
+	webhookRoute("GET /webhooks/", "listWebhooks", "Lists the webhooks",
+		reply(http.StatusOK, "The webhooks.", openapi.ArrayOf(webhook)))
+	webhookRoute("POST /webhooks/", "createWebhook", "Registers a webhook",
+		describe("The secret signs every delivery, one is generated unless it is sent. It is only sent back in this response."),
+		jsonBody("The webhook.", openapi.Object(map[string]*openapi.Schema{
+			"url":    openapi.String().Describe("Absolute http or https url."),
+			"events": openapi.ArrayOf(openapi.Enum(events.Types...)).Describe("The event types, all events when empty."),
+			"secret": openapi.String(),
+		}, "url")),
+		reply(http.StatusCreated, "The registered webhook with its secret.", doc.Schema(repos.RegisteredWebhook{})))
+	webhookRoute("GET /webhooks/deliveries", "listDeliveries", "Lists the deliveries, most recent first",
+		params(append([]openapi.Parameter{
+			queryParam("status", "Only the deliveries with the status, dead are the dead letters.", openapi.Enum(webhooks.Statuses...)),
+			queryParam("webhook", "Only the deliveries of the webhook.", openapi.ID()),
+		}, pagingParams()...)...),
+		reply(http.StatusOK, "A page of the deliveries with their events.", openapi.ArrayOf(delivery), "X-Total-Count"))
+	webhookRoute("POST /webhooks/deliveries/{id}/retry", "retryDelivery", "Sends a delivery again",
+		describe("Dead letters get all their attempts back."),
+		params(idParam("delivery")),
+		reply(http.StatusOK, "The delivery.", delivery),
+		reply(http.StatusConflict, "The delivery was already delivered.", errorSchema))
+	webhookRoute("DELETE /webhooks/{id}", "deleteWebhook", "Removes a webhook with its deliveries",
+		params(idParam("webhook")),
+		reply(http.StatusOK, "The webhook was removed.", openapi.Enum("Deleted")))
+
+	return doc.Document
+}
+
+// crud describes the list, get, create, find, update and delete routes of
+// the entities under /<collection>/.
+func (doc apiDoc) crud(entity, collection string, schema *openapi.Schema, preload openapi.Parameter) {
+	name := strings.ToUpper(entity[:1]) + entity[1:]
+	plural := strings.ToUpper(collection[:1]) + collection[1:]
+	list := openapi.ArrayOf(schema)
+	base := "/" + collection + "/"
+
+	doc.add("GET "+base, "list"+plural, collection, "Lists the "+collection,
+		params(preload),
+		reply(http.StatusOK, "The "+collection+".", list))
+	doc.add("GET "+base+"{id}", "get"+name, collection, "Returns a "+entity,
+		params(idParam(entity), preload),
+		reply(http.StatusOK, "The "+entity+".", schema))
+	doc.add("POST "+base, "create"+name, collection, "Creates a "+entity,
+		jsonBody("The "+entity+", read only fields are ignored.", schema),
+		reply(http.StatusCreated, "The created "+entity+".", schema))
+	doc.add("GET "+base+"find/{name}", "find"+plural, collection, "Finds the "+collection+" whose name contains name",
+		params(pathParam("name", "Part of the name, case is ignored.", openapi.String())),
+		reply(http.StatusOK, "The "+collection+".", list))
+	doc.add("PUT "+base+"{id}", "update"+name, collection, "Updates a "+entity,
+		params(idParam(entity)),
+		jsonBody("The "+entity+".", schema),
+		reply(http.StatusOK, "The updated "+entity+".", schema))
+	doc.add("DELETE "+base+"{id}", "delete"+name, collection, "Deletes a "+entity,
+		params(idParam(entity)),
+		reply(http.StatusOK, "The "+entity+" was deleted.", openapi.Enum("Deleted")))
+}
+
+// apiDoc adds the operations of apiSpec.
+type apiDoc struct {
+	*openapi.Document
+}
+
+// opOption fills in an operation of apiSpec.
+type opOption func(op *openapi.Operation)
+
+// errorSchema is the RestError every failed request is answered with.
+var errorSchema = openapi.Ref("RestError")
+
+// add describes the operation generated for the route, "METHOD /path".
+// Every operation may fail with a RestError.
+func (doc apiDoc) add(route, id, tag, summary string, options ...opOption) {
+	method, path, _ := strings.Cut(route, " ")
+	op, ok := doc.Operation(method, path)
+	if !ok {
+		panic(fmt.Sprintf("apiSpec: %s is not served", route))
+	}
+	if op.Summary != "" {
+		panic(fmt.Sprintf("apiSpec: %s is described twice", route))
+	}
+	op.OperationID = id
+	op.Summary = summary
+	op.Tags = []string{tag}
+	for _, option := range options {
+		option(op)
+	}
+	op.Responses["default"] = &openapi.Response{
+		Description: "The request failed, e.g. 400 for invalid input or records which do not exist, 408 when it took too long or 429 when the client exceeded its rate limit.",
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: errorSchema}},
+	}
+}
+
+func describe(description string) opOption {
+	return func(op *openapi.Operation) {
+		op.Description = description
+	}
+}
+
+// params describes the parameters, generated path parameters are replaced.
+func params(parameters ...openapi.Parameter) opOption {
+	return func(op *openapi.Operation) {
+		for _, p := range parameters {
+			op.SetParameter(p)
+		}
+	}
+}
+
+// jsonBody is a required json request body.
+func jsonBody(description string, schema *openapi.Schema) opOption {
+	return body(description, map[string]*openapi.Schema{"application/json": schema})
+}
+
+// body is a required request body of one of the media types.
+func body(description string, content map[string]*openapi.Schema) opOption {
+	return func(op *openapi.Operation) {
+		op.RequestBody = &openapi.RequestBody{Description: description, Required: true, Content: mediaTypes(content)}
+	}
+}
+
+// patchBody is the body of the PATCH routes, a JSON Merge Patch or a JSON
+// Patch.
+var patchBody = body("A JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902), read only fields cannot be patched.", map[string]*openapi.Schema{
+	"application/merge-patch+json": openapi.Object(nil),
+	"application/json-patch+json": openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{
+		"op":    openapi.Enum("add", "remove", "replace", "move", "copy", "test"),
+		"path":  openapi.String(),
+		"from":  openapi.String(),
+		"value": openapi.Any(),
+	}, "op", "path")),
+})
+
+// reply is a json response with the headers named in headers.
+func reply(status int, description string, schema *openapi.Schema, headers ...string) opOption {
+	return replyAs(status, description, map[string]*openapi.Schema{"application/json": schema}, headers...)
+}
+
+// replyAs is a response of one of the media types.
+func replyAs(status int, description string, content map[string]*openapi.Schema, headers ...string) opOption {
+	return func(op *openapi.Operation) {
+		response := &openapi.Response{Description: description, Content: mediaTypes(content)}
+		for _, name := range headers {
+			if response.Headers == nil {
+				response.Headers = make(map[string]*openapi.Header)
+			}
+			response.Headers[name] = responseHeaders[name]
+		}
+		op.Responses[strconv.Itoa(status)] = response
+	}
+}
+
+var responseHeaders = map[string]*openapi.Header{
+	"ETag":                {Description: "The version of the record, send it as If-Match to change it.", Schema: openapi.String()},
+	"X-Total-Count":       {Description: "The number of matching records on all pages.", Schema: openapi.Integer().Min(0)},
+	"Accept-Patch":        {Description: "The patch formats the route accepts.", Schema: openapi.String()},
+	"Content-Disposition": {Description: "The file name of the export.", Schema: openapi.String()},
+}
+
+// notModified is the answer to a conditional GET for the current version.
+var notModified = replyAs(http.StatusNotModified, "The record did not change since the version of If-None-Match.", nil, "ETag")
+
+// preconditions are the answers to writes sent for an older version or
+// without If-Match.
+func preconditions(op *openapi.Operation) {
+	reply(http.StatusPreconditionFailed, "The record was changed since the version of If-Match.", errorSchema)(op)
+	if p, ok := op.Parameter("header", "If-Match"); ok && p.Required {
+		reply(http.StatusPreconditionRequired, "If-Match is missing.", errorSchema)(op)
+	}
+}
+
+// secured restricts the route to the clients meeting one of requirements,
+// the others are answered in plain text.
+func secured(requirements ...openapi.SecurityRequirement) opOption {
+	return func(op *openapi.Operation) {
+		op.Security = requirements
+		replyAs(http.StatusUnauthorized, "The request carries no valid credentials.", plainText)(op)
+	}
+}
+
+// forbidden is the answer of adminOnly to clients without admin key.
+var forbidden = replyAs(http.StatusForbidden, "The key is no admin key.", plainText)
+
+var plainText = map[string]*openapi.Schema{"text/plain": openapi.String()}
+
+func mediaTypes(content map[string]*openapi.Schema) map[string]openapi.MediaType {
+	if content == nil {
+		return nil
+	}
+	types := make(map[string]openapi.MediaType, len(content))
+	for mediaType, schema := range content {
+		types[mediaType] = openapi.MediaType{Schema: schema}
+	}
+	return types
+}
+
+func pathParam(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "path", Description: description, Required: true, Schema: schema}
+}
+
+func idParam(entity string) openapi.Parameter {
+	return pathParam("id", fmt.Sprintf("Id of the %s.", entity), openapi.ID())
+}
+
+func queryParam(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// listParam is a query parameter taking a comma separated list.
+func listParam(name, description string, items *openapi.Schema) openapi.Parameter {
+	explode := false
+	p := queryParam(name, description, openapi.ArrayOf(items))
+	p.Explode = &explode
+	return p
+}
+
+func headerParam(name, description string, required bool, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "header", Description: description, Required: required, Schema: schema}
+}
+
+// preloadParam is the ?preload= of the associations in allowed.
+func preloadParam(allowed ...string) openapi.Parameter {
+	return listParam("preload", "Associations to include in the response: "+strings.Join(allowed, ", ")+". Responses with associations carry no ETag.",
+		openapi.Enum(allowed...))
+}
+
+var purgeParam = queryParam("purge", "Delete the record for good instead of moving it to the trash, requires an admin key.", openapi.Boolean())
+
+var ifNoneMatch = headerParam("If-None-Match", "The ETag of the version the client has, 304 is sent when it is current.", false, openapi.String())
+
+// deleteIfMatch is the If-Match of the deletes, purging needs none.
+var deleteIfMatch = headerParam("If-Match", "The ETag of the version the delete was made for, required unless purging.", false, openapi.String())
+
+var deleteWithoutIfMatch = reply(http.StatusPreconditionRequired, "If-Match is missing and the record is not purged.", errorSchema)
+
+func ifMatch(required bool) openapi.Parameter {
+	return headerParam("If-Match", "The ETag of the version the change was made for.", required, openapi.String())
+}
+
+func pagingParams() []openapi.Parameter {
+	return []openapi.Parameter{
+		queryParam("page", "The page, from 1.", openapi.Integer().Min(1)),
+		queryParam("per_page", "The size of the page.", openapi.Integer().Min(1).Max(500)),
+	}
+}
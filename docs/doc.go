@@ -1,30 +0,0 @@
-// Package classification LibraryAPI.
-//
-// Documentation of our LibraryAPI. With this RestAPI you can make CRUD operations on Books and Authors.
-//
-// Terms Of Service:
-//
-// there are no TOS at this moment, use at your own risk we take no responsibility
-//
-//     Schemes: http, https
-//     BasePath: /
-//     Version: 1.0.0
-//     Host: localhost
-//     License: MIT http://opensource.org/licenses/MIT
-//     ontact: Mert Şakar<mertsakar@hotmail.com> http://horzu.github.io
-//
-//     Consumes:
-//     - application/json
-//
-//     Produces:
-//     - application/json
-//
-//     Security:
-//     - basic
-//
-//    SecurityDefinitions:
-//    basic:
-//      type: basic
-//
-// swagger:meta
-package docs
\ No newline at end of file
@@ -0,0 +1,110 @@
+// Package audit records who created, changed or deleted which record. Every
+// statement run through gorm on an entity with a single numeric primary key
+// is audited, new entities need no extra code.
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+)
+
+// Actions recorded in entries.
+const (
+	Create  = "create"
+	Update  = "update"
+	Delete  = "delete"
+	Restore = "restore"
+	Purge   = "purge"
+)
+
+// Actions lists every action an entry can have.
+var Actions = []string{Create, Update, Delete, Restore, Purge}
+
+const (
+	// SystemActor changes records outside of requests, e.g. the import
+	// command or the scheduled purge of the trash.
+	SystemActor = "system"
+	// AnonymousActor sends requests without credentials.
+	AnonymousActor = "anonymous"
+)
+
+// Entry is the audit record of a single change of a record.
+type Entry struct {
+	ID uint `json:"id" gorm:"primaryKey"`
+	// CreatedAt is when the change was written.
+	CreatedAt time.Time `json:"timestamp" gorm:"not null;index"`
+	// Entity is the lower case name of the changed entity, e.g. book.
+	Entity   string `json:"entity" gorm:"not null;index:idx_audit_entries_record,priority:1"`
+	EntityID uint   `json:"entityId" gorm:"not null;index:idx_audit_entries_record,priority:2"`
+	Action   string `json:"action" gorm:"not null"`
+	// Actor is the principal of the request, e.g. apikey:3f2a9c1b.
+	Actor     string `json:"actor" gorm:"not null;index"`
+	RequestID string `json:"requestId,omitempty"`
+	// Changes holds the columns which changed, creates only have new values
+	// and deletes only old ones.
+	Changes Changes `json:"changes" gorm:"type:jsonb"`
+}
+
+func (Entry) TableName() string {
+	return "audit_entries"
+}
+
+// Change is the old and the new json value of a column.
+type Change struct {
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+// Changes maps column names to their change.
+type Changes map[string]Change
+
+// Value stores the changes as a json document.
+func (c Changes) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	doc, err := json.Marshal(c)
+	return string(doc), err
+}
+
+// Scan reads the changes from a json document.
+func (c *Changes) Scan(src interface{}) error {
+	switch doc := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(doc, c)
+	case string:
+		return json.Unmarshal([]byte(doc), c)
+	default:
+		return fmt.Errorf("audit changes cannot be read from %T", src)
+	}
+}
+
+// actor is the principal changing records with ctx.
+func actor(ctx context.Context) string {
+	if middleware.RequestIDFromContext(ctx) == "" {
+		return SystemActor
+	}
+	if principal, ok := middleware.PrincipalFromContext(ctx); ok {
+		return principal.String()
+	}
+	return AnonymousActor
+}
+
+// ParseAction checks the name of an action.
+func ParseAction(name string) (string, error) {
+	for _, action := range Actions {
+		if name == action {
+			return action, nil
+		}
+	}
+	return "", fmt.Errorf("unknown action %q, use %s", name, strings.Join(Actions, ", "))
+}
@@ -0,0 +1,297 @@
+package audit
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+const oldRowsKey = "audit:old_rows"
+
+// ignored columns change with every write and are left out of updates.
+var ignored = map[string]bool{"updated_at": true}
+
+// GormPlugin writes an entry for every record created, updated or deleted
+// through gorm, in the transaction of the statement. Updates and deletes
+// read the affected rows before and after the statement to compare them.
+// Statements run with Exec are not audited. It is installed with db.Use.
+type GormPlugin struct {
+	// Ignored lists the tables of internal records whose changes are not
+	// audited, e.g. the outbox.
+	Ignored []string
+}
+
+func (GormPlugin) Name() string {
+	return "audit"
+}
+
+func (p GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().After("gorm:create").Register("audit:after_create", p.afterCreate),
+		callbacks.Update().Before("gorm:update").Register("audit:before_update", p.readOldRows),
+		callbacks.Update().After("gorm:update").Register("audit:after_update", afterChange(Update)),
+		callbacks.Delete().Before("gorm:delete").Register("audit:before_delete", p.readOldRows),
+		callbacks.Delete().After("gorm:delete").Register("audit:after_delete", afterChange(Delete)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audited reports whether the statement of db changes an audited entity.
+func (p GormPlugin) audited(db *gorm.DB) bool {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.DryRun || stmt.Schema.Table == (Entry{}).TableName() {
+		return false
+	}
+	for _, table := range p.Ignored {
+		if stmt.Schema.Table == table {
+			return false
+		}
+	}
+	// join tables and entities with composite keys have no single id
+	primary := stmt.Schema.PrioritizedPrimaryField
+	if primary == nil || len(stmt.Schema.PrimaryFields) != 1 {
+		return false
+	}
+	switch primary.FieldType.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func (p GormPlugin) afterCreate(db *gorm.DB) {
+	if db.Error != nil || db.RowsAffected == 0 || !p.audited(db) {
+		return
+	}
+	stmt := db.Statement
+	primary := stmt.Schema.PrioritizedPrimaryField
+
+	var records []reflect.Value
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct:
+		records = append(records, stmt.ReflectValue)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			records = append(records, reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	}
+	// upserts which skipped rows do not tell which ones they inserted
+	if _, upsert := stmt.Clauses["ON CONFLICT"]; upsert && db.RowsAffected != int64(len(records)) {
+		return
+	}
+
+	var entries []Entry
+	for _, record := range records {
+		id, zero := primary.ValueOf(stmt.Context, record)
+		if zero {
+			continue
+		}
+		changes := Changes{}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			value, _ := field.ValueOf(stmt.Context, record)
+			changes[field.DBName] = Change{New: marshal(value)}
+		}
+		entries = append(entries, newEntry(db, Create, id, changes))
+	}
+	write(db, entries)
+}
+
+// readOldRows keeps the rows an update or delete is about to change.
+func (p GormPlugin) readOldRows(db *gorm.DB) {
+	if db.Error != nil || !p.audited(db) {
+		return
+	}
+	stmt := db.Statement
+
+	query := rows(db)
+	conditions := false
+	if where, ok := stmt.Clauses["WHERE"]; ok {
+		if expr, ok := where.Expression.(clause.Where); ok && len(expr.Exprs) > 0 {
+			query = query.Clauses(clause.Where{Exprs: expr.Exprs})
+			conditions = true
+		}
+	}
+	if ids := primaryKeys(db); len(ids) > 0 {
+		query = query.Where(clause.IN{Column: clause.PrimaryColumn, Values: ids})
+		conditions = true
+	}
+	// gorm refuses such statements, nothing is changed
+	if !conditions && !stmt.AllowGlobalUpdate {
+		return
+	}
+	if stmt.Unscoped {
+		query = query.Unscoped()
+	}
+
+	var old []map[string]interface{}
+	if err := query.Find(&old).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	db.InstanceSet(oldRowsKey, old)
+}
+
+// afterChange compares the rows read by readOldRows with their state after
+// the statement. Rows which are gone were purged, rows whose deleted_at was
+// set or cleared were deleted or restored.
+func afterChange(action string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(oldRowsKey)
+		if !ok || db.Error != nil || db.RowsAffected == 0 {
+			return
+		}
+		old := value.([]map[string]interface{})
+		if len(old) == 0 {
+			return
+		}
+		primary := db.Statement.Schema.PrioritizedPrimaryField.DBName
+
+		ids := make([]interface{}, 0, len(old))
+		for _, row := range old {
+			ids = append(ids, row[primary])
+		}
+		var current []map[string]interface{}
+		if err := rows(db).Unscoped().Where(clause.IN{Column: clause.PrimaryColumn, Values: ids}).
+			Find(&current).Error; err != nil {
+			db.AddError(fmt.Errorf("audit: %w", err))
+			return
+		}
+		byID := make(map[string]map[string]interface{}, len(current))
+		for _, row := range current {
+			byID[fmt.Sprint(row[primary])] = row
+		}
+
+		var entries []Entry
+		for _, before := range old {
+			after, exists := byID[fmt.Sprint(before[primary])]
+			rowAction, changes := action, Changes{}
+			switch {
+			case !exists:
+				// entities without soft deletes are always deleted for good
+				if db.Statement.Schema.LookUpField("deleted_at") != nil {
+					rowAction = Purge
+				}
+				for column, value := range before {
+					changes[column] = Change{Old: marshal(value)}
+				}
+			case before["deleted_at"] == nil && after["deleted_at"] != nil:
+				rowAction = Delete
+				changes = diff(before, after)
+			case before["deleted_at"] != nil && after["deleted_at"] == nil:
+				rowAction = Restore
+				changes = diff(before, after)
+			default:
+				// deletes which left the row as it was did not match it
+				if action == Delete {
+					continue
+				}
+				if changes = diff(before, after); len(changes) == 0 {
+					continue
+				}
+			}
+			entries = append(entries, newEntry(db, rowAction, before[primary], changes))
+		}
+		write(db, entries)
+	}
+}
+
+// rows starts a query for the rows of the statement's entity in its
+// transaction, always on the primary database.
+func rows(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+		Model(reflect.New(db.Statement.Schema.ModelType).Interface()).
+		Clauses(dbresolver.Write)
+}
+
+// primaryKeys returns the non-zero primary keys of the records the
+// statement was called with.
+func primaryKeys(db *gorm.DB) []interface{} {
+	stmt := db.Statement
+	primary := stmt.Schema.PrioritizedPrimaryField
+
+	var ids []interface{}
+	add := func(record reflect.Value) {
+		if id, zero := primary.ValueOf(stmt.Context, record); !zero {
+			ids = append(ids, id)
+		}
+	}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct:
+		add(stmt.ReflectValue)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			add(reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	}
+	return ids
+}
+
+// diff returns the columns whose values differ between the rows.
+func diff(before, after map[string]interface{}) Changes {
+	changes := Changes{}
+	for column, value := range after {
+		if ignored[column] {
+			continue
+		}
+		old, changed := marshal(before[column]), marshal(value)
+		if string(old) != string(changed) {
+			changes[column] = Change{Old: old, New: changed}
+		}
+	}
+	return changes
+}
+
+func marshal(value interface{}) json.RawMessage {
+	if valuer, ok := value.(driver.Valuer); ok {
+		value, _ = valuer.Value()
+	}
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	doc, err := json.Marshal(value)
+	if err != nil {
+		return json.RawMessage(strconv.Quote(fmt.Sprint(value)))
+	}
+	return doc
+}
+
+func newEntry(db *gorm.DB, action string, id interface{}, changes Changes) Entry {
+	ctx := db.Statement.Context
+	entityID, _ := strconv.ParseUint(fmt.Sprint(id), 10, 64)
+	return Entry{
+		Entity:    strings.ToLower(db.Statement.Schema.Name),
+		EntityID:  uint(entityID),
+		Action:    action,
+		Actor:     actor(ctx),
+		RequestID: middleware.RequestIDFromContext(ctx),
+		Changes:   changes,
+	}
+}
+
+// write stores the entries in the statement's transaction, so they are
+// rolled back with it. Failing to audit a change fails the change.
+func write(db *gorm.DB, entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+		Clauses(dbresolver.Write).Create(&entries).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+	}
+}
@@ -0,0 +1,544 @@
+// Package config loads the configuration of the api from defaults, an
+// optional YAML file, environment variables and command line flags, each
+// source overriding the previous ones.
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/ratelimit"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+)
+
+// Config is the whole configuration of the api. Every field can be set in
+// the YAML file under its yaml key, with the environment variable in its env
+// tag and with the command line flag in its flag tag. Fields tagged secret
+// are left out when the configuration is printed.
+type Config struct {
+	Server     Server     `yaml:"server"`
+	Database   Database   `yaml:"database"`
+	Log        Log        `yaml:"log"`
+	Tracing    Tracing    `yaml:"tracing"`
+	CORS       CORS       `yaml:"cors"`
+	Auth       Auth       `yaml:"auth"`
+	RateLimit  RateLimit  `yaml:"rate_limit"`
+	Trash      Trash      `yaml:"trash"`
+	Webhooks   Webhooks   `yaml:"webhooks"`
+	Stream     Stream     `yaml:"stream"`
+	GRPC       GRPC       `yaml:"grpc"`
+	Validation Validation `yaml:"validation"`
+}
+
+type Server struct {
+	Addr            string        `yaml:"addr" env:"SERVER_ADDR" flag:"addr" usage:"address the api listens on"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" flag:"read-timeout" usage:"maximum duration for reading a request"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" flag:"write-timeout" usage:"maximum duration for writing a response"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" flag:"idle-timeout" usage:"how long idle keep-alive connections are kept"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long running requests and background work may take once shutting down"`
+	DrainDelay      time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY" flag:"drain-delay" usage:"how long requests are still served after readiness failed on shutdown"`
+	// RequestTimeout cancels the queries of requests running longer, it
+	// must be shorter than WriteTimeout so the error can still be sent.
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"SERVER_REQUEST_TIMEOUT" flag:"request-timeout" usage:"deadline of a request's database queries, 0 disables it"`
+	// RouteTimeouts are deadlines of single routes written as
+	// [METHOD ]template=duration, e.g. GET /export/books=0.
+	RouteTimeouts []string `yaml:"route_timeouts" env:"SERVER_ROUTE_TIMEOUTS" flag:"route-timeouts" usage:"comma separated [METHOD ]route=duration overrides of the request timeout"`
+	// Mode is production or development, development turns on checks
+	// which cost too much for production like the validation of responses.
+	Mode string `yaml:"mode" env:"SERVER_MODE" flag:"mode" usage:"production or development"`
+}
+
+type Database struct {
+	Host     string `yaml:"host" env:"LIBRARY_DB_HOST" flag:"db-host" usage:"postgres host"`
+	Port     int    `yaml:"port" env:"LIBRARY_DB_PORT" flag:"db-port" usage:"postgres port"`
+	User     string `yaml:"user" env:"LIBRARY_DB_USERNAME" flag:"db-user" usage:"postgres user"`
+	Password string `yaml:"password" env:"LIBRARY_DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"LIBRARY_DB_NAME" flag:"db-name" usage:"postgres database"`
+	// SSLMode is one of the libpq sslmode values.
+	SSLMode     string `yaml:"sslmode" env:"LIBRARY_DB_SSLMODE" flag:"db-sslmode" usage:"disable, allow, prefer, require, verify-ca or verify-full"`
+	SSLRootCert string `yaml:"sslrootcert" env:"LIBRARY_DB_SSLROOTCERT" flag:"db-sslrootcert" usage:"CA certificate file the server certificate is verified with"`
+	SSLCert     string `yaml:"sslcert" env:"LIBRARY_DB_SSLCERT" flag:"db-sslcert" usage:"client certificate file"`
+	SSLKey      string `yaml:"sslkey" env:"LIBRARY_DB_SSLKEY" flag:"db-sslkey" usage:"private key file of the client certificate"`
+	// Replicas are the host:port addresses of read replicas sharing the
+	// credentials and TLS settings of the primary.
+	Replicas []string `yaml:"replicas" env:"LIBRARY_DB_REPLICAS" flag:"db-replicas" usage:"comma separated host:port of read replicas for list and search queries"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"LIBRARY_DB_MAX_OPEN_CONNS" flag:"db-max-open-conns" usage:"maximum number of open connections per database"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"LIBRARY_DB_MAX_IDLE_CONNS" flag:"db-max-idle-conns" usage:"maximum number of idle connections per database"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"LIBRARY_DB_CONN_MAX_LIFETIME" flag:"db-conn-max-lifetime" usage:"connections are closed after this long, 0 keeps them"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"LIBRARY_DB_CONN_MAX_IDLE_TIME" flag:"db-conn-max-idle-time" usage:"idle connections are closed after this long, 0 keeps them"`
+
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env:"LIBRARY_DB_CONNECT_TIMEOUT" flag:"db-connect-timeout" usage:"timeout of a single connection attempt"`
+	// ConnectRetry is how long the database is waited for on startup, the
+	// connection is retried with an exponential backoff until then.
+	ConnectRetry time.Duration `yaml:"connect_retry" env:"LIBRARY_DB_CONNECT_RETRY" flag:"db-connect-retry" usage:"how long connecting is retried on startup, 0 tries once"`
+	// StatementTimeout aborts statements running longer on the server.
+	StatementTimeout time.Duration `yaml:"statement_timeout" env:"LIBRARY_DB_STATEMENT_TIMEOUT" flag:"db-statement-timeout" usage:"statements running longer are aborted by postgres, 0 disables"`
+	SlowThreshold    time.Duration `yaml:"slow_threshold" env:"LIBRARY_DB_SLOW_THRESHOLD" flag:"db-slow-threshold" usage:"statements slower than this are logged as warnings"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"json or text"`
+	// Output is stdout, stderr or a file path. When empty commands printing
+	// their results to stdout log to stderr, the server logs to stdout.
+	Output string `yaml:"output" env:"LOG_OUTPUT" flag:"log-output" usage:"stdout, stderr or the path of a log file"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"none, stdout, file or otlp"`
+	File        string  `yaml:"file" env:"TRACING_FILE" flag:"tracing-file" usage:"file spans are written to by the file exporter"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" flag:"tracing-endpoint" usage:"host:port of the OTLP/HTTP collector"`
+	Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE" flag:"tracing-insecure" usage:"send spans to the collector over plain http"`
+	ServiceName string  `yaml:"service_name" env:"TRACING_SERVICE_NAME" flag:"tracing-service-name" usage:"name of the service in the traces"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" usage:"share of new traces which are recorded"`
+}
+
+type CORS struct {
+	// AllowedOrigins may contain * wildcards, e.g. https://*.example.com.
+	AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-allowed-origins" usage:"comma separated origins allowed to call the api, * wildcards are allowed"`
+	AllowedHeaders []string `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" flag:"cors-allowed-headers" usage:"comma separated request headers allowed from other origins"`
+	AllowedMethods []string `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS" flag:"cors-allowed-methods" usage:"comma separated methods allowed from other origins"`
+	ExposedHeaders []string `yaml:"exposed_headers" env:"CORS_EXPOSED_HEADERS" flag:"cors-exposed-headers" usage:"comma separated response headers scripts of other origins may read"`
+	// AllowCredentials lets browsers send cookies and authorization headers,
+	// it cannot be combined with the * origin.
+	AllowCredentials bool          `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" flag:"cors-allow-credentials" usage:"allow requests with credentials from other origins"`
+	MaxAge           time.Duration `yaml:"max_age" env:"CORS_MAX_AGE" flag:"cors-max-age" usage:"how long browsers may cache preflight responses"`
+}
+
+type Auth struct {
+	// APIKeys are accepted in the X-API-Key header, clients using one are
+	// rate limited by key instead of by ip address.
+	APIKeys []string `yaml:"api_keys" env:"AUTH_API_KEYS" secret:"true"`
+	// AdminKeys are api keys which may also purge deleted records.
+	AdminKeys []string `yaml:"admin_keys" env:"AUTH_ADMIN_KEYS" secret:"true"`
+}
+
+type RateLimit struct {
+	Enabled bool `yaml:"enabled" env:"RATE_LIMIT_ENABLED" flag:"rate-limit" usage:"limit the requests of every client"`
+	// Default is the limit of every client on all routes without a limit
+	// of their own, written as requests/period.
+	Default string `yaml:"default" env:"RATE_LIMIT_DEFAULT" flag:"rate-limit-default" usage:"requests/period allowed per client, e.g. 120/1m"`
+	// Routes are limits of single routes written as [METHOD ]template=limit,
+	// e.g. GET /books/withauthors=10/1m.
+	Routes []string `yaml:"routes" env:"RATE_LIMIT_ROUTES" flag:"rate-limit-routes" usage:"comma separated [METHOD ]route=requests/period overrides"`
+	// Store keeps the buckets, only memory is built in.
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" usage:"where buckets are kept: memory"`
+}
+
+type Trash struct {
+	// AuthorPolicy decides what deleting an author does to its books.
+	AuthorPolicy string `yaml:"author_policy" env:"TRASH_AUTHOR_POLICY" flag:"author-delete-policy" usage:"what deleting an author does to its books: block, cascade or orphan"`
+	// RetentionDays is how long deleted records can be restored before
+	// they are purged, 0 keeps them forever.
+	RetentionDays int           `yaml:"retention_days" env:"TRASH_RETENTION_DAYS" flag:"trash-retention-days" usage:"days after which deleted records are purged, 0 keeps them"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"TRASH_PURGE_INTERVAL" flag:"trash-purge-interval" usage:"how often expired records are purged"`
+}
+
+type Webhooks struct {
+	// Enabled runs the dispatcher delivering the outbox to the webhooks,
+	// events are written to the outbox either way.
+	Enabled      bool          `yaml:"enabled" env:"WEBHOOKS_ENABLED" flag:"webhooks" usage:"deliver domain events to the registered webhooks"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"WEBHOOKS_POLL_INTERVAL" flag:"webhooks-poll-interval" usage:"how often new events and due deliveries are looked for"`
+	Timeout      time.Duration `yaml:"timeout" env:"WEBHOOKS_TIMEOUT" flag:"webhooks-timeout" usage:"maximum duration of a single delivery"`
+	// MaxAttempts is how often a delivery is tried before it is a dead
+	// letter.
+	MaxAttempts int           `yaml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS" flag:"webhooks-max-attempts" usage:"attempts before a delivery is dead"`
+	RetryBase   time.Duration `yaml:"retry_base" env:"WEBHOOKS_RETRY_BASE" flag:"webhooks-retry-base" usage:"wait after the first failed attempt, doubled for every further one"`
+	RetryMax    time.Duration `yaml:"retry_max" env:"WEBHOOKS_RETRY_MAX" flag:"webhooks-retry-max" usage:"longest wait between attempts"`
+	BatchSize   int           `yaml:"batch_size" env:"WEBHOOKS_BATCH_SIZE" flag:"webhooks-batch-size" usage:"events and deliveries handled per poll"`
+}
+
+// Stream configures the server-sent event stream of GET /events.
+type Stream struct {
+	PollInterval time.Duration `yaml:"poll_interval" env:"STREAM_POLL_INTERVAL" flag:"stream-poll-interval" usage:"how often the outbox is read for new events"`
+	Heartbeat    time.Duration `yaml:"heartbeat" env:"STREAM_HEARTBEAT" flag:"stream-heartbeat" usage:"how often idle streams get a heartbeat comment"`
+	// Buffer is how many events a client may lag behind before its stream
+	// is closed, it resumes with Last-Event-ID.
+	Buffer      int `yaml:"buffer" env:"STREAM_BUFFER" flag:"stream-buffer" usage:"events buffered per client"`
+	ReplayLimit int `yaml:"replay_limit" env:"STREAM_REPLAY_LIMIT" flag:"stream-replay-limit" usage:"most missed events resent to a resuming client"`
+}
+
+// GRPC configures the gRPC api served next to the REST api.
+type GRPC struct {
+	Enabled bool   `yaml:"enabled" env:"GRPC_ENABLED" flag:"grpc" usage:"serve the gRPC api"`
+	Addr    string `yaml:"addr" env:"GRPC_ADDR" flag:"grpc-addr" usage:"address the gRPC api listens on"`
+	// Reflection lets clients such as grpcurl list the services and their
+	// messages.
+	Reflection bool `yaml:"reflection" env:"GRPC_REFLECTION" flag:"grpc-reflection" usage:"serve the gRPC reflection service"`
+}
+
+// Validation checks requests against the OpenAPI document of
+// /openapi.json before they reach the handlers, in development mode the
+// responses as well.
+type Validation struct {
+	Enabled bool `yaml:"enabled" env:"VALIDATION_ENABLED" flag:"validate" usage:"reject requests which do not match the OpenAPI document"`
+}
+
+// Development reports whether the server runs in development mode.
+func (s Server) Development() bool {
+	return strings.EqualFold(s.Mode, "development")
+}
+
+// Default returns the configuration used for everything which is not set.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            "127.0.0.1:4000",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			DrainDelay:      5 * time.Second,
+			RequestTimeout:  10 * time.Second,
+			Mode:            "production",
+		},
+		Database: Database{
+			Host:             "localhost",
+			Port:             5432,
+			User:             "postgres",
+			Name:             "library",
+			SSLMode:          "prefer",
+			MaxOpenConns:     25,
+			MaxIdleConns:     10,
+			ConnMaxLifetime:  30 * time.Minute,
+			ConnMaxIdleTime:  5 * time.Minute,
+			ConnectTimeout:   5 * time.Second,
+			ConnectRetry:     30 * time.Second,
+			StatementTimeout: 30 * time.Second,
+			SlowThreshold:    200 * time.Millisecond,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "library-api",
+			SampleRatio: 1,
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"https://localhost"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "If-Match", "If-None-Match", "Last-Event-ID"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			ExposedHeaders: []string{"X-Request-ID", "X-Total-Count", "ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			MaxAge:         10 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Default: "120/1m",
+			Routes: []string{
+				"GET /books/withauthors=10/1m",
+				"GET /authors/withbooks=10/1m",
+				"GET /export/books=5/1m",
+				"POST /import=5/1m",
+			},
+			Store: "memory",
+		},
+		Trash: Trash{
+			AuthorPolicy:  "block",
+			RetentionDays: 30,
+			PurgeInterval: time.Hour,
+		},
+		Webhooks: Webhooks{
+			Enabled:      true,
+			PollInterval: time.Second,
+			Timeout:      5 * time.Second,
+			MaxAttempts:  8,
+			RetryBase:    10 * time.Second,
+			RetryMax:     time.Hour,
+			BatchSize:    50,
+		},
+		Stream: Stream{
+			PollInterval: time.Second,
+			Heartbeat:    15 * time.Second,
+			Buffer:       256,
+			ReplayLimit:  1000,
+		},
+		GRPC: GRPC{
+			Enabled:    true,
+			Addr:       "127.0.0.1:4001",
+			Reflection: true,
+		},
+	}
+}
+
+// ValidationError lists every problem of a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (v *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(v.Problems, "; ")
+}
+
+// Validate reports every invalid value of the configuration at once.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		add("server.addr %q is not host:port", c.Server.Addr)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		add("server.addr %q has an invalid port", c.Server.Addr)
+	}
+	for _, f := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	} {
+		if f.value <= 0 {
+			add("%s must be positive", f.name)
+		}
+	}
+	if !oneOf(strings.ToLower(c.Server.Mode), "production", "development") {
+		add("server.mode %q must be production or development", c.Server.Mode)
+	}
+	if c.Server.DrainDelay < 0 {
+		add("server.drain_delay cannot be negative")
+	} else if c.Server.DrainDelay >= c.Server.ShutdownTimeout {
+		// the drain delay is part of the shutdown timeout
+		add("server.drain_delay must be shorter than server.shutdown_timeout")
+	}
+	if c.Server.RequestTimeout < 0 {
+		add("server.request_timeout cannot be negative")
+	} else if c.Server.RequestTimeout >= c.Server.WriteTimeout {
+		add("server.request_timeout must be shorter than server.write_timeout")
+	}
+	for _, route := range c.Server.RouteTimeouts {
+		deadlines, err := middleware.ParseDeadlines([]string{route})
+		if err != nil {
+			add("server.route_timeouts: %s", err)
+			continue
+		}
+		for name, timeout := range deadlines {
+			if timeout >= c.Server.WriteTimeout {
+				add("server.route_timeouts: %s must be shorter than server.write_timeout", name)
+			}
+		}
+	}
+
+	if c.Database.Host == "" {
+		add("database.host is required")
+	}
+	if c.Database.Port <= 0 || c.Database.Port > 65535 {
+		add("database.port %d is out of range", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		add("database.user is required")
+	}
+	if c.Database.Name == "" {
+		add("database.name is required")
+	}
+	if !oneOf(c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full") {
+		add("database.sslmode %q is not a libpq sslmode", c.Database.SSLMode)
+	}
+	if (c.Database.SSLMode == "verify-ca" || c.Database.SSLMode == "verify-full") && c.Database.SSLRootCert == "" {
+		add("database.sslrootcert is required by sslmode %s", c.Database.SSLMode)
+	}
+	if (c.Database.SSLCert == "") != (c.Database.SSLKey == "") {
+		add("database.sslcert and database.sslkey must be set together")
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"database.sslrootcert", c.Database.SSLRootCert},
+		{"database.sslcert", c.Database.SSLCert},
+		{"database.sslkey", c.Database.SSLKey},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if _, err := os.Stat(f.value); err != nil {
+			add("%s: %s", f.name, err)
+		}
+	}
+	for _, replica := range c.Database.Replicas {
+		if _, port, err := net.SplitHostPort(replica); err != nil {
+			add("database.replicas %q is not host:port", replica)
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			add("database.replicas %q has an invalid port", replica)
+		}
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		add("database connection limits cannot be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		add("database.max_idle_conns cannot exceed database.max_open_conns")
+	}
+	for _, f := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"database.conn_max_lifetime", c.Database.ConnMaxLifetime},
+		{"database.conn_max_idle_time", c.Database.ConnMaxIdleTime},
+		{"database.connect_retry", c.Database.ConnectRetry},
+		{"database.statement_timeout", c.Database.StatementTimeout},
+		{"database.slow_threshold", c.Database.SlowThreshold},
+	} {
+		if f.value < 0 {
+			add("%s cannot be negative", f.name)
+		}
+	}
+	if c.Database.ConnectTimeout < time.Second {
+		add("database.connect_timeout must be at least 1s")
+	}
+
+	if !oneOf(strings.ToLower(c.Log.Level), "debug", "info", "warn", "error") {
+		add("log.level %q must be debug, info, warn or error", c.Log.Level)
+	}
+	if !oneOf(strings.ToLower(c.Log.Format), "json", "text") {
+		add("log.format %q must be json or text", c.Log.Format)
+	}
+
+	if !oneOf(strings.ToLower(c.Tracing.Exporter), "none", "stdout", "file", "otlp") {
+		add("tracing.exporter %q must be none, stdout, file or otlp", c.Tracing.Exporter)
+	}
+	if strings.EqualFold(c.Tracing.Exporter, "file") && c.Tracing.File == "" {
+		add("tracing.file is required by the file exporter")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" && c.CORS.AllowCredentials {
+			add("cors.allow_credentials cannot be combined with the * origin")
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		add("cors.max_age cannot be negative")
+	}
+
+	if _, err := ratelimit.ParseLimit(c.RateLimit.Default); err != nil {
+		add("rate_limit.default: %s", err)
+	}
+	if _, err := ratelimit.ParseRoutes(c.RateLimit.Routes); err != nil {
+		add("rate_limit.routes: %s", err)
+	}
+	if _, err := trash.ParsePolicy(c.Trash.AuthorPolicy); err != nil {
+		add("trash.author_policy: %s", err)
+	}
+	if c.Trash.RetentionDays < 0 {
+		add("trash.retention_days cannot be negative")
+	}
+	if c.Trash.PurgeInterval <= 0 {
+		add("trash.purge_interval must be positive")
+	}
+	if c.RateLimit.Store != "memory" {
+		add("rate_limit.store %q must be memory", c.RateLimit.Store)
+	}
+	if c.Webhooks.PollInterval <= 0 {
+		add("webhooks.poll_interval must be positive")
+	}
+	if c.Webhooks.Timeout <= 0 {
+		add("webhooks.timeout must be positive")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		add("webhooks.max_attempts must be at least 1")
+	}
+	if c.Webhooks.RetryBase <= 0 || c.Webhooks.RetryMax < c.Webhooks.RetryBase {
+		add("webhooks.retry_base must be positive and at most webhooks.retry_max")
+	}
+	if c.Webhooks.BatchSize < 1 {
+		add("webhooks.batch_size must be at least 1")
+	}
+	if c.Stream.PollInterval <= 0 {
+		add("stream.poll_interval must be positive")
+	}
+	if c.Stream.Heartbeat <= 0 {
+		add("stream.heartbeat must be positive")
+	}
+	if c.Stream.Buffer < 1 {
+		add("stream.buffer must be at least 1")
+	}
+	if c.Stream.ReplayLimit < 0 {
+		add("stream.replay_limit cannot be negative")
+	}
+	if c.GRPC.Enabled {
+		if _, port, err := net.SplitHostPort(c.GRPC.Addr); err != nil {
+			add("grpc.addr %q is not host:port", c.GRPC.Addr)
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			add("grpc.addr %q has an invalid port", c.GRPC.Addr)
+		} else if c.GRPC.Addr == c.Server.Addr {
+			add("grpc.addr must differ from server.addr")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// DSN returns the libpq connection string of the primary database.
+func (d Database) DSN() string {
+	return d.dsn(d.Host, d.Port)
+}
+
+// ReplicaDSNs returns the connection strings of the read replicas.
+func (d Database) ReplicaDSNs() []string {
+	dsns := make([]string, 0, len(d.Replicas))
+	for _, replica := range d.Replicas {
+		host, port, _ := net.SplitHostPort(replica)
+		portNumber, _ := strconv.Atoi(port)
+		dsns = append(dsns, d.dsn(host, portNumber))
+	}
+	return dsns
+}
+
+func (d Database) dsn(host string, port int) string {
+	params := []string{
+		"host=" + quoteDSN(host),
+		"port=" + strconv.Itoa(port),
+		"user=" + quoteDSN(d.User),
+		"password=" + quoteDSN(d.Password),
+		"dbname=" + quoteDSN(d.Name),
+		"sslmode=" + quoteDSN(d.SSLMode),
+		"connect_timeout=" + strconv.Itoa(int(d.ConnectTimeout/time.Second)),
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"sslrootcert", d.SSLRootCert},
+		{"sslcert", d.SSLCert},
+		{"sslkey", d.SSLKey},
+	} {
+		if f.value != "" {
+			params = append(params, f.name+"="+quoteDSN(f.value))
+		}
+	}
+	if d.StatementTimeout > 0 {
+		// unknown keys are sent to the server as run-time parameters
+		params = append(params, "statement_timeout="+strconv.FormatInt(d.StatementTimeout.Milliseconds(), 10))
+	}
+	return strings.Join(params, " ")
+}
+
+// quoteDSN quotes a connection string value as libpq expects it.
+func quoteDSN(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return "'" + value + "'"
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDrainDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		drain   time.Duration
+		problem string
+	}{
+		{"default", Default().Server.DrainDelay, ""},
+		{"no delay", 0, ""},
+		{"negative", -time.Second, "server.drain_delay cannot be negative"},
+		{"as long as the shutdown", 10 * time.Second, "server.drain_delay must be shorter than server.shutdown_timeout"},
+		{"longer than the shutdown", time.Minute, "server.drain_delay must be shorter than server.shutdown_timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Server.DrainDelay = tt.drain
+			err := cfg.Validate()
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("error %v, want %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestDefaultDrains(t *testing.T) {
+	// load balancers must see readiness fail before connections are refused
+	if Default().Server.DrainDelay <= 0 {
+		t.Error("the default drain delay is 0")
+	}
+}
+
+func TestDatabaseDSN(t *testing.T) {
+	db := Default().Database
+	db.Password = `it's a \secret`
+	db.SSLMode = "verify-full"
+	db.SSLRootCert = "/etc/ssl/ca.pem"
+	db.Replicas = []string{"replica-1:5433", "[::1]:5434"}
+
+	want := `host='localhost' port=5432 user='postgres' password='it\'s a \\secret' dbname='library' sslmode='verify-full' ` +
+		`connect_timeout=5 sslrootcert='/etc/ssl/ca.pem' statement_timeout=30000`
+	if got := db.DSN(); got != want {
+		t.Errorf("DSN()\n got %s\nwant %s", got, want)
+	}
+
+	replicas := db.ReplicaDSNs()
+	if len(replicas) != 2 {
+		t.Fatalf("%d replica DSNs, want 2", len(replicas))
+	}
+	for i, prefix := range []string{"host='replica-1' port=5433 ", "host='::1' port=5434 "} {
+		// replicas share everything but the address with the primary
+		if !strings.HasPrefix(replicas[i], prefix) || !strings.HasSuffix(replicas[i], strings.SplitN(want, " user=", 2)[1]) {
+			t.Errorf("replica DSN %s, want %s...", replicas[i], prefix)
+		}
+	}
+
+	db.StatementTimeout = 0
+	if got := db.DSN(); strings.Contains(got, "statement_timeout") {
+		t.Errorf("DSN() %s sets a statement timeout, want none", got)
+	}
+}
+
+func TestValidateDatabase(t *testing.T) {
+	cert := filepath.Join(t.TempDir(), "client.pem")
+	if err := os.WriteFile(cert, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(db *Database)
+		problem string
+	}{
+		{"default", func(db *Database) {}, ""},
+		{"client certificate", func(db *Database) { db.SSLCert, db.SSLKey = cert, cert }, ""},
+		{"verify without a CA", func(db *Database) { db.SSLMode = "verify-ca" }, "database.sslrootcert is required by sslmode verify-ca"},
+		{"certificate without a key", func(db *Database) { db.SSLCert = cert }, "database.sslcert and database.sslkey must be set together"},
+		{"missing CA file", func(db *Database) { db.SSLRootCert = cert + ".missing" }, "database.sslrootcert: "},
+		{"replica without a port", func(db *Database) { db.Replicas = []string{"replica-1"} }, `database.replicas "replica-1" is not host:port`},
+		{"replica with a bad port", func(db *Database) { db.Replicas = []string{"replica-1:99999"} }, `database.replicas "replica-1:99999" has an invalid port`},
+		{"more idle than open", func(db *Database) { db.MaxOpenConns, db.MaxIdleConns = 5, 10 }, "database.max_idle_conns cannot exceed database.max_open_conns"},
+		{"unlimited open", func(db *Database) { db.MaxOpenConns, db.MaxIdleConns = 0, 10 }, ""},
+		{"negative limit", func(db *Database) { db.MaxIdleConns = -1 }, "database connection limits cannot be negative"},
+		{"negative retry", func(db *Database) { db.ConnectRetry = -time.Second }, "database.connect_retry cannot be negative"},
+		{"short connect timeout", func(db *Database) { db.ConnectTimeout = 500 * time.Millisecond }, "database.connect_timeout must be at least 1s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(&cfg.Database)
+			err := cfg.Validate()
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("error %v, want %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestWriteLeavesOutSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "s3cret"
+	cfg.Auth.APIKeys = []string{"key-1", "key-2"}
+	cfg.Database.Replicas = []string{"replica-1:5432"}
+
+	var out strings.Builder
+	if err := Write(&out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	for _, secret := range []string{"s3cret", "key-1", "password:", "api_keys:"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, printed)
+		}
+	}
+	if !strings.HasPrefix(printed, "# secrets which are set are left out: database.password (LIBRARY_DB_PASSWORD), auth.api_keys (AUTH_API_KEYS)\n") {
+		t.Errorf("printed configuration does not name the secrets:\n%s", printed)
+	}
+
+	// the output loads back into the same configuration, without secrets
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(printed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded := Default()
+	if err := loadFile(&loaded, path); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Database.Password, cfg.Auth.APIKeys = "", nil
+	var want, got strings.Builder
+	Write(&want, &cfg)
+	Write(&got, &loaded)
+	if got.String() != want.String() {
+		t.Errorf("loaded configuration\n%s\nwant\n%s", got.String(), want.String())
+	}
+}
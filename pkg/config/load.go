@@ -0,0 +1,195 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable holding the path of the YAML file,
+// the -config flag takes precedence over it.
+const FileEnv = "LIBRARY_CONFIG"
+
+// Loader reads the configuration, its flags are registered on the flag set
+// of the command.
+type Loader struct {
+	flags *flag.FlagSet
+	file  *string
+}
+
+// NewLoader registers a flag for every configuration field on flags, Load
+// must be called once they are parsed.
+func NewLoader(flags *flag.FlagSet) *Loader {
+	l := &Loader{flags: flags}
+	l.file = flags.String("config", "", "path of the YAML configuration file, overrides "+FileEnv)
+
+	defaults := Default()
+	for _, f := range fields(&defaults) {
+		if f.flag == "" {
+			continue
+		}
+		flags.String(f.flag, format(f.value), fmt.Sprintf("%s (%s)", f.usage, f.env))
+	}
+	return l
+}
+
+// Load builds the configuration from the defaults, the YAML file, the
+// environment and the flags set on the command line, in that order, and
+// validates it.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+
+	path := *l.file
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	byFlag := make(map[string]field)
+	for _, f := range fields(&cfg) {
+		if value, ok := os.LookupEnv(f.env); ok && f.env != "" {
+			if err := set(f.value, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+		if f.flag != "" {
+			byFlag[f.flag] = f
+		}
+	}
+
+	var err error
+	l.flags.Visit(func(fl *flag.Flag) {
+		if f, ok := byFlag[fl.Name]; ok && err == nil {
+			if setErr := set(f.value, fl.Value.String()); setErr != nil {
+				err = fmt.Errorf("-%s: %w", fl.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, cfg.Validate()
+}
+
+// loadFile applies the YAML file on cfg, unknown keys are rejected so typos
+// do not go unnoticed. An empty file changes nothing.
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// field is a single configuration value with the tags of its struct field.
+type field struct {
+	path   string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// fields lists the configuration values of cfg in declaration order.
+func fields(cfg *Config) []field {
+	var list []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			path := prefix + sf.Tag.Get("yaml")
+			if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(path+".", v.Field(i))
+				continue
+			}
+			list = append(list, field{
+				path:   path,
+				env:    sf.Tag.Get("env"),
+				flag:   sf.Tag.Get("flag"),
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return list
+}
+
+// set parses s into the configuration value v.
+func set(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", s)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported configuration type %s", v.Type())
+	}
+	return nil
+}
+
+// format returns the value as it would be written in an environment
+// variable or a flag.
+func format(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case []string:
+		return strings.Join(value, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Write prints cfg as a YAML file which can be loaded again. Secrets which
+// are set are left out, a comment at the top lists them, so loading the file
+// keeps taking them from the environment. Every value is commented with its
+// environment variable and flag.
+func Write(w io.Writer, cfg *Config) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	var secrets []string
+	for _, f := range fields(cfg) {
+		if f.secret && f.value.Len() > 0 {
+			secrets = append(secrets, fmt.Sprintf("%s (%s)", f.path, comment(f)))
+			continue
+		}
+
+		section, key, _ := strings.Cut(f.path, ".")
+		node, ok := sections[section]
+		if !ok {
+			node = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = node
+			root.Content = append(root.Content, scalar(section, "!!str"), node)
+		}
+
+		value := valueNode(f)
+		value.LineComment = comment(f)
+		node.Content = append(node.Content, scalar(key, "!!str"), value)
+	}
+	if len(secrets) > 0 {
+		root.HeadComment = "secrets which are set are left out: " + strings.Join(secrets, ", ")
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func valueNode(f field) *yaml.Node {
+	switch f.value.Kind() {
+	case reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := 0; i < f.value.Len(); i++ {
+			node.Content = append(node.Content, scalar(f.value.Index(i).String(), "!!str"))
+		}
+		return node
+	case reflect.String:
+		return scalar(f.value.String(), "!!str")
+	case reflect.Int64:
+		// durations are written the way they are parsed
+		return scalar(format(f.value), "!!str")
+	default:
+		return scalar(format(f.value), "")
+	}
+}
+
+func comment(f field) string {
+	var sources []string
+	if f.env != "" {
+		sources = append(sources, f.env)
+	}
+	if f.flag != "" {
+		sources = append(sources, "-"+f.flag)
+	}
+	return strings.Join(sources, ", ")
+}
+
+func scalar(value, tag string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: tag}
+}
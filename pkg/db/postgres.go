@@ -1,35 +1,102 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"log/slog"
+	"math/rand"
+	"time"
 
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/config"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
-func NewPsqlDB() (*gorm.DB, error) {
-	dbURI := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s",
-		os.Getenv("LIBRARY_DB_HOST"),
-		os.Getenv("LIBRARY_DB_PORT"),
-		os.Getenv("LIBRARY_DB_USERNAME"),
-		os.Getenv("LIBRARY_DB_NAME"),
-		os.Getenv("LIBRARY_DB_PASSWORD"),
-	)
+// ReplicaResolver names the read replicas in dbresolver.Use. Statements
+// using it run on the primary when no replica is configured.
+const ReplicaResolver = "replica"
 
-	db, err := gorm.Open(postgres.Open(dbURI), &gorm.Config{})
-	if err!=nil{
+// NewPsqlDB connects to the primary database, retrying with an exponential
+// backoff until cfg.ConnectRetry passed, so the api can start before
+// postgres is up. Read replicas are registered under ReplicaResolver.
+func NewPsqlDB(cfg config.Database) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{
+		// the connection is checked by connect
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
 		return nil, fmt.Errorf("cannot open database: %v", err)
 	}
 
 	sqlDB, err := db.DB()
-	if err!=nil{
+	if err != nil {
 		return nil, err
 	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	if err:=sqlDB.Ping(); err!=nil{
+	if err := connect(sqlDB.PingContext, cfg.ConnectRetry); err != nil {
+		sqlDB.Close()
 		return nil, err
 	}
+
+	if len(cfg.Replicas) > 0 {
+		replicas := make([]gorm.Dialector, 0, len(cfg.Replicas))
+		for _, dsn := range cfg.ReplicaDSNs() {
+			replicas = append(replicas, postgres.Open(dsn))
+		}
+
+		resolver := dbresolver.Register(dbresolver.Config{Replicas: replicas}, ReplicaResolver)
+		if err := db.Use(resolver); err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("cannot open read replicas: %v", err)
+		}
+		resolver.
+			SetMaxOpenConns(cfg.MaxOpenConns).
+			SetMaxIdleConns(cfg.MaxIdleConns).
+			SetConnMaxLifetime(cfg.ConnMaxLifetime).
+			SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+
 	return db, nil
+}
 
-}
\ No newline at end of file
+// connect pings the database until it answers or retry passed, waiting
+// twice as long after every failed attempt.
+func connect(ping func(context.Context) error, retry time.Duration) error {
+	const (
+		initialBackoff = 500 * time.Millisecond
+		maxBackoff     = 10 * time.Second
+	)
+
+	deadline := time.Now().Add(retry)
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := ping(context.Background())
+		if err == nil {
+			return nil
+		}
+
+		// up to a fifth of jitter, so instances started together do not
+		// retry in lockstep
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/5))
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("cannot connect to database after %d attempts: %w", attempt, err)
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+
+		slog.Warn("database is not reachable, retrying",
+			"attempt", attempt, "retry_in", wait.String(), "error", err)
+		time.Sleep(wait)
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
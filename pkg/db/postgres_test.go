@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flaky returns a ping failing the given number of times before it answers.
+func flaky(failures int) (ping func(context.Context) error, attempts *int) {
+	attempts = new(int)
+	return func(context.Context) error {
+		*attempts++
+		if *attempts <= failures {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, attempts
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		retry    time.Duration
+		attempts int
+		err      string
+	}{
+		{"up", 0, 0, 1, ""},
+		{"no retry", 1, 0, 1, "cannot connect to database after 1 attempts: connection refused"},
+		{"comes up", 1, 5 * time.Second, 2, ""},
+		// the second attempt waits only for what is left of the retry
+		{"stays down", 10, 200 * time.Millisecond, 2, "cannot connect to database after 2 attempts: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ping, attempts := flaky(tt.failures)
+			start := time.Now()
+			err := connect(ping, tt.retry)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if *attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", *attempts, tt.attempts)
+			}
+			if took := time.Since(start); tt.retry > 0 && took > tt.retry+time.Second {
+				t.Errorf("connecting took %s, longer than the retry %s", took, tt.retry)
+			}
+		})
+	}
+}
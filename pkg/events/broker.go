@@ -0,0 +1,305 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+var (
+	// ErrClosed ends the subscriptions of a broker which shuts down.
+	ErrClosed = errors.New("event stream is shutting down")
+	// ErrSlow ends a subscription whose buffer ran full, the subscriber can
+	// resume from the outbox.
+	ErrSlow = errors.New("subscriber is too slow")
+)
+
+const (
+	// gapWait is how long the broker waits for the events of ids it skipped.
+	// Ids are taken when an event is written but become visible when its
+	// transaction commits, which may be after events with higher ids.
+	gapWait = 30 * time.Second
+	// maxGaps bounds the skipped ids waited for, rolled back transactions
+	// leave gaps which are never filled.
+	maxGaps = 1000
+	// pollBatch is how many events are read from the outbox per query.
+	pollBatch = 500
+)
+
+// Filter selects the events a subscriber receives, the zero Filter selects
+// every event.
+type Filter struct {
+	// Entity is book or author.
+	Entity string
+	// BookIDs selects the events about these books.
+	BookIDs []uint
+	Types   []Type
+}
+
+// Matches reports whether the event is selected by the filter.
+func (f Filter) Matches(e Event) bool {
+	if f.Entity != "" && e.Entity != f.Entity {
+		return false
+	}
+	if len(f.BookIDs) > 0 {
+		if e.Entity != "book" || !containsID(f.BookIDs, e.EntityID) {
+			return false
+		}
+	}
+	if len(f.Types) > 0 && !containsType(f.Types, e.Type) {
+		return false
+	}
+	return true
+}
+
+// where adds the conditions of the filter to a query of the outbox.
+func (f Filter) where(db *gorm.DB) *gorm.DB {
+	if f.Entity != "" {
+		db = db.Where("entity = ?", f.Entity)
+	}
+	if len(f.BookIDs) > 0 {
+		db = db.Where("entity = ? AND entity_id IN ?", "book", f.BookIDs)
+	}
+	if len(f.Types) > 0 {
+		db = db.Where("type IN ?", f.Types)
+	}
+	return db
+}
+
+// Subscription receives the events committed after it was created.
+type Subscription struct {
+	// From is the last event committed before the subscription, events up
+	// to it are read from the outbox with Replay.
+	From uint
+
+	filter Filter
+	events chan Event
+	err    error
+}
+
+// Events is closed when the subscription ends, Err tells why.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err is ErrClosed or ErrSlow once Events was closed by the broker.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Broker polls the outbox and hands the new events to its subscribers, so
+// every instance of the api streams every event no matter which instance
+// committed it. A subscriber gets buffer events ahead before it is dropped.
+type Broker struct {
+	db       *gorm.DB
+	interval time.Duration
+	buffer   int
+
+	mu     sync.Mutex
+	ready  bool
+	closed bool
+	cursor uint
+	gaps   map[uint]time.Time
+	subs   map[*Subscription]struct{}
+}
+
+func NewBroker(db *gorm.DB, interval time.Duration, buffer int) *Broker {
+	return &Broker{
+		db:       db.Clauses(dbresolver.Write).Session(&gorm.Session{}),
+		interval: interval,
+		buffer:   buffer,
+		gaps:     make(map[uint]time.Time),
+		subs:     make(map[*Subscription]struct{}),
+	}
+}
+
+// Run polls the outbox every interval until ctx is done, then it closes
+// the broker.
+func (b *Broker) Run(ctx context.Context) {
+	defer b.Close()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("event stream cannot read the outbox", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Subscribe starts a subscription to the events matching filter. It fails
+// with ErrClosed once the broker shuts down.
+func (b *Broker) Subscribe(ctx context.Context, filter Filter) (*Subscription, error) {
+	if err := b.start(ctx); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	sub := &Subscription{From: b.cursor, filter: filter, events: make(chan Event, b.buffer)}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe ends a subscription, it may be called more than once.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(sub, nil)
+}
+
+// Close ends every subscription, e.g. when the server shuts down, so
+// streaming requests return.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.drop(sub, ErrClosed)
+	}
+}
+
+// Replay reads the events matching filter with ids in (after, upTo] from
+// the outbox, oldest first. complete is false when there are more than
+// limit of them, none are returned then.
+func (b *Broker) Replay(ctx context.Context, filter Filter, after, upTo uint, limit int) (events []Event, complete bool, err error) {
+	if after >= upTo {
+		return nil, true, nil
+	}
+	db := filter.where(b.db.WithContext(ctx).Where("id > ? AND id <= ?", after, upTo))
+	if err := db.Order("id").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, false, err
+	}
+	if len(events) > limit {
+		return nil, false, nil
+	}
+	return events, true, nil
+}
+
+// start moves the cursor to the end of the outbox the first time it is
+// needed, events committed before the broker started are only replayed.
+func (b *Broker) start(ctx context.Context) error {
+	b.mu.Lock()
+	ready := b.ready
+	b.mu.Unlock()
+	if ready {
+		return nil
+	}
+
+	var last uint
+	if err := b.db.WithContext(ctx).Model(&Event{}).Select("COALESCE(MAX(id), 0)").Scan(&last).Error; err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ready {
+		b.cursor, b.ready = last, true
+	}
+	return nil
+}
+
+// poll reads the events committed since the last poll and broadcasts them.
+func (b *Broker) poll(ctx context.Context) error {
+	if err := b.start(ctx); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	cursor := b.cursor
+	now := time.Now()
+	gaps := make([]uint, 0, len(b.gaps))
+	for id, since := range b.gaps {
+		if now.Sub(since) > gapWait {
+			delete(b.gaps, id)
+			continue
+		}
+		gaps = append(gaps, id)
+	}
+	b.mu.Unlock()
+
+	var found []Event
+	db := b.db.WithContext(ctx).Where("id > ?", cursor)
+	if len(gaps) > 0 {
+		db = db.Or("id IN ?", gaps)
+	}
+	if err := db.Order("id").Limit(pollBatch).Find(&found).Error; err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := b.cursor
+	for _, event := range found {
+		if event.ID <= b.cursor {
+			delete(b.gaps, event.ID)
+		} else {
+			for id := next + 1; id < event.ID && len(b.gaps) < maxGaps; id++ {
+				b.gaps[id] = now
+			}
+			next = event.ID
+		}
+		b.broadcast(event)
+	}
+	b.cursor = next
+	return nil
+}
+
+// broadcast hands the event to the matching subscribers, subscribers with
+// a full buffer are dropped. It is called with mu held.
+func (b *Broker) broadcast(event Event) {
+	for sub := range b.subs {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.drop(sub, ErrSlow)
+		}
+	}
+}
+
+// drop ends a subscription, it is called with mu held.
+func (b *Broker) drop(sub *Subscription, err error) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	sub.err = err
+	close(sub.events)
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []Type, t Type) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestBroker returns a broker on an empty sqlite outbox, it is polled
+// by the tests themselves.
+func newTestBroker(t *testing.T, buffer int) (*Broker, *gorm.DB) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "outbox.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&Event{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewBroker(db, time.Hour, buffer), db
+}
+
+// commit writes the events of the given ids to the outbox, like
+// transactions committing in a different order than they took their ids.
+func commit(t *testing.T, db *gorm.DB, ids ...uint) {
+	t.Helper()
+	for _, id := range ids {
+		event := Event{ID: id, OccurredAt: time.Now(), Type: BookUpdated, Entity: "book", EntityID: id}
+		if err := db.Create(&event).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// received returns the ids of the events waiting in the subscription.
+func received(sub *Subscription) []uint {
+	var ids []uint
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func sameIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPollFillsGaps(t *testing.T) {
+	broker, db := newTestBroker(t, 100)
+	ctx := context.Background()
+	// events committed before the broker started are only replayed
+	commit(t, db, 1)
+	sub, err := broker.Subscribe(ctx, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.From != 1 {
+		t.Errorf("subscription starts after %d, want 1", sub.From)
+	}
+
+	steps := []struct {
+		name   string
+		commit []uint
+		want   []uint
+		gaps   int
+	}{
+		{"3 commits before 2", []uint{3}, []uint{3}, 1},
+		{"2 commits late", []uint{2}, []uint{2}, 0},
+		{"5 and 7 skip 4 and 6", []uint{5, 7}, []uint{5, 7}, 2},
+		{"nothing new", nil, nil, 2},
+		{"6 commits late", []uint{6}, []uint{6}, 1},
+	}
+	for _, step := range steps {
+		commit(t, db, step.commit...)
+		if err := broker.poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := received(sub); !sameIDs(got, step.want) {
+			t.Errorf("%s: received %v, want %v", step.name, got, step.want)
+		}
+		if len(broker.gaps) != step.gaps {
+			t.Errorf("%s: waiting for %d gaps, want %d", step.name, len(broker.gaps), step.gaps)
+		}
+	}
+
+	// the transaction of 4 was rolled back, its gap is given up eventually
+	broker.gaps[4] = time.Now().Add(-gapWait - time.Second)
+	if err := broker.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.gaps) != 0 {
+		t.Errorf("still waiting for %d gaps", len(broker.gaps))
+	}
+	commit(t, db, 4, 8)
+	if err := broker.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := received(sub); !sameIDs(got, []uint{8}) {
+		t.Errorf("received %v after the gap was given up, want [8]", got)
+	}
+}
+
+func TestSubscriptionsEnd(t *testing.T) {
+	broker, db := newTestBroker(t, 1)
+	ctx := context.Background()
+
+	books, err := broker.Subscribe(ctx, Filter{BookIDs: []uint{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := broker.Subscribe(ctx, Filter{Entity: "book"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(t, db, 1, 2)
+	if err := broker.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second event did not fit into the buffer
+	if got := received(slow); !sameIDs(got, []uint{1}) || !errors.Is(slow.Err(), ErrSlow) {
+		t.Errorf("slow subscriber received %v and ended with %v, want [1] and ErrSlow", got, slow.Err())
+	}
+	if got := received(books); !sameIDs(got, []uint{2}) {
+		t.Errorf("subscriber of book 2 received %v", got)
+	}
+
+	broker.Close()
+	if _, ok := <-books.Events(); ok || !errors.Is(books.Err(), ErrClosed) {
+		t.Errorf("subscription ended with %v, want ErrClosed", books.Err())
+	}
+	if _, err := broker.Subscribe(ctx, Filter{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("subscribing to a closed broker: %v, want ErrClosed", err)
+	}
+	// unsubscribing after the end is harmless
+	broker.Unsubscribe(books)
+}
+
+func TestReplay(t *testing.T) {
+	broker, db := newTestBroker(t, 1)
+	commit(t, db, 1, 2, 3, 4, 5)
+
+	tests := []struct {
+		after, upTo uint
+		filter      Filter
+		limit       int
+		want        []uint
+		complete    bool
+	}{
+		{1, 4, Filter{}, 10, []uint{2, 3, 4}, true},
+		{1, 4, Filter{BookIDs: []uint{3, 5}}, 10, []uint{3}, true},
+		{1, 4, Filter{Entity: "author"}, 10, nil, true},
+		{0, 5, Filter{}, 5, []uint{1, 2, 3, 4, 5}, true},
+		{0, 5, Filter{}, 4, nil, false},
+		{5, 5, Filter{}, 10, nil, true},
+	}
+	for _, tt := range tests {
+		events, complete, err := broker.Replay(context.Background(), tt.filter, tt.after, tt.upTo, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []uint
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if !sameIDs(ids, tt.want) || complete != tt.complete {
+			t.Errorf("Replay(%+v, %d, %d, %d) = %v, %v, want %v, %v",
+				tt.filter, tt.after, tt.upTo, tt.limit, ids, complete, tt.want, tt.complete)
+		}
+	}
+}
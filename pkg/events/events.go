@@ -0,0 +1,148 @@
+// Package events defines the domain events other systems are told about.
+// Events are written to an outbox table in the transaction of the change
+// they describe, so they are published exactly when the change is
+// committed. The webhooks dispatcher delivers them from there.
+package events
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	"gorm.io/gorm"
+)
+
+// Type names an event, e.g. BookCreated.
+type Type string
+
+const (
+	BookCreated   Type = "BookCreated"
+	BookUpdated   Type = "BookUpdated"
+	BookDeleted   Type = "BookDeleted"
+	BookRestored  Type = "BookRestored"
+	BookPurged    Type = "BookPurged"
+	BookPurchased Type = "BookPurchased"
+	StockChanged  Type = "StockChanged"
+
+	AuthorCreated  Type = "AuthorCreated"
+	AuthorUpdated  Type = "AuthorUpdated"
+	AuthorDeleted  Type = "AuthorDeleted"
+	AuthorRestored Type = "AuthorRestored"
+	AuthorPurged   Type = "AuthorPurged"
+)
+
+// Types lists every event type.
+var Types = []Type{
+	BookCreated, BookUpdated, BookDeleted, BookRestored, BookPurged, BookPurchased, StockChanged,
+	AuthorCreated, AuthorUpdated, AuthorDeleted, AuthorRestored, AuthorPurged,
+}
+
+// StockChange is the data of StockChanged events.
+type StockChange struct {
+	BookID   uint `json:"bookId"`
+	Previous int  `json:"previousStock"`
+	Stock    int  `json:"stock"`
+}
+
+// Purchase is the data of BookPurchased events.
+type Purchase struct {
+	BookID   uint `json:"bookId"`
+	Quantity int  `json:"quantity"`
+	Stock    int  `json:"stock"`
+}
+
+// Event is a domain event in the outbox.
+type Event struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	OccurredAt time.Time `json:"occurredAt" gorm:"not null"`
+	Type       Type      `json:"type" gorm:"not null"`
+	// Entity and EntityID name the record the event is about, e.g. book 42.
+	Entity    string `json:"entity" gorm:"not null"`
+	EntityID  uint   `json:"entityId" gorm:"not null"`
+	RequestID string `json:"requestId,omitempty"`
+	Data      Data   `json:"data" gorm:"type:jsonb"`
+	// DispatchedAt is set once the event was handed to the webhooks.
+	DispatchedAt *time.Time `json:"-" gorm:"index"`
+}
+
+func (Event) TableName() string {
+	return "outbox_events"
+}
+
+// Data is the json document of an event, it is sent as it is.
+type Data []byte
+
+func (d Data) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+func (d *Data) UnmarshalJSON(doc []byte) error {
+	*d = append((*d)[:0], doc...)
+	return nil
+}
+
+// Value stores the document as text, which every driver can write to jsonb.
+func (d Data) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return "null", nil
+	}
+	return string(d), nil
+}
+
+func (d *Data) Scan(src interface{}) error {
+	switch doc := src.(type) {
+	case nil:
+		*d = nil
+	case []byte:
+		*d = append(Data(nil), doc...)
+	case string:
+		*d = Data(doc)
+	default:
+		return fmt.Errorf("event data cannot be read from %T", src)
+	}
+	return nil
+}
+
+// Publish writes an event about the record with the given id to the outbox
+// of tx, data is sent as the event's data. It has to be called with the
+// transaction of the change, so the event is dropped when the change is
+// rolled back.
+func Publish(tx *gorm.DB, eventType Type, id uint, data interface{}) error {
+	doc, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("event %s cannot be encoded: %w", eventType, err)
+	}
+	return tx.Create(&Event{
+		OccurredAt: time.Now().UTC(),
+		Type:       eventType,
+		Entity:     eventType.Entity(),
+		EntityID:   id,
+		RequestID:  middleware.RequestIDFromContext(tx.Statement.Context),
+		Data:       doc,
+	}).Error
+}
+
+// Entity is the lower case name of the entity events of the type are
+// about, e.g. book.
+func (t Type) Entity() string {
+	if strings.HasPrefix(string(t), "Author") {
+		return "author"
+	}
+	return "book"
+}
+
+// ParseType checks the name of an event type.
+func ParseType(name string) (Type, error) {
+	for _, t := range Types {
+		if Type(name) == t {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", name)
+}
@@ -0,0 +1,157 @@
+// Package export writes book records as CSV, JSON Lines or MARC-XML. Every
+// writer encodes records one by one so the catalog can be streamed without
+// loading it into memory.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type Format string
+
+const (
+	CSV       Format = "csv"
+	JSONLines Format = "jsonl"
+	MARCXML   Format = "marcxml"
+)
+
+// Record is a single exported book joined with its author and publisher.
+type Record struct {
+	ID            uint   `json:"id"`
+	Title         string `json:"title"`
+	Page          int    `json:"page"`
+	Stock         int    `json:"stock"`
+	Price         string `json:"price"`
+	StockCode     string `json:"stockCode"`
+	ISBN          string `json:"ISBN"`
+	Format        string `json:"format,omitempty"`
+	Edition       int    `json:"edition,omitempty"`
+	PublishedYear int    `json:"publishedYear,omitempty"`
+	AuthorID      *uint  `json:"AuthorID"`
+	AuthorName    string `json:"AuthorName"`
+	PublisherName string `json:"PublisherName,omitempty"`
+}
+
+// Writer encodes records to an underlying io.Writer. Close must be called
+// after the last record to complete the document.
+type Writer interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// ParseFormat returns the format named s, CSV when s is empty.
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(s); format {
+	case "":
+		return CSV, nil
+	case CSV, JSONLines, MARCXML:
+		return format, nil
+	default:
+		return "", fmt.Errorf("format must be %q, %q or %q", CSV, JSONLines, MARCXML)
+	}
+}
+
+// NewWriter returns the writer of the given format.
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w), nil
+	case JSONLines:
+		return &jsonLinesWriter{encoder: json.NewEncoder(w)}, nil
+	case MARCXML:
+		return newMARCWriter(w)
+	default:
+		return nil, fmt.Errorf("format must be %q, %q or %q", CSV, JSONLines, MARCXML)
+	}
+}
+
+// ContentType returns the media type of the given format.
+func ContentType(format Format) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case JSONLines:
+		return "application/x-ndjson"
+	case MARCXML:
+		return "application/marcxml+xml"
+	}
+	return "application/octet-stream"
+}
+
+// FileExtension returns the usual extension of files of the given format.
+func FileExtension(format Format) string {
+	if format == MARCXML {
+		return "xml"
+	}
+	return string(format)
+}
+
+var csvHeader = []string{
+	"id", "title", "page", "stock", "price", "stock_code", "isbn",
+	"format", "edition", "published_year", "author_id", "author_name", "publisher_name",
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+// newCSVWriter writes the header row right away, so the columns match the
+// ones the importer reads.
+func newCSVWriter(w io.Writer) *csvWriter {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	return &csvWriter{writer: writer}
+}
+
+func (c *csvWriter) Write(rec Record) error {
+	return c.writer.Write([]string{
+		strconv.FormatUint(uint64(rec.ID), 10),
+		rec.Title,
+		strconv.Itoa(rec.Page),
+		strconv.Itoa(rec.Stock),
+		rec.Price,
+		rec.StockCode,
+		rec.ISBN,
+		rec.Format,
+		optionalInt(rec.Edition),
+		optionalInt(rec.PublishedYear),
+		optionalID(rec.AuthorID),
+		rec.AuthorName,
+		rec.PublisherName,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func optionalInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+type jsonLinesWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonLinesWriter) Write(rec Record) error {
+	return j.encoder.Encode(rec)
+}
+
+func (j *jsonLinesWriter) Close() error {
+	return nil
+}
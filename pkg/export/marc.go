@@ -0,0 +1,183 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/isbn"
+)
+
+const marcNamespace = "http://www.loc.gov/MARC21/slim"
+
+// marcLeader is the leader of a new, language material, monograph record.
+// The record length and base address are not meaningful in MARC-XML and are
+// left as zeros like most MARC-XML producers do.
+const marcLeader = "00000nam a2200000 i 4500"
+
+type marcRecord struct {
+	XMLName       xml.Name           `xml:"record"`
+	Leader        string             `xml:"leader"`
+	ControlFields []marcControlField `xml:"controlfield"`
+	DataFields    []marcDataField    `xml:"datafield"`
+}
+
+type marcControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// marcWriter writes a MARC21 slim collection, one record per book.
+type marcWriter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+}
+
+func newMARCWriter(w io.Writer) (*marcWriter, error) {
+	if _, err := io.WriteString(w, xml.Header+`<collection xmlns="`+marcNamespace+`">`+"\n"); err != nil {
+		return nil, err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("  ", "  ")
+	return &marcWriter{w: w, encoder: encoder}, nil
+}
+
+func (m *marcWriter) Write(rec Record) error {
+	if err := m.encoder.Encode(marcFromRecord(rec)); err != nil {
+		return err
+	}
+	return m.encoder.Flush()
+}
+
+func (m *marcWriter) Close() error {
+	_, err := io.WriteString(m.w, "\n</collection>\n")
+	return err
+}
+
+// marcFromRecord maps a book to the MARC21 bibliographic fields:
+//
+//	001     control number (book id)
+//	020 $a  ISBN, $q format
+//	100 $a  main entry, personal name (author)
+//	245 $a  title statement
+//	250 $a  edition statement
+//	264 $b  publisher, $c date of publication
+//	300 $a  physical description (page count)
+//	365 $b  trade price
+func marcFromRecord(rec Record) marcRecord {
+	record := marcRecord{
+		Leader:        marcLeader,
+		ControlFields: []marcControlField{{Tag: "001", Value: strconv.FormatUint(uint64(rec.ID), 10)}},
+	}
+
+	if rec.ISBN != "" {
+		field := marcDataField{Tag: "020", Ind1: " ", Ind2: " ",
+			Subfields: []marcSubfield{{Code: "a", Value: isbn.Clean(rec.ISBN)}}}
+		if rec.Format != "" {
+			field.Subfields = append(field.Subfields, marcSubfield{Code: "q", Value: rec.Format})
+		}
+		record.DataFields = append(record.DataFields, field)
+	}
+
+	if rec.AuthorName != "" {
+		record.DataFields = append(record.DataFields, marcDataField{Tag: "100", Ind1: "1", Ind2: " ",
+			Subfields: []marcSubfield{{Code: "a", Value: marcInvertName(rec.AuthorName) + ","}, {Code: "e", Value: "author."}}})
+	}
+
+	// first indicator: title added entry when there is a 100 main entry
+	titleInd1 := "0"
+	if rec.AuthorName != "" {
+		titleInd1 = "1"
+	}
+	title := marcDataField{Tag: "245", Ind1: titleInd1, Ind2: strconv.Itoa(marcNonFilingCharacters(rec.Title)),
+		Subfields: []marcSubfield{{Code: "a", Value: rec.Title}}}
+	if rec.AuthorName != "" {
+		title.Subfields[0].Value += " /"
+		title.Subfields = append(title.Subfields, marcSubfield{Code: "c", Value: rec.AuthorName + "."})
+	} else {
+		title.Subfields[0].Value += "."
+	}
+	record.DataFields = append(record.DataFields, title)
+
+	if rec.Edition > 0 {
+		record.DataFields = append(record.DataFields, marcDataField{Tag: "250", Ind1: " ", Ind2: " ",
+			Subfields: []marcSubfield{{Code: "a", Value: marcOrdinal(rec.Edition) + " edition."}}})
+	}
+
+	if rec.PublisherName != "" || rec.PublishedYear > 0 {
+		field := marcDataField{Tag: "264", Ind1: " ", Ind2: "1"}
+		if rec.PublisherName != "" {
+			field.Subfields = append(field.Subfields, marcSubfield{Code: "b", Value: rec.PublisherName + ","})
+		}
+		if rec.PublishedYear > 0 {
+			field.Subfields = append(field.Subfields, marcSubfield{Code: "c", Value: strconv.Itoa(rec.PublishedYear) + "."})
+		}
+		record.DataFields = append(record.DataFields, field)
+	}
+
+	if rec.Page > 0 {
+		record.DataFields = append(record.DataFields, marcDataField{Tag: "300", Ind1: " ", Ind2: " ",
+			Subfields: []marcSubfield{{Code: "a", Value: fmt.Sprintf("%d pages", rec.Page)}}})
+	}
+
+	if rec.Price != "" {
+		record.DataFields = append(record.DataFields, marcDataField{Tag: "365", Ind1: " ", Ind2: " ",
+			Subfields: []marcSubfield{{Code: "b", Value: rec.Price}}})
+	}
+
+	return record
+}
+
+// marcInvertName turns "Frank Herbert" into "Herbert, Frank", the form of
+// personal names in 100 $a. Names already containing a comma are kept.
+func marcInvertName(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.Contains(name, ",") {
+		return name
+	}
+	i := strings.LastIndex(name, " ")
+	if i < 0 {
+		return name
+	}
+	return name[i+1:] + ", " + name[:i]
+}
+
+// marcNonFilingCharacters returns how many leading characters of an English
+// title are skipped when sorting, e.g. 4 for "The ".
+func marcNonFilingCharacters(title string) int {
+	lower := strings.ToLower(title)
+	for _, article := range []string{"the ", "an ", "a "} {
+		if strings.HasPrefix(lower, article) {
+			return len(article)
+		}
+	}
+	return 0
+}
+
+func marcOrdinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
@@ -0,0 +1,138 @@
+// Package health serves the liveness and readiness probes of the api.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown is reported by the readiness probe once the server started
+// draining its connections.
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Check is a dependency the api needs to serve requests.
+type Check struct {
+	Name string
+	// Timeout bounds the check, it defaults to two seconds.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Checker runs the readiness checks and tracks the shutdown of the server.
+type Checker struct {
+	checks       []Check
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// ShutDown makes the readiness probe fail from now on, so load balancers
+// stop sending requests before the connections are closed.
+func (c *Checker) ShutDown() {
+	c.shuttingDown.Store(true)
+}
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report is the body of the probe responses.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Live answers the liveness probe, it succeeds as long as the process
+// serves http.
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: statusOK})
+}
+
+// Ready answers the readiness probe. All checks run concurrently, the
+// probe fails with 503 if any of them fails or the server is shutting down.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+// Run runs every check and reports their results.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: statusOK, Checks: make(map[string]CheckResult, len(c.checks)+1)}
+
+	shutdown := CheckResult{Status: statusOK}
+	if c.shuttingDown.Load() {
+		shutdown = CheckResult{Status: statusUnavailable, Error: ErrShuttingDown.Error()}
+		report.Status = statusUnavailable
+	}
+	report.Checks["shutdown"] = shutdown
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status != statusOK {
+				report.Status = statusUnavailable
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func run(ctx context.Context, check Check) CheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := CheckResult{
+		Status:     statusOK,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = statusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// record is a single input row keyed by its normalized field name, see
+// fieldName.
+type record map[string]string
+
+// rowError is returned by a reader when a single row cannot be decoded, the
+// reader can still continue with the next row.
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// reader returns the records of an input one by one and io.EOF at its end.
+type reader interface {
+	Next() (record, error)
+}
+
+func newReader(in io.Reader, format Format) (reader, error) {
+	switch format {
+	case CSV:
+		return newCSVReader(in)
+	case JSONLines:
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		return &jsonLinesReader{scanner: scanner}, nil
+	case JSON:
+		return newJSONReader(in)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+// fieldName lower cases name and removes underscores, hyphens and spaces so
+// "stock_code", "StockCode" and "Stock Code" are the same field.
+func fieldName(name string) string {
+	return strings.NewReplacer("_", "", "-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+func newCSVReader(in io.Reader) (*csvReader, error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv input has no header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read csv header: %w", err)
+	}
+	for i := range header {
+		header[i] = fieldName(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	return &csvReader{reader: r, header: header}, nil
+}
+
+func (c *csvReader) Next() (record, error) {
+	values, err := c.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		// a malformed line does not stop the csv reader, the next call
+		// continues with the following line
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &rowError{err}
+		}
+		return nil, err
+	}
+	if len(values) > len(c.header) {
+		return nil, &rowError{fmt.Errorf("row has %d fields, header has %d", len(values), len(c.header))}
+	}
+
+	rec := make(record, len(values))
+	for i, value := range values {
+		rec[c.header[i]] = strings.TrimSpace(value)
+	}
+	return rec, nil
+}
+
+type jsonLinesReader struct {
+	scanner *bufio.Scanner
+}
+
+func (j *jsonLinesReader) Next() (record, error) {
+	for j.scanner.Scan() {
+		line := strings.TrimSpace(j.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var values map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &values); err != nil {
+			return nil, &rowError{fmt.Errorf("invalid json: %w", err)}
+		}
+		return jsonRecord(values), nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// jsonReader reads a single json array of objects, the format of the files
+// in pkg/mocks.
+type jsonReader struct {
+	decoder *json.Decoder
+}
+
+func newJSONReader(in io.Reader) (*jsonReader, error) {
+	decoder := json.NewDecoder(in)
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read json input: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("json input must be an array of objects")
+	}
+	return &jsonReader{decoder: decoder}, nil
+}
+
+func (j *jsonReader) Next() (record, error) {
+	if !j.decoder.More() {
+		return nil, io.EOF
+	}
+
+	var values map[string]interface{}
+	if err := j.decoder.Decode(&values); err != nil {
+		// the position in the array is lost, so the whole input fails
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	return jsonRecord(values), nil
+}
+
+func jsonRecord(values map[string]interface{}) record {
+	rec := make(record, len(values))
+	for key, value := range values {
+		var s string
+		switch v := value.(type) {
+		case nil:
+		case string:
+			s = v
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			s = strconv.FormatBool(v)
+		default:
+			encoded, _ := json.Marshal(v)
+			s = string(encoded)
+		}
+		rec[fieldName(key)] = strings.TrimSpace(s)
+	}
+	return rec
+}
+
+// get returns the first non empty value of the given field names
+func (r record) get(names ...string) string {
+	for _, name := range names {
+		if value := r[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// integer parses the first non empty value of the given field names, missing
+// values are 0
+func (r record) integer(names ...string) (int, error) {
+	value := r.get(names...)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", names[0])
+	}
+	return n, nil
+}
@@ -0,0 +1,486 @@
+// Package importer bulk imports books and authors from CSV, JSON Lines or
+// JSON array inputs.
+//
+// Every row is validated on its own and reported as created, updated,
+// skipped or failed. Rows are written in batches, each batch runs inside a
+// transaction and every row inside a savepoint, so a failing row never takes
+// the rest of its batch down. A dry run executes the very same statements
+// inside a single transaction which is rolled back at the end, so its report
+// matches the one of a real import.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/isbn"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+)
+
+type Format string
+
+const (
+	CSV       Format = "csv"
+	JSONLines Format = "jsonl"
+	JSON      Format = "json"
+)
+
+type Entity string
+
+const (
+	Books   Entity = "books"
+	Authors Entity = "authors"
+)
+
+// Conflict decides what happens to a row matching an existing record.
+type Conflict string
+
+const (
+	Skip   Conflict = "skip"
+	Update Conflict = "update"
+)
+
+type Status string
+
+const (
+	Created Status = "created"
+	Updated Status = "updated"
+	Skipped Status = "skipped"
+	Failed  Status = "failed"
+)
+
+const DefaultBatchSize = 100
+
+// Options of a single import.
+type Options struct {
+	Entity Entity
+	Format Format
+	// DryRun validates and writes every row, then rolls everything back.
+	DryRun bool
+	// CreateAuthors creates the authors referenced by name in book rows
+	// when they do not exist yet, otherwise such rows fail.
+	CreateAuthors bool
+	OnConflict    Conflict
+	BatchSize     int
+}
+
+// Validate fills the defaults of opts and checks its values.
+func (o *Options) Validate() error {
+	if o.Entity == "" {
+		o.Entity = Books
+	}
+	if o.OnConflict == "" {
+		o.OnConflict = Skip
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+
+	switch {
+	case o.Entity != Books && o.Entity != Authors:
+		return fmt.Errorf("entity must be %q or %q", Books, Authors)
+	case o.Format != CSV && o.Format != JSONLines && o.Format != JSON:
+		return fmt.Errorf("format must be %q, %q or %q", CSV, JSONLines, JSON)
+	case o.OnConflict != Skip && o.OnConflict != Update:
+		return fmt.Errorf("on conflict must be %q or %q", Skip, Update)
+	case o.BatchSize < 0 || o.BatchSize > 10000:
+		return errors.New("batch size must be between 1 and 10000")
+	}
+	return nil
+}
+
+// FormatFromContentType returns the format of a request body, or "" when the
+// content type does not identify one.
+func FormatFromContentType(contentType string) Format {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return CSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines", "application/jsonlines":
+		return JSONLines
+	case "application/json":
+		return JSON
+	}
+	return ""
+}
+
+// FormatFromFileName returns the format of a file from its extension, or ""
+// when the extension does not identify one.
+func FormatFromFileName(name string) Format {
+	switch {
+	case strings.HasSuffix(name, ".csv"):
+		return CSV
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"):
+		return JSONLines
+	case strings.HasSuffix(name, ".json"):
+		return JSON
+	}
+	return ""
+}
+
+// RowResult is the outcome of a single input row. Rows are numbered from 1
+// starting with the first data row.
+type RowResult struct {
+	Row    int      `json:"row"`
+	Status Status   `json:"status"`
+	ID     uint     `json:"id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Report summarizes an import.
+type Report struct {
+	Entity  Entity      `json:"entity"`
+	DryRun  bool        `json:"dryRun"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []RowResult `json:"rows"`
+}
+
+func (r *Report) add(results ...RowResult) {
+	for _, result := range results {
+		switch result.Status {
+		case Created:
+			r.Created++
+		case Updated:
+			r.Updated++
+		case Skipped:
+			r.Skipped++
+		case Failed:
+			r.Failed++
+		}
+		r.Rows = append(r.Rows, result)
+	}
+}
+
+// sort orders the rows by their position in the input, rows which cannot
+// be decoded are reported before the batch they belong to is written
+func (r *Report) sort() {
+	sort.SliceStable(r.Rows, func(i, j int) bool { return r.Rows[i].Row < r.Rows[j].Row })
+}
+
+// errDryRun rolls back the transaction of a dry run.
+var errDryRun = errors.New("dry run")
+
+type Importer struct {
+	db *gorm.DB
+}
+
+func New(db *gorm.DB) *Importer {
+	return &Importer{db: db}
+}
+
+type pendingRow struct {
+	row    int
+	record record
+}
+
+// Import reads every row of in and writes it according to opts. The returned
+// error is only set when the input cannot be read any further or ctx is
+// done, problems of single rows are part of the report. Rows written before
+// ctx was done stay written.
+func (i *Importer) Import(ctx context.Context, in io.Reader, opts Options) (*Report, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := newReader(in, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		return i.run(ctx, i.db, rows, opts)
+	}
+
+	// the batches of a dry run are savepoints of a single transaction, so
+	// later rows see the ones before like in a real import
+	var report *Report
+	var runErr error
+	err = i.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		report, runErr = i.run(ctx, tx, rows, opts)
+		return errDryRun
+	})
+	if err != nil && !errors.Is(err, errDryRun) && runErr == nil {
+		runErr = err
+	}
+	return report, runErr
+}
+
+// run imports the rows into db batch by batch.
+func (i *Importer) run(ctx context.Context, db *gorm.DB, rows reader, opts Options) (*Report, error) {
+	report := &Report{Entity: opts.Entity, DryRun: opts.DryRun, Rows: []RowResult{}}
+	batch := make([]pendingRow, 0, opts.BatchSize)
+	row := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			report.sort()
+			return report, fmt.Errorf("stopped before row %d: %w", row+1, err)
+		}
+
+		rec, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		row++
+
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			report.add(RowResult{Row: row, Status: Failed, Errors: []string{rowErr.Error()}})
+			continue
+		}
+		if err != nil {
+			// flush what was read so far, the report shows where it stopped
+			report.add(i.writeBatch(ctx, db, batch, opts)...)
+			report.sort()
+			return report, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		batch = append(batch, pendingRow{row: row, record: rec})
+		if len(batch) == opts.BatchSize {
+			report.add(i.writeBatch(ctx, db, batch, opts)...)
+			batch = batch[:0]
+		}
+	}
+	report.add(i.writeBatch(ctx, db, batch, opts)...)
+	report.sort()
+
+	return report, nil
+}
+
+// writeBatch writes the rows of a batch in a single transaction, each row in
+// its own savepoint.
+func (i *Importer) writeBatch(ctx context.Context, db *gorm.DB, batch []pendingRow, opts Options) []RowResult {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	results := make([]RowResult, 0, len(batch))
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, pending := range batch {
+			result := RowResult{Row: pending.row}
+			err := tx.Transaction(func(rowTx *gorm.DB) error {
+				var err error
+				if opts.Entity == Authors {
+					result.Status, result.ID, err = importAuthor(rowTx, pending.record, opts)
+				} else {
+					result.Status, result.ID, err = importBook(rowTx, pending.record, opts)
+				}
+				return err
+			})
+			if err != nil {
+				result.Status, result.ID, result.Errors = Failed, 0, problems(err)
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+
+	if err != nil {
+		// the batch could not be committed, none of its rows were written
+		for j := range results {
+			if results[j].Status != Failed {
+				results[j].Status, results[j].ID, results[j].Errors = Failed, 0, []string{err.Error()}
+			}
+		}
+	}
+	return results
+}
+
+func problems(err error) []string {
+	var validationErr *models.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Problems
+	}
+	return []string{err.Error()}
+}
+
+func importBook(tx *gorm.DB, rec record, opts Options) (Status, uint, error) {
+	var problems []string
+	number := func(names ...string) int {
+		n, err := rec.integer(names...)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		return n
+	}
+
+	id := number("id")
+	book := models.Book{
+		Title:     rec.get("title"),
+		Page:      number("page", "pages"),
+		Stock:     number("stock"),
+		Price:     rec.get("price"),
+		StockCode: rec.get("stockcode"),
+		ISBN:      rec.get("isbn"),
+	}
+	authorID := uint(number("authorid"))
+	authorName := rec.get("author", "authorname")
+
+	if err := book.Validate(); err != nil {
+		problems = append(problems, err.(*models.ValidationError).Problems...)
+	}
+	if len(problems) > 0 {
+		return Failed, 0, &models.ValidationError{Problems: problems}
+	}
+
+	if book.ISBN != "" {
+		book.ISBN, _ = isbn.Normalize(book.ISBN)
+	}
+
+	author, err := resolveAuthor(tx, authorID, authorName, opts.CreateAuthors)
+	if err != nil {
+		return Failed, 0, err
+	}
+	book.AuthorID = &author
+
+	var existing models.Book
+	var result *gorm.DB
+	switch {
+	case id > 0:
+		result = tx.Limit(1).Find(&existing, id)
+	case book.ISBN != "":
+		result = tx.Where("isbn = ?", book.ISBN).Limit(1).Find(&existing)
+	}
+	if result != nil && result.Error != nil {
+		return Failed, 0, result.Error
+	}
+
+	if existing.ID == 0 {
+		if err := tx.Omit("Work", "Publisher", "Categories", "Tags").Create(&book).Error; err != nil {
+			return Failed, 0, err
+		}
+		if err := events.Publish(tx, events.BookCreated, book.ID, book); err != nil {
+			return Failed, 0, err
+		}
+		return Created, book.ID, nil
+	}
+
+	if opts.OnConflict == Skip {
+		return Skipped, existing.ID, nil
+	}
+	// the update assigns the new values to existing
+	previous := existing.Stock
+	book.Version = existing.Version + 1
+	result = tx.Model(&existing).
+		Where("version = ?", existing.Version).
+		Select("title", "page", "stock", "price", "stock_code", "isbn", "author_id", "version").
+		Updates(&book)
+	if result.Error != nil {
+		return Failed, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return Failed, 0, fmt.Errorf("book %d was changed concurrently", existing.ID)
+	}
+
+	var updated models.Book
+	if err := tx.First(&updated, existing.ID).Error; err != nil {
+		return Failed, 0, err
+	}
+	if err := events.Publish(tx, events.BookUpdated, updated.ID, updated); err != nil {
+		return Failed, 0, err
+	}
+	if updated.Stock != previous {
+		err := events.Publish(tx, events.StockChanged, updated.ID,
+			events.StockChange{BookID: updated.ID, Previous: previous, Stock: updated.Stock})
+		if err != nil {
+			return Failed, 0, err
+		}
+	}
+	return Updated, existing.ID, nil
+}
+
+// resolveAuthor returns the id of the author referenced by id or name,
+// creating the author by name when allowed.
+func resolveAuthor(tx *gorm.DB, id uint, name string, create bool) (uint, error) {
+	var author models.Author
+
+	if id > 0 {
+		if err := tx.Limit(1).Find(&author, id).Error; err != nil {
+			return 0, err
+		}
+		if author.ID == 0 {
+			return 0, fmt.Errorf("author %d does not exist", id)
+		}
+		return author.ID, nil
+	}
+
+	if name == "" {
+		return 0, errors.New("author id or author name is required")
+	}
+	if err := tx.Where("LOWER(name) = LOWER(?)", name).Limit(1).Find(&author).Error; err != nil {
+		return 0, err
+	}
+	if author.ID != 0 {
+		return author.ID, nil
+	}
+	if !create {
+		return 0, fmt.Errorf("author %q does not exist", name)
+	}
+
+	author.Name = name
+	if err := tx.Omit("Books").Create(&author).Error; err != nil {
+		return 0, err
+	}
+	if err := events.Publish(tx, events.AuthorCreated, author.ID, author); err != nil {
+		return 0, err
+	}
+	return author.ID, nil
+}
+
+func importAuthor(tx *gorm.DB, rec record, opts Options) (Status, uint, error) {
+	id, err := rec.integer("id")
+	if err != nil {
+		return Failed, 0, err
+	}
+	author := models.Author{Name: rec.get("name", "authorname", "author")}
+	if err := author.Validate(); err != nil {
+		return Failed, 0, err
+	}
+
+	var existing models.Author
+	if id > 0 {
+		err = tx.Limit(1).Find(&existing, id).Error
+	} else {
+		err = tx.Where("LOWER(name) = LOWER(?)", author.Name).Limit(1).Find(&existing).Error
+	}
+	if err != nil {
+		return Failed, 0, err
+	}
+
+	if existing.ID == 0 {
+		if err := tx.Omit("Books").Create(&author).Error; err != nil {
+			return Failed, 0, err
+		}
+		if err := events.Publish(tx, events.AuthorCreated, author.ID, author); err != nil {
+			return Failed, 0, err
+		}
+		return Created, author.ID, nil
+	}
+
+	if opts.OnConflict == Skip || existing.Name == author.Name {
+		return Skipped, existing.ID, nil
+	}
+	if err := tx.Model(&existing).Updates(map[string]interface{}{
+		"name":    author.Name,
+		"version": gorm.Expr("version + 1"),
+	}).Error; err != nil {
+		return Failed, 0, err
+	}
+
+	var updated models.Author
+	if err := tx.First(&updated, existing.ID).Error; err != nil {
+		return Failed, 0, err
+	}
+	if err := events.Publish(tx, events.AuthorUpdated, updated.ID, updated); err != nil {
+		return Failed, 0, err
+	}
+	return Updated, existing.ID, nil
+}
@@ -0,0 +1,170 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a sqlite database with the tables an import writes.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "import.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.Author{}, &models.Book{}, &events.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE UNIQUE INDEX idx_books_isbn ON books (isbn) WHERE deleted_at IS NULL AND isbn <> ''").Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func count(t *testing.T, db *gorm.DB, model interface{}) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Model(model).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// statuses lists the rows of report as "row:status".
+func statuses(report *Report) []string {
+	rows := make([]string, len(report.Rows))
+	for i, row := range report.Rows {
+		rows[i] = fmt.Sprintf("%d:%s", row.Row, row.Status)
+	}
+	return rows
+}
+
+const booksCSV = `title,pages,stock,price,isbn,author,author_id
+Dune,412,3,9.99,0-441-01359-7,Frank Herbert,
+,100,1,1.00,,Frank Herbert,
+Children of Dune,many,1,1.00,,Frank Herbert,
+Dune Messiah,256,1,1.00,,Frank "Herbert,
+Dune again,412,3,9.99,9780441013593,frank herbert,
+Hyperion,482,2,8.99,,Dan Simmons,
+Excession,451,1,10.00,,,99
+`
+
+func TestImportReport(t *testing.T) {
+	wantRows := []string{"1:created", "2:failed", "3:failed", "4:failed", "5:skipped", "6:created", "7:failed"}
+	wantErrors := map[int]string{
+		2: "title is required",
+		3: "page must be an integer",
+		4: `bare " in non-quoted-field`,
+		7: "author 99 does not exist",
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		name := "write"
+		if dryRun {
+			name = "dry run"
+		}
+		t.Run(name, func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.Create(&models.Author{Name: "Dan Simmons"}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			// a batch size of 2 spreads the rows over several transactions
+			report, err := New(db).Import(context.Background(), strings.NewReader(booksCSV), Options{
+				Format: CSV, BatchSize: 2, DryRun: dryRun, CreateAuthors: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statuses(report); !reflect.DeepEqual(got, wantRows) {
+				t.Errorf("rows %v, want %v", got, wantRows)
+			}
+			if report.DryRun != dryRun || report.Created != 2 || report.Skipped != 1 || report.Failed != 4 || report.Updated != 0 {
+				t.Errorf("report %+v", report)
+			}
+			for _, row := range report.Rows {
+				want, ok := wantErrors[row.Row]
+				if ok != (len(row.Errors) > 0) || ok && !strings.Contains(strings.Join(row.Errors, "; "), want) {
+					t.Errorf("row %d errors %q, want %q", row.Row, row.Errors, want)
+				}
+			}
+			if report.Rows[0].ID == 0 || report.Rows[4].ID != report.Rows[0].ID {
+				t.Errorf("row 5 skipped for book %d, want the book of row 1, %d", report.Rows[4].ID, report.Rows[0].ID)
+			}
+
+			books, authors, outbox := count(t, db, &models.Book{}), count(t, db, &models.Author{}), count(t, db, &events.Event{})
+			if dryRun {
+				if books != 0 || authors != 1 || outbox != 0 {
+					t.Errorf("dry run left %d books, %d authors, %d events", books, authors, outbox)
+				}
+				return
+			}
+			// Frank Herbert was created with the row of Dune
+			if books != 2 || authors != 2 || outbox != 3 {
+				t.Errorf("import left %d books, %d authors, %d events", books, authors, outbox)
+			}
+		})
+	}
+}
+
+// TestImportSavepoints checks that a row the database rejects is rolled back
+// alone, the other rows of its batch are still written.
+func TestImportSavepoints(t *testing.T) {
+	db := newTestDB(t)
+	author := models.Author{Name: "Frank Herbert"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range []models.Book{
+		{Title: "Dune", Page: 412, Stock: 3, ISBN: "978-0-441-01359-3", AuthorID: &author.ID},
+		{Title: "Dune Messiah", Page: 256, Stock: 1, ISBN: "978-0-306-40615-7", AuthorID: &author.ID},
+	} {
+		if err := db.Create(&book).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// row 2 gives Dune the ISBN of Dune Messiah, which the unique index
+	// rejects after the update of row 1 was made in the same transaction
+	in := `{"id":1,"title":"Dune","page":412,"stock":5,"isbn":"9780441013593","authorId":1}
+{"id":1,"title":"Dune","page":412,"stock":7,"isbn":"9780306406157","authorId":1}
+{"title":"Children of Dune","page":444,"stock":2,"authorId":1}
+`
+	report, err := New(db).Import(context.Background(), strings.NewReader(in), Options{
+		Format: JSONLines, OnConflict: Update,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := statuses(report), []string{"1:updated", "2:failed", "3:created"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows %v, want %v: %+v", got, want, report.Rows)
+	}
+	if report.Rows[1].ID != 0 || len(report.Rows[1].Errors) != 1 || !strings.Contains(report.Rows[1].Errors[0], "UNIQUE") {
+		t.Errorf("failed row %+v", report.Rows[1])
+	}
+
+	var dune models.Book
+	if err := db.First(&dune, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if dune.Stock != 5 || dune.ISBN != "978-0-441-01359-3" || dune.Version != 2 {
+		t.Errorf("Dune has stock %d, ISBN %s, version %d, want the update of row 1", dune.Stock, dune.ISBN, dune.Version)
+	}
+	if n := count(t, db, &models.Book{}); n != 3 {
+		t.Errorf("%d books, want 3", n)
+	}
+}
@@ -0,0 +1,167 @@
+// Package isbn validates, converts and hyphenates ISBN-10 and ISBN-13 numbers.
+//
+// Books are stored with the normalized form returned by Normalize, which is
+// the hyphenated ISBN-13, so the same book sent as "0-306-40615-2" or
+// "9780306406157" ends up with a single representation. Only the ranges of
+// the registration groups in ranges.go are known, ISBNs of the other groups
+// are normalized to the unhyphenated ISBN-13.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidLength    = errors.New("isbn must have 10 or 13 digits")
+	ErrInvalidCharacter = errors.New("isbn contains invalid characters")
+	ErrInvalidChecksum  = errors.New("isbn check digit does not match")
+	ErrInvalidPrefix    = errors.New("isbn-13 must start with 978 or 979")
+	ErrNoISBN10         = errors.New("only isbn-13 numbers starting with 978 have an isbn-10 form")
+	ErrUnknownRange     = errors.New("isbn registrant ranges of its registration group are unknown")
+)
+
+// Clean removes hyphens and spaces from s and upper cases the ISBN-10 check
+// character. The result is not validated.
+func Clean(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '-' || r == ' ':
+			continue
+		case r == 'x':
+			b.WriteRune('X')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Validate checks the length, characters and check digit of an ISBN-10 or
+// ISBN-13, hyphens and spaces are ignored.
+func Validate(s string) error {
+	s = Clean(s)
+
+	switch len(s) {
+	case 10:
+		for i, r := range s {
+			if (r < '0' || r > '9') && !(r == 'X' && i == 9) {
+				return ErrInvalidCharacter
+			}
+		}
+		if checkDigit10(s[:9]) != s[9] {
+			return ErrInvalidChecksum
+		}
+	case 13:
+		for _, r := range s {
+			if r < '0' || r > '9' {
+				return ErrInvalidCharacter
+			}
+		}
+		if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+			return ErrInvalidPrefix
+		}
+		if checkDigit13(s[:12]) != s[12] {
+			return ErrInvalidChecksum
+		}
+	default:
+		return ErrInvalidLength
+	}
+	return nil
+}
+
+// To13 returns the unhyphenated ISBN-13 form of s.
+func To13(s string) (string, error) {
+	if err := Validate(s); err != nil {
+		return "", err
+	}
+
+	s = Clean(s)
+	if len(s) == 13 {
+		return s, nil
+	}
+	body := "978" + s[:9]
+	return body + string(checkDigit13(body)), nil
+}
+
+// To10 returns the unhyphenated ISBN-10 form of s. ISBN-13 numbers with the
+// 979 prefix have no ISBN-10 form.
+func To10(s string) (string, error) {
+	if err := Validate(s); err != nil {
+		return "", err
+	}
+
+	s = Clean(s)
+	if len(s) == 10 {
+		return s, nil
+	}
+	if !strings.HasPrefix(s, "978") {
+		return "", ErrNoISBN10
+	}
+	body := s[3:12]
+	return body + string(checkDigit10(body)), nil
+}
+
+// Normalize validates s and returns it as a hyphenated ISBN-13, which is the
+// form books are stored and looked up with. ISBNs which cannot be
+// hyphenated are returned as unhyphenated ISBN-13.
+func Normalize(s string) (string, error) {
+	s13, err := To13(s)
+	if err != nil {
+		return "", err
+	}
+	hyphenated, err := hyphenate13(s13)
+	if errors.Is(err, ErrUnknownRange) {
+		return s13, nil
+	}
+	return hyphenated, err
+}
+
+// Hyphenate validates s and returns it hyphenated in its own form, an
+// ISBN-10 stays an ISBN-10. It fails with ErrUnknownRange for ISBNs whose
+// registrant ranges are not known.
+func Hyphenate(s string) (string, error) {
+	if err := Validate(s); err != nil {
+		return "", err
+	}
+
+	s = Clean(s)
+	if len(s) == 13 {
+		return hyphenate13(s)
+	}
+	// An ISBN-10 is hyphenated like its 978 counterpart without the prefix
+	// and with its own check digit.
+	s13, _ := To13(s)
+	hyphenated, err := hyphenate13(s13)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(hyphenated, "-")
+	parts = append(parts[1:len(parts)-1], s[9:])
+	return strings.Join(parts, "-"), nil
+}
+
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		digit := int(body[i] - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,103 @@
+package isbn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		isbn string
+		err  error
+	}{
+		{"0306406152", nil},
+		{"0-306-40615-2", nil},
+		{"0 306 40615 2", nil},
+		{"080442957X", nil},
+		{"080442957x", nil},
+		{"9780306406157", nil},
+		{"978-0-306-40615-7", nil},
+		{"9791090636071", nil},
+		{"0306406153", ErrInvalidChecksum},
+		{"9780306406158", ErrInvalidChecksum},
+		{"03064061X2", ErrInvalidCharacter},
+		{"97803064061X7", ErrInvalidCharacter},
+		{"9770306406157", ErrInvalidPrefix},
+		{"030640615", ErrInvalidLength},
+		{"97803064061570", ErrInvalidLength},
+		{"", ErrInvalidLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.isbn, func(t *testing.T) {
+			if err := Validate(tt.isbn); !errors.Is(err, tt.err) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.isbn, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		isbn      string
+		isbn13    string
+		isbn10    string
+		err10     error
+		normal    string
+		hyphenate string
+	}{
+		{"0-306-40615-2", "9780306406157", "0306406152", nil, "978-0-306-40615-7", "0-306-40615-2"},
+		{"9780306406157", "9780306406157", "0306406152", nil, "978-0-306-40615-7", "978-0-306-40615-7"},
+		{"080442957x", "9780804429573", "080442957X", nil, "978-0-8044-2957-3", "0-8044-2957-X"},
+		{"9780441013593", "9780441013593", "0441013597", nil, "978-0-441-01359-3", "978-0-441-01359-3"},
+		{"979-10-90636-07-1", "9791090636071", "", ErrNoISBN10, "979-10-90636-07-1", "979-10-90636-07-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.isbn, func(t *testing.T) {
+			if got, err := To13(tt.isbn); err != nil || got != tt.isbn13 {
+				t.Errorf("To13 = %q, %v, want %q", got, err, tt.isbn13)
+			}
+			if got, err := To10(tt.isbn); !errors.Is(err, tt.err10) || got != tt.isbn10 {
+				t.Errorf("To10 = %q, %v, want %q, %v", got, err, tt.isbn10, tt.err10)
+			}
+			if got, err := Normalize(tt.isbn); err != nil || got != tt.normal {
+				t.Errorf("Normalize = %q, %v, want %q", got, err, tt.normal)
+			}
+			if got, err := Hyphenate(tt.isbn); err != nil || got != tt.hyphenate {
+				t.Errorf("Hyphenate = %q, %v, want %q", got, err, tt.hyphenate)
+			}
+		})
+	}
+}
+
+func TestConvertInvalid(t *testing.T) {
+	for _, convert := range []func(string) (string, error){To13, To10, Normalize, Hyphenate} {
+		if got, err := convert("0306406153"); !errors.Is(err, ErrInvalidChecksum) || got != "" {
+			t.Errorf("got %q, %v, want %v", got, err, ErrInvalidChecksum)
+		}
+	}
+}
+
+func TestUnknownRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		isbn   string
+		normal string
+	}{
+		{"group without registrant ranges", "978-605-123-456-4", "9786051234564"},
+		{"two digit group without registrant ranges", "9788020001238", "9788020001238"},
+		{"isbn-10 of such a group", "80-200-0123-9", "9788020001238"},
+		{"979 group without registrant ranges", "9798681234562", "9798681234562"},
+		{"group range not in use", "9786601234563", "9786601234563"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Hyphenate(tt.isbn); !errors.Is(err, ErrUnknownRange) || got != "" {
+				t.Errorf("Hyphenate = %q, %v, want %v", got, err, ErrUnknownRange)
+			}
+			// books of these groups are still stored, without guessed hyphens
+			if got, err := Normalize(tt.isbn); err != nil || got != tt.normal {
+				t.Errorf("Normalize = %q, %v, want %q", got, err, tt.normal)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package isbn
+
+import "strings"
+
+// rng maps a 7 digit window of an ISBN to the length of the element starting
+// at that window, the same representation the ISBN International
+// RangeMessage.xml file uses. A length of 0 means the range is not in use.
+type rng struct {
+	start, end string
+	length     int
+}
+
+// groups holds the registration group ranges of each EAN prefix.
+var groups = map[string][]rng{
+	"978": {
+		{"0000000", "5999999", 1},
+		{"6000000", "6499999", 3},
+		{"6500000", "6599999", 2},
+		{"6600000", "6999999", 0},
+		{"7000000", "7999999", 1},
+		{"8000000", "9499999", 2},
+		{"9500000", "9899999", 3},
+		{"9900000", "9989999", 4},
+		{"9990000", "9999999", 5},
+	},
+	"979": {
+		{"0000000", "0999999", 0},
+		{"1000000", "1299999", 2},
+		{"1300000", "7999999", 0},
+		{"8000000", "8999999", 1},
+		{"9000000", "9999999", 0},
+	},
+}
+
+// registrants holds the registrant ranges of the registration groups the
+// library mostly deals with, keyed by prefix and group. ISBNs of other groups
+// cannot be hyphenated.
+var registrants = map[string][]rng{
+	// English language
+	"978-0": {
+		{"0000000", "1999999", 2},
+		{"2000000", "6999999", 3},
+		{"7000000", "8499999", 4},
+		{"8500000", "8999999", 5},
+		{"9000000", "9499999", 6},
+		{"9500000", "9999999", 7},
+	},
+	"978-1": {
+		{"0000000", "0999999", 2},
+		{"1000000", "3999999", 3},
+		{"4000000", "5499999", 4},
+		{"5500000", "8697999", 5},
+		{"8698000", "9989999", 6},
+		{"9990000", "9999999", 7},
+	},
+	// French language
+	"978-2": {
+		{"0000000", "1999999", 2},
+		{"2000000", "3499999", 3},
+		{"3500000", "3999999", 5},
+		{"4000000", "6999999", 3},
+		{"7000000", "8399999", 4},
+		{"8400000", "8999999", 5},
+		{"9000000", "9499999", 6},
+		{"9500000", "9999999", 7},
+	},
+	// German language
+	"978-3": {
+		{"0000000", "0299999", 2},
+		{"0300000", "0339999", 3},
+		{"0340000", "0369999", 4},
+		{"0370000", "0399999", 5},
+		{"0400000", "1999999", 2},
+		{"2000000", "6999999", 3},
+		{"7000000", "8499999", 4},
+		{"8500000", "8999999", 5},
+		{"9000000", "9499999", 6},
+		{"9500000", "9539999", 7},
+		{"9540000", "9699999", 5},
+		{"9700000", "9849999", 7},
+		{"9850000", "9999999", 5},
+	},
+	// Japan
+	"978-4": {
+		{"0000000", "1999999", 2},
+		{"2000000", "6999999", 3},
+		{"7000000", "8499999", 4},
+		{"8500000", "8999999", 5},
+		{"9000000", "9499999", 6},
+		{"9500000", "9999999", 7},
+	},
+	// China
+	"978-7": {
+		{"0000000", "0999999", 2},
+		{"1000000", "4999999", 3},
+		{"5000000", "7999999", 4},
+		{"8000000", "8999999", 5},
+		{"9000000", "9999999", 6},
+	},
+	// Turkey
+	"978-975": {
+		{"0000000", "0199999", 5},
+		{"0200000", "2499999", 2},
+		{"2500000", "5999999", 3},
+		{"6000000", "9199999", 4},
+		{"9200000", "9899999", 5},
+		{"9900000", "9999999", 3},
+	},
+	// France
+	"979-10": {
+		{"0000000", "1999999", 2},
+		{"2000000", "6999999", 3},
+		{"7000000", "8999999", 4},
+		{"9000000", "9759999", 5},
+		{"9760000", "9999999", 6},
+	},
+}
+
+// lookup returns the length of the element starting at the beginning of
+// digits according to ranges, or 0 when no range matches.
+func lookup(ranges []rng, digits string) int {
+	window := (digits + "0000000")[:7]
+	for _, r := range ranges {
+		if window >= r.start && window <= r.end {
+			return r.length
+		}
+	}
+	return 0
+}
+
+// hyphenate13 splits a valid, unhyphenated ISBN-13 into prefix, registration
+// group, registrant, publication and check digit. It fails with
+// ErrUnknownRange when the registration group or its registrant ranges are
+// not known, rather than guessing where the hyphens go.
+func hyphenate13(s string) (string, error) {
+	prefix, rest, check := s[:3], s[3:12], s[12:]
+
+	groupLength := lookup(groups[prefix], rest)
+	if groupLength == 0 {
+		return "", ErrUnknownRange
+	}
+	group, rest := rest[:groupLength], rest[groupLength:]
+
+	registrantLength := lookup(registrants[prefix+"-"+group], rest)
+	if registrantLength == 0 || registrantLength >= len(rest) {
+		return "", ErrUnknownRange
+	}
+	return strings.Join([]string{prefix, group, rest[:registrantLength], rest[registrantLength:], check}, "-"), nil
+}
@@ -0,0 +1,5 @@
+// Package librarypb holds the gRPC api of the library generated from
+// library.proto, run go generate after changing it.
+package librarypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative library.proto
@@ -0,0 +1,153 @@
+// Package lifecycle stops the parts of the api in order once it is asked
+// to shut down.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manager tracks the servers, background workers and resources of the api.
+// Stop shuts them down in three phases:
+//
+//  1. servers stop accepting requests and wait for the in-flight ones,
+//  2. the context of the workers is cancelled and they are waited for,
+//  3. resources such as the database pool are closed.
+//
+// Within a phase the hooks run in the reverse order of their registration.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	workers sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]int
+	servers []hook
+	closers []hook
+}
+
+// closeGrace bounds closing the resources once the deadline of Stop passed.
+const closeGrace = 5 * time.Second
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{ctx: ctx, cancel: cancel, running: make(map[string]int)}
+}
+
+// Context is cancelled once the servers stopped, work started outside of a
+// request should use it.
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Go runs fn as a background worker, it has to return soon after its
+// context is cancelled.
+func (m *Manager) Go(name string, fn func(ctx context.Context)) {
+	m.mu.Lock()
+	m.running[name]++
+	m.mu.Unlock()
+
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		defer func() {
+			m.mu.Lock()
+			m.running[name]--
+			m.mu.Unlock()
+		}()
+		fn(m.ctx)
+	}()
+}
+
+// OnShutdown registers a server, fn has to stop accepting new work and
+// wait for the in-flight work until ctx expires.
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, hook{name, fn})
+}
+
+// OnClose registers a resource which is released once the servers and the
+// workers stopped.
+func (m *Manager) OnClose(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closers = append(m.closers, hook{name, fn})
+}
+
+// Stop shuts everything down within ctx. Resources are closed even when the
+// servers or workers did not finish in time, the returned error lists every
+// part which failed or timed out.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	servers, closers := m.servers, m.closers
+	m.mu.Unlock()
+
+	var errs []error
+	errs = append(errs, run(ctx, servers)...)
+
+	m.cancel()
+	if err := m.wait(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// resources are closed even when the deadline passed, leaking them is
+	// worse than a slightly late exit
+	closeCtx := ctx
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		closeCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), closeGrace)
+		defer cancel()
+	}
+	errs = append(errs, run(closeCtx, closers)...)
+
+	return errors.Join(errs...)
+}
+
+// wait blocks until all workers returned or ctx expires.
+func (m *Manager) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		var names []string
+		for name, n := range m.running {
+			if n > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return fmt.Errorf("workers %v still running: %w", names, ctx.Err())
+	}
+}
+
+func run(ctx context.Context, hooks []hook) []error {
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		slog.Info("stopping", "component", h.name)
+		if err := h.fn(ctx); err != nil {
+			slog.Error("component did not stop cleanly", "component", h.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+	return errs
+}
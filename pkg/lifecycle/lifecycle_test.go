@@ -0,0 +1,86 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStopOrder(t *testing.T) {
+	m := New()
+	var (
+		mu    sync.Mutex
+		steps []string
+	)
+	step := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, name)
+	}
+	hook := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			step(name)
+			return nil
+		}
+	}
+
+	m.OnClose("database", hook("close database"))
+	m.OnClose("tracer", hook("close tracer"))
+	m.OnShutdown("http", func(context.Context) error {
+		// the workers keep running while the servers drain
+		if m.Context().Err() != nil {
+			t.Error("worker context cancelled before the servers stopped")
+		}
+		step("shutdown http")
+		return nil
+	})
+	m.OnShutdown("grpc", hook("shutdown grpc"))
+	m.Go("outbox", func(ctx context.Context) {
+		<-ctx.Done()
+		step("outbox stopped")
+	})
+
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "shutdown grpc, shutdown http, outbox stopped, close tracer, close database"
+	if got := strings.Join(steps, ", "); got != want {
+		t.Errorf("steps %s\nwant %s", got, want)
+	}
+}
+
+func TestStopTimesOut(t *testing.T) {
+	m := New()
+	failed := errors.New("listener already closed")
+	m.OnShutdown("http", func(context.Context) error { return failed })
+	release := make(chan struct{})
+	defer close(release)
+	m.Go("import", func(context.Context) { <-release })
+	m.Go("outbox", func(ctx context.Context) { <-ctx.Done() })
+
+	var closed bool
+	m.OnClose("database", func(ctx context.Context) error {
+		// resources are closed with a fresh grace period
+		if ctx.Err() != nil {
+			t.Error("database closed with an expired context")
+		}
+		closed = true
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := m.Stop(ctx)
+	if !closed {
+		t.Error("database was not closed after the workers timed out")
+	}
+	if !errors.Is(err, failed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want the failed server and the timeout", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "workers [import] still running") {
+		t.Errorf("error %v, want it to name the running worker only", err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package logging builds the structured logger of the api and carries the
+// request scoped logger through context.Context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Config selects the level, format and destination of the logs.
+type Config struct {
+	// Level is one of debug, info, warn or error.
+	Level string
+	// Format is json or text.
+	Format string
+	// Output is stdout, stderr or the path of a file logs are appended to.
+	Output string
+}
+
+// New returns the logger described by cfg. The returned closer releases the
+// log file, it is a no-op for stdout and stderr.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(defaultString(cfg.Level, "info"))); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+
+	var out io.Writer
+	closer := io.Closer(nopCloser{})
+	switch output := defaultString(cfg.Output, "stdout"); output {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot open log file: %w", err)
+		}
+		out, closer = file, file
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(defaultString(cfg.Format, "json")); format {
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("invalid log format %q", cfg.Format)
+	}
+
+	return slog.New(handler), closer, nil
+}
+
+type loggerKey struct{}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger when
+// there is none. Request handlers get a logger which already carries the
+// request id.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+var (
+	purchases = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purchases_total",
+		Help:      "Number of completed book purchases.",
+	})
+
+	purchasedBooks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purchased_books_total",
+		Help:      "Number of book copies sold.",
+	})
+
+	purchaseWindow = &minuteWindow{}
+
+	purchasesPerMinute = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "purchases_per_minute",
+		Help:      "Number of book purchases completed in the last minute.",
+	}, func() float64 {
+		return float64(purchaseWindow.count(time.Now()))
+	})
+)
+
+// BookPurchased records a completed purchase of quantity copies.
+func BookPurchased(quantity int) {
+	purchases.Inc()
+	purchasedBooks.Add(float64(quantity))
+	purchaseWindow.add(time.Now())
+}
+
+// minuteWindow counts events of the last minute in one second buckets.
+type minuteWindow struct {
+	mu      sync.Mutex
+	buckets [60]struct {
+		second int64
+		count  int64
+	}
+}
+
+func (m *minuteWindow) add(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	second := now.Unix()
+	bucket := &m.buckets[second%int64(len(m.buckets))]
+	if bucket.second != second {
+		bucket.second, bucket.count = second, 0
+	}
+	bucket.count++
+}
+
+func (m *minuteWindow) count(now time.Time) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total int64
+	for _, bucket := range m.buckets {
+		if now.Unix()-bucket.second < int64(len(m.buckets)) {
+			total += bucket.count
+		}
+	}
+	return total
+}
+
+// libraryCollector reads the stock gauges from the database on every
+// scrape, so they are right whichever process changed the books.
+type libraryCollector struct {
+	db         *gorm.DB
+	books      *prometheus.Desc
+	stock      *prometheus.Desc
+	authors    *prometheus.Desc
+	scrapeTime time.Duration
+}
+
+func newLibraryCollector(db *gorm.DB) *libraryCollector {
+	return &libraryCollector{
+		db: db,
+		books: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "books"),
+			"Number of books in the library.", nil, nil),
+		stock: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "books_in_stock"),
+			"Number of book copies in stock.", nil, nil),
+		authors: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "authors"),
+			"Number of authors in the library.", nil, nil),
+		scrapeTime: 5 * time.Second,
+	}
+}
+
+func (l *libraryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.books
+	ch <- l.stock
+	ch <- l.authors
+}
+
+func (l *libraryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.scrapeTime)
+	defer cancel()
+
+	var totals struct {
+		Books int64
+		Stock int64
+	}
+	if err := l.db.WithContext(ctx).
+		Raw("SELECT COUNT(*) AS books, COALESCE(SUM(stock), 0) AS stock FROM books WHERE deleted_at IS NULL").
+		Scan(&totals).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(l.books, err)
+		ch <- prometheus.NewInvalidMetric(l.stock, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(l.books, prometheus.GaugeValue, float64(totals.Books))
+		ch <- prometheus.MustNewConstMetric(l.stock, prometheus.GaugeValue, float64(totals.Stock))
+	}
+
+	var authors int64
+	if err := l.db.WithContext(ctx).
+		Raw("SELECT COUNT(*) FROM authors WHERE deleted_at IS NULL").
+		Scan(&authors).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(l.authors, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(l.authors, prometheus.GaugeValue, float64(authors))
+	}
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a sqlite database with the books and authors tables.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "metrics.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.Author{}, &models.Book{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMinuteWindow(t *testing.T) {
+	var window minuteWindow
+	start := time.Unix(1_000_000, 0)
+	window.add(start)
+	window.add(start)
+	window.add(start.Add(30 * time.Second))
+
+	tests := []struct {
+		at   time.Duration
+		want int64
+	}{
+		{30 * time.Second, 3},
+		{59 * time.Second, 3},
+		{60 * time.Second, 1},
+		{90 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := window.count(start.Add(tt.at)); got != tt.want {
+			t.Errorf("count after %s = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+
+	// a bucket is reused a minute later, its old count is dropped
+	window.add(start.Add(60 * time.Second))
+	if got := window.count(start.Add(60 * time.Second)); got != 2 {
+		t.Errorf("count after the bucket was reused = %d, want 2", got)
+	}
+}
+
+func TestMiddlewareLabelsRoutes(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(middleware.Route)
+	r.HandleFunc("/books/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := middleware.RequestID(Middleware(r))
+
+	matched := httpRequests.WithLabelValues(http.MethodGet, "/books/{id}", "418")
+	unmatched := httpRequests.WithLabelValues(http.MethodGet, "unmatched", "404")
+	before, beforeUnmatched := testutil.ToFloat64(matched), testutil.ToFloat64(unmatched)
+	for _, target := range []string{"/books/1", "/books/2", "/authors"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if got := testutil.ToFloat64(matched) - before; got != 2 {
+		t.Errorf("requests of /books/{id} = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(unmatched) - beforeUnmatched; got != 1 {
+		t.Errorf("unmatched requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(httpInFlight); got != 0 {
+		t.Errorf("requests in flight = %v, want 0", got)
+	}
+}
+
+func TestGormPluginCountsErrors(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := dbQueryErrors.WithLabelValues("query", "books")
+	before := testutil.ToFloat64(failed)
+
+	var book models.Book
+	if err := db.First(&book, 1).Error; err == nil {
+		t.Fatal("found a book in an empty table")
+	}
+	if got := testutil.ToFloat64(failed) - before; got != 0 {
+		t.Errorf("a missing record counted %v errors, want 0", got)
+	}
+	if err := db.Where("no_such_column = 1").Find(&[]models.Book{}).Error; err == nil {
+		t.Fatal("queried an unknown column")
+	}
+	if got := testutil.ToFloat64(failed) - before; got != 1 {
+		t.Errorf("a failed query counted %v errors, want 1", got)
+	}
+}
+
+func TestLibraryCollector(t *testing.T) {
+	db := newTestDB(t)
+	author := models.Author{Name: "Ursula K. Le Guin"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	books := []models.Book{
+		{Title: "A Wizard of Earthsea", Stock: 3, AuthorID: &author.ID},
+		{Title: "The Dispossessed", Stock: 4, AuthorID: &author.ID},
+		{Title: "The Lathe of Heaven", Stock: 5, AuthorID: &author.ID},
+	}
+	if err := db.Create(&books).Error; err != nil {
+		t.Fatal(err)
+	}
+	// deleted books are not counted
+	if err := db.Delete(&books[2]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP library_authors Number of authors in the library.
+# TYPE library_authors gauge
+library_authors 1
+# HELP library_books Number of books in the library.
+# TYPE library_books gauge
+library_books 2
+# HELP library_books_in_stock Number of book copies in stock.
+# TYPE library_books_in_stock gauge
+library_books_in_stock 7
+`
+	if err := testutil.CollectAndCompare(newLibraryCollector(db), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
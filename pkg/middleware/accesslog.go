@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+)
+
+// AccessLog logs one structured line per request once it is served. It must
+// run inside RequestID so the line carries the request id.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := NewStatusRecorder(w)
+
+		next.ServeHTTP(recorder, r)
+
+		route := RouteFromContext(r.Context())
+		if route == "" {
+			route = "unmatched"
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case recorder.Status() >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case recorder.Status() >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.Status()),
+			slog.Int64("bytes", recorder.Bytes()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", ClientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if principal, ok := PrincipalFromContext(r.Context()); ok {
+			attrs = append(attrs, slog.String("principal", principal.String()))
+		}
+		logging.FromContext(r.Context()).LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// ClientIP returns the address of the client connected to the server.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
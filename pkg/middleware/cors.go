@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures which cross origin requests browsers may send.
+type CORSOptions struct {
+	// AllowedOrigins are origins like https://example.com, an origin may
+	// contain * wildcards, e.g. https://*.example.com. A single * allows
+	// every origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed besides the simple
+	// ones, * allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// CORS answers preflight requests and adds the CORS headers to the
+// responses of allowed origins. It must wrap the router, preflight requests
+// are OPTIONS requests no route is registered for.
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	allowedMethods := make(map[string]bool, len(opts.AllowedMethods))
+	for _, method := range opts.AllowedMethods {
+		allowedMethods[strings.ToUpper(method)] = true
+	}
+	allowedHeaders := make(map[string]bool, len(opts.AllowedHeaders))
+	for _, header := range opts.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	allowAllOrigins := len(opts.AllowedOrigins) == 1 && opts.AllowedOrigins[0] == "*"
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge / time.Second))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !originAllowed(origin, opts.AllowedOrigins) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if preflight {
+				if !allowedMethods[r.Header.Get("Access-Control-Request-Method")] {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				requested, ok := requestedHeaders(r.Header.Get("Access-Control-Request-Headers"), allowedHeaders)
+				if !ok {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				setAllowOrigin(header, origin, allowAllOrigins && !opts.AllowCredentials, opts.AllowCredentials)
+				header.Set("Access-Control-Allow-Methods", methods)
+				if len(requested) > 0 {
+					header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+				}
+				if opts.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			setAllowOrigin(header, origin, allowAllOrigins && !opts.AllowCredentials, opts.AllowCredentials)
+			if exposed != "" {
+				header.Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setAllowOrigin(header http.Header, origin string, wildcard, credentials bool) {
+	if wildcard {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// requestedHeaders checks the headers listed in a preflight request and
+// returns them canonicalized.
+func requestedHeaders(list string, allowed map[string]bool) ([]string, bool) {
+	var headers []string
+	for _, header := range strings.Split(list, ",") {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header == "" {
+			continue
+		}
+		if !allowed["*"] && !allowed[header] && !simpleHeader(header) {
+			return nil, false
+		}
+		headers = append(headers, header)
+	}
+	return headers, true
+}
+
+// simpleHeader reports the headers browsers send without asking.
+func simpleHeader(header string) bool {
+	switch header {
+	case "Accept", "Accept-Language", "Content-Language":
+		return true
+	}
+	return false
+}
+
+// originAllowed matches origin against the allowed origins, which may
+// contain * wildcards.
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		if matchWildcard(strings.ToLower(pattern), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
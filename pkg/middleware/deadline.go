@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Deadline bounds the context of every request to timeout, so the queries
+// of slow requests are cancelled and answered with 408 Request Timeout.
+// Routes listed in routes, keyed by "METHOD template" or "template", get a
+// timeout of their own, 0 disables the deadline. It must be registered on
+// the router with Use.
+func Deadline(timeout time.Duration, routes map[string]time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := timeout
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					if override, ok := routes[r.Method+" "+template]; ok {
+						d = override
+					} else if override, ok := routes[template]; ok {
+						d = override
+					}
+				}
+			}
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ParseDeadlines parses route timeouts written as "[METHOD ]template=duration",
+// e.g. "GET /export/books=1m", into the routes of Deadline.
+func ParseDeadlines(routes []string) (map[string]time.Duration, error) {
+	deadlines := make(map[string]time.Duration, len(routes))
+	for _, route := range routes {
+		name, timeout, ok := strings.Cut(route, "=")
+		if !ok {
+			return nil, fmt.Errorf("route timeout %q is not route=duration", route)
+		}
+
+		parsed, err := time.ParseDuration(strings.TrimSpace(timeout))
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("route %s: invalid timeout %q", name, timeout)
+		}
+
+		name = strings.TrimSpace(name)
+		if method, path, ok := strings.Cut(name, " "); ok {
+			name = strings.ToUpper(method) + " " + strings.TrimSpace(path)
+		}
+		if !strings.Contains(name, "/") {
+			return nil, fmt.Errorf("route timeout %q has no route", route)
+		}
+		deadlines[name] = parsed
+	}
+	return deadlines, nil
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The interceptors of the gRPC server mirror the http middlewares: calls
+// get a request id and are logged, the full method name stands in for the
+// route.
+
+// UnaryRequestID propagates the x-request-id metadata of a call like
+// RequestID, the id is sent back in the response header.
+func UnaryRequestID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, id := callContext(ctx, info.FullMethod)
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(RequestIDHeader), id))
+	return handler(ctx, req)
+}
+
+// StreamRequestID is UnaryRequestID for streaming calls.
+func StreamRequestID(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := callContext(ss.Context(), info.FullMethod)
+	ss.SetHeader(metadata.Pairs(strings.ToLower(RequestIDHeader), id))
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+// UnaryAccessLog logs one structured line per call like AccessLog, it must
+// run inside UnaryRequestID.
+func UnaryAccessLog(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// StreamAccessLog is UnaryAccessLog for streaming calls.
+func StreamAccessLog(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// UnaryDeadline bounds the context of every unary call to timeout like
+// Deadline, unless the client sent a shorter deadline. Streams are not
+// bounded.
+func UnaryDeadline(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// MetadataValue returns the first value of the metadata key sent with the
+// call ctx belongs to.
+func MetadataValue(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// callContext returns the context of a call carrying its request info and
+// a logger with its request id.
+func callContext(ctx context.Context, method string) (context.Context, string) {
+	id := MetadataValue(ctx, strings.ToLower(RequestIDHeader))
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	ctx = context.WithValue(ctx, requestInfoKey{}, &requestInfo{id: id, route: method})
+	ctx = logging.WithContext(ctx, logging.FromContext(ctx).With("request_id", id))
+	return ctx, id
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		attrs = append(attrs, slog.String("client_ip", host))
+	}
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		attrs = append(attrs, slog.String("principal", principal.String()))
+	}
+	logging.FromContext(ctx).LogAttrs(ctx, level, "grpc call", attrs...)
+}
+
+// serverStream replaces the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import "context"
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	// Kind is user for bearer tokens and apikey for api keys.
+	Kind string
+	// ID identifies the client, it never contains the credential itself.
+	ID string
+}
+
+// String returns the principal as kind:id, e.g. apikey:3f2a9c1b.
+func (p Principal) String() string {
+	return p.Kind + ":" + p.ID
+}
+
+// SetPrincipal records the authenticated client of the request ctx belongs
+// to, the middlewares wrapping the router see it as well.
+func SetPrincipal(ctx context.Context, p Principal) {
+	if info := infoFromContext(ctx); info != nil {
+		info.principal = &p
+	}
+}
+
+// PrincipalFromContext returns the authenticated client of the request ctx
+// belongs to, ok is false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (p Principal, ok bool) {
+	if info := infoFromContext(ctx); info != nil && info.principal != nil {
+		return *info.principal, true
+	}
+	return Principal{}, false
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import "net/http"
+
+// StatusRecorder wraps a http.ResponseWriter and remembers the status code
+// and the number of body bytes written through it.
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	if recorder, ok := w.(*StatusRecorder); ok {
+		return recorder
+	}
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+func (s *StatusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *StatusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (s *StatusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer.
+func (s *StatusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Status returns the status code sent, 200 when the handler wrote nothing.
+func (s *StatusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// Bytes returns the number of body bytes written.
+func (s *StatusRecorder) Bytes() int64 {
+	return s.bytes
+}
@@ -0,0 +1,99 @@
+// Package middleware holds the http middlewares wrapped around the router.
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+)
+
+// RequestIDHeader carries the id of a request between services and back to
+// the client.
+const RequestIDHeader = "X-Request-ID"
+
+// requestInfo is shared by the middlewares of a single request. It is
+// created before routing, the route is filled in once mux matched one and
+// the principal once the client is authenticated.
+type requestInfo struct {
+	id        string
+	route     string
+	principal *Principal
+}
+
+type requestInfoKey struct{}
+
+func infoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// RequestIDFromContext returns the id of the request ctx belongs to.
+func RequestIDFromContext(ctx context.Context) string {
+	if info := infoFromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// RouteFromContext returns the path template of the route which matched the
+// request, e.g. /books/{id}, or "" when no route matched.
+func RouteFromContext(ctx context.Context) string {
+	if info := infoFromContext(ctx); info != nil {
+		return info.route
+	}
+	return ""
+}
+
+// RequestID propagates the X-Request-ID header of the request or generates a
+// new id when it is missing or malformed. The id is sent back in the
+// response header and the request's logger carries it.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{id: id})
+		ctx = logging.WithContext(ctx, logging.FromContext(ctx).With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Route records the path template of the matched route for the middlewares
+// running before routing. It must be registered on the router with Use.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := infoFromContext(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts ids of up to 128 printable ascii characters, so a
+// client cannot inject anything into the logs through the header.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
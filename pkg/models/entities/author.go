@@ -9,8 +9,10 @@ import (
 // Author represents body of author requests.
 type Author struct {
 	gorm.Model
-	Name  string `json:"Name"`
-	Books []Book `json:"Books,omitempty" gorm:"foreignKey:AuthorID;references:id"`
+	// Version is incremented by every update, it is sent as the ETag.
+	Version uint   `json:"version" gorm:"not null;default:1"`
+	Name    string `json:"Name"`
+	Books   []Book `json:"Books,omitempty" gorm:"foreignKey:AuthorID;references:id"`
 }
 
 func (a *Author) toString() string {
@@ -18,12 +20,9 @@ func (a *Author) toString() string {
 		a.ID, a.Name, a.CreatedAt.Format("2006-01-02 15:04:05"))
 }
 
-func (a *Author) BeforeDelete(tx *gorm.DB) (err error) {
-	fmt.Printf("Author (%s) deleting...\n", a.Name)
-	return nil
-}
-
-func (a *Author) AfterDelete(tx *gorm.DB) (err error) {
-	fmt.Printf("Author (%s) deleted...\n", a.Name)
+// BeforeCreate starts every new author at the first version, whatever the
+// client sent.
+func (a *Author) BeforeCreate(tx *gorm.DB) (err error) {
+	a.Version = 1
 	return nil
 }
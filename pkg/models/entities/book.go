@@ -6,65 +6,35 @@ import (
 	"gorm.io/gorm"
 )
 
-// a list of books as api response
-// swagger:response booksResponseSlice
-type booksResponseSlice struct{
-	// All books in the system
-	// in: body
-	Body []BookResponse
-}
-
-// book as api response
-// swagger:response bookResponse
-type bookResponse struct{
-	// All books in the system
-	// in: body
-	Body BookResponse
-}
+// Book is a single edition of a work, it carries everything which differs
+// between printings: ISBN, page count, price and stock. Its fields are
+// always sent, so a fetched book can be sent back as a whole with PUT.
+type Book struct {
+	gorm.Model
+	// Version is incremented by every update, it is sent as the ETag.
+	Version   uint   `json:"version" gorm:"not null;default:1"`
+	Title     string `json:"title"`
+	Page      int    `json:"page"`
+	Stock     int    `json:"stock"`
+	Price     string `json:"price"`
+	StockCode string `json:"stockCode"`
+	ISBN      string `json:"ISBN"`
+	// AuthorID is null for books whose author was deleted with the orphan
+	// policy.
+	AuthorID *uint `json:"AuthorID"`
 
-// swagger:parameters GetBookByID DeleteBook
-type bookIdParameter struct{
-	// The id of the Book to perform operations on the database
-	// in: path
-	// required: yes
-	ID int `json:"id"`
-}
+	// edition information
+	WorkID        *uint  `json:"WorkID"`
+	PublisherID   *uint  `json:"PublisherID"`
+	Format        string `json:"format"`
+	Edition       int    `json:"edition"`
+	PublishedYear int    `json:"publishedYear"`
 
-// Books request model.
-// swagger:model
-type BookRequest struct {
-	ID	int64	`json:"id"`
-	Title     string `json:"title,omitempty"`
-	Page      int    `json:"page,omitempty"`
-	Stock     int    `json:"stock,omitempty"`  
-	Price     string `json:"price,omitempty"`
-	StockCode string `json:"stockCode,omitempty"`
-	ISBN      string `json:"ISBN,omitempty"`
-	AuthorID  uint   `json:"AuthorID,omitempty"`
-}
-
-// Books response model.
-// swagger:model
-type BookResponse struct {
-	ID	int64	`json:"id"`
-	Title     string `json:"title,omitempty"`
-	Page      int    `json:"page,omitempty"`
-	Stock     int    `json:"stock,omitempty"`  
-	Price     string `json:"price,omitempty"`
-	StockCode string `json:"stockCode,omitempty"`
-	ISBN      string `json:"ISBN,omitempty"`
-	AuthorID  uint   `json:"AuthorID,omitempty"`
-}
+	Work      *Work      `json:"Work,omitempty" gorm:"foreignKey:WorkID;references:id"`
+	Publisher *Publisher `json:"Publisher,omitempty" gorm:"foreignKey:PublisherID;references:id"`
 
-type Book struct {
-	gorm.Model
-	Title     string `json:"title,omitempty"`
-	Page      int    `json:"page,omitempty"`
-	Stock     int    `json:"stock,omitempty"`  
-	Price     string `json:"price,omitempty"`
-	StockCode string `json:"stockCode,omitempty"`
-	ISBN      string `json:"ISBN,omitempty"`
-	AuthorID  uint   `json:"AuthorID,omitempty"`
+	Categories []Category `json:"Categories,omitempty" gorm:"many2many:book_categories"`
+	Tags       []Tag      `json:"Tags,omitempty" gorm:"many2many:book_tags"`
 }
 
 // Books represents body of book requests with author information.
@@ -76,23 +46,31 @@ type Books struct {
 	Price     string `json:"price,omitempty"`
 	StockCode string `json:"stockCode,omitempty"`
 	ISBN      string `json:"ISBN,omitempty"`
-	AuthorID  uint   `json:"AuthorID,omitempty"`
+	AuthorID  *uint  `json:"AuthorID,omitempty"`
+
+	// edition information
+	WorkID        *uint  `json:"WorkID,omitempty"`
+	PublisherID   *uint  `json:"PublisherID,omitempty"`
+	Format        string `json:"format,omitempty"`
+	Edition       int    `json:"edition,omitempty"`
+	PublishedYear int    `json:"publishedYear,omitempty"`
 
 	// the author information for this book
-	Authors	Author	`json:"Authors,omitempty" gorm:"foreignkey:id;references:AuthorID"`
+	Authors Author `json:"Authors,omitempty" gorm:"foreignkey:id;references:AuthorID"`
 }
 
 func (b *Book) toString() string {
-	return fmt.Sprintf("ID : %d, Title : %s, Page : %d, Stock : %d, Price : %s, StockCode : %s, ISBN : %s, AuthorID : %d, CreatedAt : %s",
-		b.ID, b.Title, b.Page, b.Stock, b.Price, b.StockCode, b.ISBN, b.AuthorID, b.CreatedAt.Format("2006-01-02 15:04:05"))
-}
-
-func (b *Book) BeforeDelete(tx *gorm.DB) (err error) {
-	fmt.Printf("Book (%s) deleting...\n", b.Title)
-	return nil
+	authorID := "none"
+	if b.AuthorID != nil {
+		authorID = fmt.Sprint(*b.AuthorID)
+	}
+	return fmt.Sprintf("ID : %d, Title : %s, Page : %d, Stock : %d, Price : %s, StockCode : %s, ISBN : %s, AuthorID : %s, CreatedAt : %s",
+		b.ID, b.Title, b.Page, b.Stock, b.Price, b.StockCode, b.ISBN, authorID, b.CreatedAt.Format("2006-01-02 15:04:05"))
 }
 
-func (b *Book) AfterDelete(tx *gorm.DB) (err error) {
-	fmt.Printf("Book (%s) deleted...\n", b.Title)
+// BeforeCreate starts every new book at the first version, whatever the
+// client sent.
+func (b *Book) BeforeCreate(tx *gorm.DB) (err error) {
+	b.Version = 1
 	return nil
 }
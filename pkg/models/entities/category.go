@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Category represents body of category requests. Categories form a tree
+// (e.g. Fiction > Science Fiction > Cyberpunk) through ParentID.
+type Category struct {
+	gorm.Model
+	Name     string `json:"Name"`
+	ParentID *uint  `json:"ParentID,omitempty"`
+
+	Children []Category `json:"Children,omitempty" gorm:"foreignKey:ParentID;references:id"`
+	Books    []Book     `json:"Books,omitempty" gorm:"many2many:book_categories"`
+
+	// number of books directly in this category and in its whole subtree,
+	// only filled by the tree and count endpoints
+	BookCount      int64 `json:"BookCount,omitempty" gorm:"-"`
+	TotalBookCount int64 `json:"TotalBookCount,omitempty" gorm:"-"`
+}
+
+func (c *Category) toString() string {
+	return fmt.Sprintf("ID : %d, Name : %s, CreatedAt : %s",
+		c.ID, c.Name, c.CreatedAt.Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Publisher represents body of publisher requests.
+type Publisher struct {
+	gorm.Model
+	Name    string `json:"Name"`
+	Country string `json:"Country,omitempty"`
+	Website string `json:"Website,omitempty"`
+
+	// the editions released by this publisher
+	Books []Book `json:"Books,omitempty" gorm:"foreignKey:PublisherID;references:id"`
+}
+
+func (p *Publisher) toString() string {
+	return fmt.Sprintf("ID : %d, Name : %s, Country : %s, CreatedAt : %s",
+		p.ID, p.Name, p.Country, p.CreatedAt.Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Series represents body of series requests. A series groups works which
+// are meant to be read in order, the position of each work is kept on
+// Work.SeriesVolume.
+type Series struct {
+	gorm.Model
+	Name        string `json:"Name"`
+	Description string `json:"Description,omitempty"`
+
+	// the works of this series ordered by volume
+	Works []Work `json:"Works,omitempty" gorm:"foreignKey:SeriesID;references:id"`
+}
+
+func (s *Series) toString() string {
+	return fmt.Sprintf("ID : %d, Name : %s, CreatedAt : %s",
+		s.ID, s.Name, s.CreatedAt.Format("2006-01-02 15:04:05"))
+}
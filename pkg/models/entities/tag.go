@@ -0,0 +1,22 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Tag is a free-form label attached to books. Tags are identified by their
+// normalized name and are created on first use.
+type Tag struct {
+	ID        uint      `json:"ID" gorm:"primarykey"`
+	Name      string    `json:"Name" gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time `json:"CreatedAt"`
+
+	Books []Book `json:"Books,omitempty" gorm:"many2many:book_tags"`
+}
+
+// NormalizeTag trims and lower cases the tag name so "Sci-Fi " and "sci-fi"
+// end up as the same tag.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
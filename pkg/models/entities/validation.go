@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/isbn"
+)
+
+// ValidationError lists every problem found while validating an entity.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, ", ")
+}
+
+// Validate checks the fields every stored book must satisfy
+func (b *Book) Validate() error {
+	var problems []string
+	if strings.TrimSpace(b.Title) == "" {
+		problems = append(problems, "title is required")
+	}
+	if b.Page < 0 {
+		problems = append(problems, "page cannot be negative")
+	}
+	if b.Stock < 0 {
+		problems = append(problems, "stock cannot be negative")
+	}
+	if b.ISBN != "" {
+		if err := isbn.Validate(b.ISBN); err != nil {
+			problems = append(problems, "ISBN: "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Validate checks the fields every stored author must satisfy
+func (a *Author) Validate() error {
+	if strings.TrimSpace(a.Name) == "" {
+		return &ValidationError{Problems: []string{"name is required"}}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Work represents body of work requests. A work is the abstract creation of
+// an author, every printing of it (hardcover, paperback, a later edition...)
+// is stored as a Book which references the work.
+type Work struct {
+	gorm.Model
+	Title        string `json:"Title"`
+	AuthorID     uint   `json:"AuthorID,omitempty"`
+	SeriesID     *uint  `json:"SeriesID,omitempty"`
+	SeriesVolume *int   `json:"SeriesVolume,omitempty"`
+
+	Author   *Author `json:"Author,omitempty" gorm:"foreignKey:AuthorID;references:id"`
+	Series   *Series `json:"Series,omitempty" gorm:"foreignKey:SeriesID;references:id"`
+	Editions []Book  `json:"Editions,omitempty" gorm:"foreignKey:WorkID;references:id"`
+}
+
+func (wk *Work) toString() string {
+	return fmt.Sprintf("ID : %d, Title : %s, AuthorID : %d, CreatedAt : %s",
+		wk.ID, wk.Title, wk.AuthorID, wk.CreatedAt.Format("2006-01-02 15:04:05"))
+}
@@ -26,7 +26,13 @@ var (
 	BadQueryParams        = errors.New("Invalid query params")
 	InternalServerError   = errors.New("Internal Server Error")
 	RequestTimeoutError   = errors.New("Request Timeout")
+	TooManyRequests       = errors.New("Too many requests")
+	PreconditionFailed    = errors.New("Precondition failed")
+	PreconditionRequired  = errors.New("Precondition required")
+	InsufficientStock     = errors.New("Insufficient stock")
 	ExistsUserIDError     = errors.New("User with given id already exists")
+	ExistsISBNError       = errors.New("Book with given ISBN already exists")
+	InvalidISBN           = errors.New("Invalid ISBN")
 	InvalidJWTToken       = errors.New("Invalid JWT token")
 	InvalidJWTClaims      = errors.New("Invalid JWT claims")
 	NotAllowedImageHeader = errors.New("Not allowed image header")
@@ -40,9 +46,34 @@ type RestErr interface {
 }
 
 type RestError struct {
-	ErrStatus int         `json:"code,omitempty"`
-	ErrError  string      `json:"message,omitempty"`
-	ErrCauses interface{} `json:"-"`
+	ErrStatus    int         `json:"code,omitempty"`
+	ErrError     string      `json:"message,omitempty"`
+	ErrRequestID string      `json:"requestId,omitempty"`
+	ErrDetails   []Detail    `json:"details,omitempty"`
+	ErrCauses    interface{} `json:"-"`
+}
+
+// Detail points at one invalid value of a request. Parameters are named by
+// Name, values in the body by their JSON Pointer (RFC 6901), an empty
+// pointer is the whole body.
+type Detail struct {
+	In      string `json:"in"`
+	Name    string `json:"name,omitempty"`
+	Pointer string `json:"pointer,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Detail) String() string {
+	switch {
+	case d.Name != "" && d.Pointer != "":
+		return fmt.Sprintf("%s %s%s %s", d.In, d.Name, d.Pointer, d.Message)
+	case d.Name != "":
+		return fmt.Sprintf("%s %s %s", d.In, d.Name, d.Message)
+	case d.Pointer != "":
+		return fmt.Sprintf("%s %s %s", d.In, d.Pointer, d.Message)
+	default:
+		return d.In + " " + d.Message
+	}
 }
 
 // Error  Error() interface method
@@ -62,6 +93,16 @@ func NewRestError(status int, err string, causes interface{}) RestErr {
 	}
 }
 
+// WithRequestID attaches the id of the failed request to err, clients can
+// quote it to find the matching log lines
+func WithRequestID(err RestErr, requestID string) RestErr {
+	if restErr, ok := err.(RestError); ok {
+		restErr.ErrRequestID = requestID
+		return restErr
+	}
+	return err
+}
+
 func NewInternalServerError(causes interface{}) RestErr {
 	result := RestError{
 		ErrStatus: http.StatusInternalServerError,
@@ -71,8 +112,71 @@ func NewInternalServerError(causes interface{}) RestErr {
 	return result
 }
 
-// ParseErrors Parser of error string messages returns RestError
+// NewTooManyRequestsError is returned to clients which exceeded their rate
+// limit, causes tells them when to retry
+func NewTooManyRequestsError(causes interface{}) RestErr {
+	return RestError{
+		ErrStatus: http.StatusTooManyRequests,
+		ErrError:  TooManyRequests.Error(),
+		ErrCauses: causes,
+	}
+}
+
+// NewPreconditionFailedError is returned when the If-Match header of a
+// request does not name the current version of the record
+func NewPreconditionFailedError(causes interface{}) RestErr {
+	return RestError{
+		ErrStatus: http.StatusPreconditionFailed,
+		ErrError:  PreconditionFailed.Error(),
+		ErrCauses: causes,
+	}
+}
+
+// NewPreconditionRequiredError is returned when a request modifying a record
+// has no If-Match header
+func NewPreconditionRequiredError(causes interface{}) RestErr {
+	return RestError{
+		ErrStatus: http.StatusPreconditionRequired,
+		ErrError:  PreconditionRequired.Error(),
+		ErrCauses: causes,
+	}
+}
+
+// NewInsufficientStockError is returned when a purchase asks for more copies
+// than the book has in stock
+func NewInsufficientStockError(causes interface{}) RestErr {
+	return RestError{
+		ErrStatus: http.StatusConflict,
+		ErrError:  InsufficientStock.Error(),
+		ErrCauses: causes,
+	}
+}
+
+// NewValidationError is returned for requests which do not match the
+// description of the api, err is the message of BadRequest, BadQueryParams
+// or ContentType and details lists every problem found
+func NewValidationError(status int, err string, details []Detail) RestErr {
+	problems := make([]string, len(details))
+	for i, detail := range details {
+		problems[i] = detail.String()
+	}
+	return RestError{
+		ErrStatus:  status,
+		ErrError:   fmt.Sprintf("%s: %s", err, strings.Join(problems, "; ")),
+		ErrDetails: details,
+		ErrCauses:  problems,
+	}
+}
+
+// ParseErrors Parser of error string messages returns RestError. Errors
+// which already are a RestErr are returned unchanged, their message must
+// not be matched again.
 func ParseErrors(err error) RestErr {
+	var restErr RestErr
+	if errors.As(err, &restErr) {
+		return restErr
+	}
+
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return NewRestError(http.StatusNotFound, NotFound.Error(), err)
@@ -99,14 +203,14 @@ func ParseErrors(err error) RestErr {
 	case strings.Contains(strings.ToLower(err.Error()), "bcrypt"):
 		return NewRestError(http.StatusBadRequest, BadRequest.Error(), err)
 	default:
-		if restErr, ok := err.(RestErr); ok {
-			return restErr
-		}
 		return NewInternalServerError(err)
 	}
 }
 
 func parseSqlErrors(err error) RestErr {
+	if strings.Contains(err.Error(), "23505") && strings.Contains(err.Error(), "idx_books_isbn") {
+		return NewRestError(http.StatusConflict, ExistsISBNError.Error(), err)
+	}
 	if strings.Contains(err.Error(), "23505") {
 		return NewRestError(http.StatusBadRequest, ExistsUserIDError.Error(), err)
 	}
@@ -124,4 +228,4 @@ func parseRecordNotFoundErrors(err error) RestErr {
 
 func ErrorResponse(err error) (int, interface{}) {
 	return ParseErrors(err).Status(), ParseErrors(err)
-}
\ No newline at end of file
+}
@@ -0,0 +1,40 @@
+package http_errors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"precondition required is kept", NewPreconditionRequiredError("If-Match header with the ETag of the record is required"),
+			http.StatusPreconditionRequired, PreconditionRequired.Error()},
+		{"validation message is kept", NewRestError(http.StatusBadRequest, "Bad request: title is required", nil),
+			http.StatusBadRequest, "Bad request: title is required"},
+		{"wrapped rest error is kept", fmt.Errorf("saving: %w", NewPreconditionFailedError("stale")),
+			http.StatusPreconditionFailed, PreconditionFailed.Error()},
+		{"plain required message", errors.New("name is required"), http.StatusBadRequest, MissingFields.Error()},
+		{"record not found", errors.New("record not found"), http.StatusBadRequest, NotFound.Error()},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusRequestTimeout, RequestTimeoutError.Error()},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, InternalServerError.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restErr := ParseErrors(tt.err)
+			if restErr.Status() != tt.status {
+				t.Errorf("status %d, want %d", restErr.Status(), tt.status)
+			}
+			if message := restErr.(RestError).ErrError; message != tt.message {
+				t.Errorf("message %q, want %q", message, tt.message)
+			}
+		})
+	}
+}
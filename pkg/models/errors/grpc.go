@@ -0,0 +1,61 @@
+package http_errors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCodes are the gRPC codes of the statuses the api answers with,
+// statuses which are not listed are Unknown
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusRequestTimeout:        codes.DeadlineExceeded,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusPreconditionRequired:  codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusInternalServerError:   codes.Internal,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+}
+
+// GRPCCode returns the gRPC code matching the status of err
+func GRPCCode(err RestErr) codes.Code {
+	var restErr RestError
+	if errors.As(err, &restErr) {
+		switch restErr.ErrError {
+		// missing records are reported as bad requests by ParseErrors
+		case NotFound.Error():
+			return codes.NotFound
+		case ExistsISBNError.Error():
+			return codes.AlreadyExists
+		}
+	}
+	if code, ok := grpcCodes[err.Status()]; ok {
+		return code
+	}
+	return codes.Unknown
+}
+
+// GRPCStatus parses err like ParseErrors and returns it as the gRPC status
+// with the matching code, its message is the message of the RestError.
+func GRPCStatus(err error) *status.Status {
+	if errors.Is(err, context.Canceled) {
+		return status.New(codes.Canceled, err.Error())
+	}
+	parsed := ParseErrors(err)
+	message := parsed.Error()
+	var restErr RestError
+	if errors.As(parsed, &restErr) {
+		message = restErr.ErrError
+	}
+	return status.New(GRPCCode(parsed), message)
+}
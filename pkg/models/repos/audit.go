@@ -0,0 +1,109 @@
+package repos
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/audit"
+	"gorm.io/gorm"
+)
+
+// AuditRepository serves the audit trail written by audit.GormPlugin.
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (a *AuditRepository) Migration() error {
+	return a.db.AutoMigrate(&audit.Entry{})
+}
+
+// GetAuditEntries lists the audit entries, newest first. They can be
+// filtered by ?entity=book&id=<id>, ?action=, ?actor=, ?request_id= and
+// the time range ?since= and ?until= (RFC 3339), and are paged with ?page=
+// and ?per_page=. The total number of matching entries is sent in the
+// X-Total-Count header.
+func (a *AuditRepository) GetAuditEntries(w http.ResponseWriter, r *http.Request) {
+	db, err := filterAudit(replica(a.db).WithContext(r.Context()).Model(&audit.Entry{}), r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page, perPage, err := paging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// the count and the page are two statements on the same conditions
+	db = db.Session(&gorm.Session{})
+
+	var total int64
+	if result := db.Count(&total); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	entries := []audit.Entry{}
+	if result := db.Order("created_at DESC, id DESC").
+		Offset((page - 1) * perPage).Limit(perPage).
+		Find(&entries); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// filterAudit applies the filters of the audit endpoint.
+func filterAudit(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	query := r.URL.Query()
+
+	if entity := query.Get("entity"); entity != "" {
+		db = db.Where("entity = ?", strings.ToLower(entity))
+	}
+	if id := query.Get("id"); id != "" {
+		parsed, err := strconv.Atoi(id)
+		if err != nil || parsed <= 0 {
+			return nil, badQuery("id", "must be a positive integer")
+		}
+		if query.Get("entity") == "" {
+			return nil, badQuery("id", "requires entity")
+		}
+		db = db.Where("entity_id = ?", parsed)
+	}
+	if action := query.Get("action"); action != "" {
+		if _, err := audit.ParseAction(action); err != nil {
+			return nil, badQuery("action", err.Error())
+		}
+		db = db.Where("action = ?", action)
+	}
+	if actor := query.Get("actor"); actor != "" {
+		db = db.Where("actor = ?", actor)
+	}
+	if requestID := query.Get("request_id"); requestID != "" {
+		db = db.Where("request_id = ?", requestID)
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"since", "created_at >= ?"},
+		{"until", "created_at < ?"},
+	} {
+		value := query.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, badQuery(bound.param, "must be an RFC 3339 time, e.g. 2022-04-01T00:00:00Z")
+		}
+		db = db.Where(bound.condition, t)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,170 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/audit"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+)
+
+// requestContext returns the context of a request with the given id, sent
+// by principal or anonymously when it is nil.
+func requestContext(t *testing.T, id string, principal *middleware.Principal) context.Context {
+	t.Helper()
+	var ctx context.Context
+	middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal != nil {
+			middleware.SetPrincipal(r.Context(), *principal)
+		}
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(middleware.RequestIDHeader, id)
+		return req
+	}())
+	return ctx
+}
+
+func TestAuditTrail(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Use(audit.GormPlugin{Ignored: []string{events.Event{}.TableName()}}); err != nil {
+		t.Fatal(err)
+	}
+	admin := requestContext(t, "req-admin", &middleware.Principal{Kind: "apikey", ID: "3f2a9c1b"})
+	anonymous := requestContext(t, "req-anonymous", nil)
+
+	// outside of requests the system changes records
+	author := models.Author{Name: "Octavia E. Butler"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	book := models.Book{Title: "Kindred", Price: "9.99", AuthorID: &author.ID}
+	if err := db.WithContext(anonymous).Create(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WithContext(admin).Model(&book).Updates(map[string]interface{}{"title": "Dawn", "stock": 0}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// changes which leave the record as it was are not recorded
+	if err := db.WithContext(admin).Model(&book).Update("title", "Dawn").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WithContext(admin).Delete(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trash.New(db, trash.Block).RestoreBook(admin, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.New(db, trash.Block).PurgeBook(admin, book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []audit.Entry
+	if err := db.Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		entity, action, actor, requestID string
+		changed                          []string
+	}{
+		{"author", audit.Create, audit.SystemActor, "", []string{"name"}},
+		{"book", audit.Create, audit.AnonymousActor, "req-anonymous", []string{"title", "price", "author_id"}},
+		{"book", audit.Update, "apikey:3f2a9c1b", "req-admin", []string{"title"}},
+		{"book", audit.Delete, "apikey:3f2a9c1b", "req-admin", []string{"deleted_at"}},
+		{"book", audit.Restore, "apikey:3f2a9c1b", "req-admin", []string{"deleted_at", "version"}},
+		{"book", audit.Purge, "apikey:3f2a9c1b", "req-admin", []string{"title", "author_id"}},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		entry := entries[i]
+		if entry.Entity != w.entity || entry.Action != w.action || entry.Actor != w.actor || entry.RequestID != w.requestID {
+			t.Errorf("entry %d is %s %s by %s in %q, want %s %s by %s in %q", i,
+				entry.Action, entry.Entity, entry.Actor, entry.RequestID, w.action, w.entity, w.actor, w.requestID)
+		}
+		for _, column := range w.changed {
+			if _, ok := entry.Changes[column]; !ok {
+				t.Errorf("entry %d (%s %s) does not record %s: %v", i, entry.Action, entry.Entity, column, entry.Changes)
+			}
+		}
+	}
+	// the stock did not change, updated_at always does and is left out
+	if update := entries[2].Changes; len(update) != 1 || string(update["title"].Old) != `"Kindred"` || string(update["title"].New) != `"Dawn"` {
+		t.Errorf("update changes %v, want the title from Kindred to Dawn", update)
+	}
+	if created := entries[1].Changes["title"]; created.Old != nil || string(created.New) != `"Kindred"` {
+		t.Errorf("create records the title as %+v, want only the new value", created)
+	}
+	if purged := entries[5].Changes["title"]; purged.New != nil || string(purged.Old) != `"Dawn"` {
+		t.Errorf("purge records the title as %+v, want only the old value", purged)
+	}
+}
+
+func TestGetAuditEntries(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Use(audit.GormPlugin{Ignored: []string{events.Event{}.TableName()}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := requestContext(t, "req-1", &middleware.Principal{Kind: "user", ID: "ada"})
+	authors := []models.Author{{Name: "Ada Palmer"}, {Name: "Ann Leckie"}}
+	if err := db.WithContext(ctx).Create(&authors).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&authors[0]).Update("name", "Ada M. Palmer").Error; err != nil {
+		t.Fatal(err)
+	}
+	repo := NewAuditRepository(db)
+
+	tests := []struct {
+		target string
+		status int
+		total  string
+		// the actions of the page, newest first
+		actions []string
+	}{
+		{"/audit", http.StatusOK, "3", []string{audit.Update, audit.Create, audit.Create}},
+		{"/audit?entity=author&id=1", http.StatusOK, "2", []string{audit.Update, audit.Create}},
+		{"/audit?actor=user:ada&per_page=1", http.StatusOK, "2", []string{audit.Create}},
+		{"/audit?request_id=req-1&action=update", http.StatusOK, "0", nil},
+		{"/audit?since=2000-01-01T00:00:00Z&until=2000-01-02T00:00:00Z", http.StatusOK, "0", nil},
+		{"/audit?id=1", http.StatusBadRequest, "", nil},
+		{"/audit?action=rename", http.StatusBadRequest, "", nil},
+		{"/audit?since=yesterday", http.StatusBadRequest, "", nil},
+	}
+	for _, tt := range tests {
+		rec := serve(t, repo.GetAuditEntries, http.MethodGet, "/audit", tt.target, "", nil)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var entries []audit.Entry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, entry := range entries {
+			actions = append(actions, entry.Action)
+		}
+		if total := rec.Header().Get("X-Total-Count"); total != tt.total || len(actions) != len(tt.actions) {
+			t.Errorf("%s: %s in total, page %v, want %s and %v", tt.target, total, actions, tt.total, tt.actions)
+			continue
+		}
+		for i := range actions {
+			if actions[i] != tt.actions[i] {
+				t.Errorf("%s: page %v, want %v", tt.target, actions, tt.actions)
+				break
+			}
+		}
+	}
+}
@@ -1,80 +1,82 @@
 package repos
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
-	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/importer"
 	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
 	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
 	"gorm.io/gorm"
 )
 
 type AuthorRepository struct {
-	db *gorm.DB
+	db    *gorm.DB
+	trash *trash.Trash
 }
 
-func NewAuthorRepository(db *gorm.DB) *AuthorRepository {
-	return &AuthorRepository{db: db}
+// NewAuthorRepository returns the author handlers, deleting an author
+// handles the author's books according to policy.
+func NewAuthorRepository(db *gorm.DB, policy trash.Policy) *AuthorRepository {
+	return &AuthorRepository{db: db, trash: trash.New(db, policy)}
 }
 
-func (a *AuthorRepository) Migration() {
-	a.db.AutoMigrate(&models.Author{})
+func (a *AuthorRepository) Migration() error {
+	return a.db.AutoMigrate(&models.Author{})
 }
 
-// InsertSampleData inserts sample data to database
-func (a *AuthorRepository) InsertSampleData() {
+// InsertSampleData imports the sample authors in ./pkg/mocks/authors.json
+func (a *AuthorRepository) InsertSampleData() (*importer.Report, error) {
 	jsonFile, err := os.Open("./pkg/mocks/authors.json")
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 	defer jsonFile.Close()
-	values, _ := ioutil.ReadAll(jsonFile)
-	authors := []models.Author{}
-	json.Unmarshal(values, &authors)
 
-	for _, author := range authors {
-		a.db.FirstOrCreate(&author)
-	}
+	return importer.New(a.db).Import(context.Background(), jsonFile, importer.Options{
+		Entity: importer.Authors,
+		Format: importer.JSON,
+	})
 }
 
 // GetAllAuthors lists all available authors
 func (a *AuthorRepository) GetAllAuthors(w http.ResponseWriter, r *http.Request) {
 	var author []models.Author
 
-	if result := a.db.Find(&author); result.Error != nil {
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := replica(a.db).WithContext(r.Context()).Find(&author); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(author)
+	writeJSON(w, http.StatusOK, author)
 }
 
 // GetAllAuGetAuthorByID returns author information according to given id
 func (a *AuthorRepository) GetAuthorByID(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic id parameter
-	vars := mux.Vars(r)
-	id, _ := strconv.Atoi(vars["id"])
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	// Iterate over all the books
 	var author models.Author
 
-	if result := a.db.First(&author, id); result.Error != nil {
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := a.db.WithContext(r.Context()).First(&author, id); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(author)
+	if notModified(w, r, author.Version) {
+		return
+	}
+	writeVersioned(w, r, http.StatusOK, author.Version, author)
 }
 
 // AddAuthor creates a new author
@@ -84,80 +86,157 @@ func (a *AuthorRepository) AddAuthor(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
 	var author models.Author
 	json.Unmarshal(body, &author)
 
-	// Append to the Book
-	result := a.db.Create(&author)
-	if result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if err := a.createAuthor(r.Context(), &author); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	// Send a 201 created response
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(author)
+	writeJSON(w, http.StatusCreated, author)
+}
+
+// createAuthor stores a new author
+func (a *AuthorRepository) createAuthor(ctx context.Context, author *models.Author) error {
+	return a.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(author).Error; err != nil {
+			return err
+		}
+		return events.Publish(tx, events.AuthorCreated, author.ID, author)
+	})
 }
 
-// UpdateAuthor updates the given author
+// UpdateAuthor replaces the given author with the complete representation
+// sent in the body
 func (a *AuthorRepository) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, _ := strconv.Atoi(vars["id"])
+	a.modifyAuthor(w, r, func(current, body []byte) ([]byte, error) {
+		return authorRepresentation.replace(body)
+	})
+}
+
+// PatchAuthor changes the given author with a JSON Merge Patch (RFC 7396)
+// or a JSON Patch (RFC 6902) according to the content type
+func (a *AuthorRepository) PatchAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	a.modifyAuthor(w, r, func(current, body []byte) ([]byte, error) {
+		return authorRepresentation.patch(r, current, body)
+	})
+}
+
+// modifyAuthor stores the author which change computes from the stored
+// author's json document and the request body, provided the request was
+// sent for the current version
+func (a *AuthorRepository) modifyAuthor(w http.ResponseWriter, r *http.Request, change func(current, body []byte) ([]byte, error)) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 	// Read to request body
 	body, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
-	var updatedAuthor models.Author
-	updatedAuthor.ID = uint(id)
-	json.Unmarshal(body, &updatedAuthor)
+	author, err := a.changeAuthor(r.Context(), id, ifMatch(r), func(current []byte) ([]byte, error) {
+		return change(current, body)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Send a 201 created response
+	writeVersioned(w, r, http.StatusCreated, author.Version, author)
+}
 
-	// Iterate over all the Books
+// changeAuthor stores the author which change computes from the stored
+// author's json document, provided the result is a valid author and
+// precondition accepts the stored version
+func (a *AuthorRepository) changeAuthor(ctx context.Context, id uint, precondition func(version uint) error, change func(current []byte) ([]byte, error)) (models.Author, error) {
 	var author models.Author
+	if result := a.db.WithContext(ctx).First(&author, id); result.Error != nil {
+		return author, result.Error
+	}
+	if err := precondition(author.Version); err != nil {
+		return author, err
+	}
 
-	// Append to the Book
-	if result := a.db.First(&author, id); result.Error != nil {
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
-		return
+	current, err := json.Marshal(author)
+	if err != nil {
+		return author, err
+	}
+	changed, err := change(current)
+	if err != nil {
+		return author, err
 	}
 
-	a.db.Save(&updatedAuthor)
-	// Send a 201 created response
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedAuthor)
+	var updatedAuthor models.Author
+	if err := decodeStrict(changed, &updatedAuthor); err != nil {
+		return author, err
+	}
+	if err := validate(&updatedAuthor); err != nil {
+		return author, err
+	}
+
+	// Replace the author unless it was changed since it was read
+	updatedAuthor.ID = author.ID
+	updatedAuthor.CreatedAt = author.CreatedAt
+	updatedAuthor.Version = author.Version + 1
+	err = a.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&updatedAuthor).
+			Where("version = ?", author.Version).
+			Select("*").Omit("Books").
+			Updates(&updatedAuthor)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return http_errors.NewPreconditionFailedError("the author was changed concurrently")
+		}
+		return events.Publish(tx, events.AuthorUpdated, updatedAuthor.ID, updatedAuthor)
+	})
+	return updatedAuthor, err
 }
 
 // DeleteAuthor deletes given author according to given id
 func (a *AuthorRepository) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic parameter
-	vars := mux.Vars(r)
-	id, _ := strconv.Atoi(vars["id"])
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
+	if err := a.deleteAuthor(r.Context(), id, ifMatch(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Deleted")
+}
+
+// deleteAuthor deletes the author provided precondition accepts its
+// version, its books are handled according to the delete policy
+func (a *AuthorRepository) deleteAuthor(ctx context.Context, id uint, precondition func(version uint) error) error {
 	// Find the author by id
 	var author models.Author
 
-	if result := a.db.First(&author, id); result.Error != nil {
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
-		return
+	if result := a.db.WithContext(ctx).First(&author, id); result.Error != nil {
+		return result.Error
 	}
-	// Delete that book
-	a.db.Delete(&author)
-
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode("Deleted")
+	if err := precondition(author.Version); err != nil {
+		return err
+	}
+	// Delete that author unless it was changed since it was read
+	return a.trash.DeleteAuthor(ctx, &author)
 }
 
 // FindAuthorByName returns authors found according to given search query
@@ -166,62 +245,70 @@ func (a *AuthorRepository) FindAuthorByName(w http.ResponseWriter, r *http.Reque
 
 	var author []models.Author
 
-	if result := a.db.Where("name ILIKE ? ", "%"+vars["name"]+"%").Find(&author); result.Error != nil {
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := replica(a.db).WithContext(r.Context()).Where("name ILIKE ? ", "%"+vars["name"]+"%").Find(&author); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	fmt.Println(vars["name"])
+	writeJSON(w, http.StatusOK, author)
+}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+// searchAuthors returns the page of the authors whose name contains name
+// ordered by id, and how many authors match it in total
+func (a *AuthorRepository) searchAuthors(ctx context.Context, name string, offset, limit int) ([]models.Author, int64, error) {
+	db := replica(a.db).WithContext(ctx).Model(&models.Author{})
+	if name != "" {
+		db = db.Where("name ILIKE ?", "%"+name+"%")
+	}
+	db = db.Session(&gorm.Session{})
 
-	json.NewEncoder(w).Encode(author)
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var authors []models.Author
+	if err := db.Order("id").Offset(offset).Limit(limit).Find(&authors).Error; err != nil {
+		return nil, 0, err
+	}
+	return authors, total, nil
 }
 
 // GetAuthorsCount returns number of authors
 func (a *AuthorRepository) GetAuthorsCount(w http.ResponseWriter, r *http.Request) {
 	var count int
 
-	a.db.Raw("SELECT COUNT(authors.name) FROM authors WHERE authors.deleted_at is null").Scan(&count)
+	replica(a.db).WithContext(r.Context()).Raw("SELECT COUNT(authors.name) FROM authors WHERE authors.deleted_at is null").Scan(&count)
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(count)
+	writeJSON(w, http.StatusOK, count)
 }
 
 // GetAuthorWithBooksById returns author with its book information
 func (a *AuthorRepository) GetAuthorWithBooksById(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic parameter
-	vars := mux.Vars(r)
-	id, _ := strconv.Atoi(vars["id"])
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	var Author models.Author
 
-	if result := a.db.Preload("Books").First(&Author, id); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := a.db.WithContext(r.Context()).Preload("Books").First(&Author, id); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(Author)
+	writeJSON(w, http.StatusOK, Author)
 }
 
 // GetAllAuthorsWithBooksById returns all authors with their book information
 func (a *AuthorRepository) GetAllAuthorsWithBooksById(w http.ResponseWriter, r *http.Request) {
 	var Authors []models.Author
 
-	if result := a.db.Preload("Books").Find(&Authors); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := replica(a.db).WithContext(r.Context()).Preload("Books").Find(&Authors); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(Authors)
+	writeJSON(w, http.StatusOK, Authors)
 }
@@ -1,20 +1,28 @@
 package repos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/importer"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/isbn"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/metrics"
 	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
 	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
 	"gorm.io/gorm"
 )
 
+// BookPreloads are the edition associations a book can be requested with
+var BookPreloads = []string{"Work", "Work.Series", "Publisher", "Categories", "Tags"}
+
 type BookRepository struct {
 	db *gorm.DB
 }
@@ -23,84 +31,227 @@ func NewBookRepository(db *gorm.DB) *BookRepository {
 	return &BookRepository{db: db}
 }
 
-func (b *BookRepository) Migration() {
-	b.db.AutoMigrate(&models.Book{})
+func (b *BookRepository) Migration() error {
+	if err := b.db.AutoMigrate(&models.Book{}); err != nil {
+		return err
+	}
+	if b.db.Migrator().HasIndex(&models.Book{}, "idx_books_isbn") {
+		return nil
+	}
+
+	// ISBNs are unique among the books which are not deleted, the ones
+	// stored before are normalized first
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		if err := normalizeStoredISBNs(tx); err != nil {
+			return err
+		}
+		return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_books_isbn ON books (isbn) WHERE deleted_at IS NULL AND isbn <> ''").Error
+	})
 }
 
+// normalizeStoredISBNs brings the ISBNs stored before they were validated
+// into the form normalizeISBN stores. Invalid ones are cleared, as are the
+// ones of books which repeat the ISBN of an older book that is not deleted.
+func normalizeStoredISBNs(tx *gorm.DB) error {
+	var books []models.Book
+	if err := tx.Unscoped().Select("id", "isbn", "deleted_at").Where("isbn <> ''").Order("id").Find(&books).Error; err != nil {
+		return err
+	}
 
-// InsertSampleData inserts sample data to database
-func (b *BookRepository) InsertSampleData() {
+	taken := make(map[string]uint)
+	for _, book := range books {
+		normalized, err := isbn.Normalize(book.ISBN)
+		if err != nil {
+			slog.Warn("clearing invalid isbn", "book", book.ID, "isbn", book.ISBN, "error", err)
+			normalized = ""
+		} else if !book.DeletedAt.Valid {
+			if first, ok := taken[normalized]; ok {
+				slog.Warn("clearing duplicate isbn", "book", book.ID, "isbn", book.ISBN, "duplicates", first)
+				normalized = ""
+			} else {
+				taken[normalized] = book.ID
+			}
+		}
+		if normalized == book.ISBN {
+			continue
+		}
+		if err := tx.Unscoped().Model(&models.Book{}).Where("id = ?", book.ID).UpdateColumn("isbn", normalized).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeISBN validates the ISBN of the book and stores it in its
+// hyphenated ISBN-13 form, books without ISBN are left untouched
+func normalizeISBN(book *models.Book) error {
+	if book.ISBN == "" {
+		return nil
+	}
+
+	normalized, err := isbn.Normalize(book.ISBN)
+	if err != nil {
+		return http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.InvalidISBN, err), err)
+	}
+	book.ISBN = normalized
+	return nil
+}
+
+// InsertSampleData imports the sample books in ./pkg/mocks/books.json
+func (b *BookRepository) InsertSampleData() (*importer.Report, error) {
 	jsonFile, err := os.Open("./pkg/mocks/books.json")
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 	defer jsonFile.Close()
-	values, _ := ioutil.ReadAll(jsonFile)
-	books := []models.Book{}
-	json.Unmarshal(values, &books)
 
-	for _, book := range books {
-		b.db.FirstOrCreate(&book)
+	return importer.New(b.db).Import(context.Background(), jsonFile, importer.Options{
+		Entity: importer.Books,
+		Format: importer.JSON,
+	})
+}
+
+// filterBooks applies the filters of the book list endpoint:
+// ?category=<id> matches books in the category or any of its descendants,
+// ?tag=<name> matches books carrying the tag and can be repeated.
+func filterBooks(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	query := r.URL.Query()
+
+	if category := query.Get("category"); category != "" {
+		id, err := strconv.Atoi(category)
+		if err != nil || id <= 0 {
+			return nil, badQuery("category", "must be a positive integer")
+		}
+		db = db.Where("books.id IN (SELECT book_id FROM book_categories WHERE category_id IN (?))", categorySubtree(uint(id)))
+	}
+
+	for _, tag := range query["tag"] {
+		db = db.Where("books.id IN (SELECT book_tags.book_id FROM book_tags JOIN tags ON tags.id = book_tags.tag_id WHERE tags.name = ?)",
+			models.NormalizeTag(tag))
 	}
+
+	return db, nil
+}
+
+// bookSearch are the filters of the book searches of the GraphQL and gRPC
+// apis, filters left empty match every book.
+type bookSearch struct {
+	// Title matches books whose title contains it, ignoring case.
+	Title    string
+	AuthorID uint
+	// Category matches books in the category or any of its descendants.
+	Category uint
+	// Tags matches books carrying all of the tags.
+	Tags     []string
+	InStock  *bool
+	MaxPages *int
+}
+
+func (s bookSearch) apply(db *gorm.DB) *gorm.DB {
+	if s.Title != "" {
+		db = db.Where("books.title ILIKE ?", "%"+s.Title+"%")
+	}
+	if s.AuthorID != 0 {
+		db = db.Where("books.author_id = ?", s.AuthorID)
+	}
+	if s.Category != 0 {
+		db = db.Where("books.id IN (SELECT book_id FROM book_categories WHERE category_id IN (?))", categorySubtree(s.Category))
+	}
+	for _, tag := range s.Tags {
+		db = db.Where("books.id IN (SELECT book_tags.book_id FROM book_tags JOIN tags ON tags.id = book_tags.tag_id WHERE tags.name = ?)",
+			models.NormalizeTag(tag))
+	}
+	if s.InStock != nil {
+		if *s.InStock {
+			db = db.Where("books.stock > 0")
+		} else {
+			db = db.Where("books.stock <= 0")
+		}
+	}
+	if s.MaxPages != nil {
+		db = db.Where("books.page <= ?", *s.MaxPages)
+	}
+	return db
 }
 
-// swagger:route GET /books books GetAllBooks
-// Returns a list of books
-// responses:
-//  200: booksResponseSlice
+// searchBooks returns the page of the books matching search ordered by id,
+// and how many books match it in total
+func (b *BookRepository) searchBooks(ctx context.Context, search bookSearch, offset, limit int) ([]models.Book, int64, error) {
+	// the count and the page are two statements on the same conditions
+	db := search.apply(replica(b.db).WithContext(ctx).Model(&models.Book{})).Session(&gorm.Session{})
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var books []models.Book
+	if err := db.Order("books.id").Offset(offset).Limit(limit).Find(&books).Error; err != nil {
+		return nil, 0, err
+	}
+	return books, total, nil
+}
+
+// bookByISBN returns the book with the given ISBN in any of its forms
+func (b *BookRepository) bookByISBN(ctx context.Context, number string) (models.Book, error) {
+	book := models.Book{ISBN: number}
+	if err := normalizeISBN(&book); err != nil {
+		return book, err
+	}
+	err := b.db.WithContext(ctx).Where("isbn = ?", book.ISBN).Take(&book).Error
+	return book, err
+}
 
 // GetAllBooks lists all available books
 func (b *BookRepository) GetAllBooks(w http.ResponseWriter, r *http.Request) {
+	db, err := preload(replica(b.db), r, BookPreloads...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	db, err = filterBooks(db, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	var books []models.Book
 
-	if result := b.db.Find(&books); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := db.Find(&books); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(books)
+	writeJSON(w, http.StatusOK, books)
 }
 
-// swagger:route GET /books/{id} books GetBookByID
-// Returns the book of given id
-// responses:
-//  200: bookResponse
-
 // GetBookByID returns book information according to given id
 func (b *BookRepository) GetBookByID(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic id parameter
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if err!=nil{
-		fmt.Println(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+	db, err := preload(b.db, r, BookPreloads...)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Iterate over all the books
 	var book models.Book
 
-	if result := b.db.First(&book, id); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := db.First(&book, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+	if notModified(w, r, book.Version) {
 		return
-	} else {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-
-		json.NewEncoder(w).Encode(book)
 	}
-}
 
-// swagger:route POST /books/ books AddBook
-// Creates the book of given body
-// responses:
-//  201: bookResponse
+	writeVersioned(w, r, http.StatusOK, book.Version, book)
+}
 
 // AddBook creates a new book
 func (b *BookRepository) AddBook(w http.ResponseWriter, r *http.Request) {
@@ -109,34 +260,65 @@ func (b *BookRepository) AddBook(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
 	var book models.Book
-	json.Unmarshal(body, &book)
+	if err := json.Unmarshal(body, &book); err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err))
+		return
+	}
 
-	// Append to the Book
-	if result := b.db.Create(&book); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if err := b.createBook(r.Context(), &book); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	// Send a 201 created response
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(book)
+	writeJSON(w, http.StatusCreated, book)
+}
+
+// createBook validates a new book and stores it with its ISBN normalized
+func (b *BookRepository) createBook(ctx context.Context, book *models.Book) error {
+	if err := normalizeISBN(book); err != nil {
+		return err
+	}
+	if err := validate(book); err != nil {
+		return err
+	}
+
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(book).Error; err != nil {
+			return err
+		}
+		return events.Publish(tx, events.BookCreated, book.ID, book)
+	})
 }
 
-// UpdateBook updates the given book
+// UpdateBook replaces the given book with the complete representation sent
+// in the body, fields cannot be left out
 func (b *BookRepository) UpdateBook(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	b.modifyBook(w, r, func(current, body []byte) ([]byte, error) {
+		return bookRepresentation.replace(body)
+	})
+}
 
+// PatchBook changes single fields of the given book with a JSON Merge Patch
+// (RFC 7396) or a JSON Patch (RFC 6902) according to the content type
+func (b *BookRepository) PatchBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	b.modifyBook(w, r, func(current, body []byte) ([]byte, error) {
+		return bookRepresentation.patch(r, current, body)
+	})
+}
+
+// modifyBook stores the book which change computes from the stored book's
+// json document and the request body, provided the request was sent for
+// the current version
+func (b *BookRepository) modifyBook(w http.ResponseWriter, r *http.Request, change func(current, body []byte) ([]byte, error)) {
+	id, err := pathID(r, "id")
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 	// Read to request body
@@ -144,63 +326,146 @@ func (b *BookRepository) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
-	var updatedBook models.Book
-	updatedBook.ID = uint(id)
-	json.Unmarshal(body, &updatedBook)
+	book, err := b.changeBook(r.Context(), id, ifMatch(r), func(current []byte) ([]byte, error) {
+		return change(current, body)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Send a 201 created response
+	writeVersioned(w, r, http.StatusCreated, book.Version, book)
+}
 
-	// Iterate over all the Books
+// changeBook stores the book which change computes from the stored book's
+// json document, provided the result is a valid book and precondition
+// accepts the stored version
+func (b *BookRepository) changeBook(ctx context.Context, id uint, precondition func(version uint) error, change func(current []byte) ([]byte, error)) (models.Book, error) {
 	var book models.Book
+	if result := b.db.WithContext(ctx).First(&book, id); result.Error != nil {
+		return book, result.Error
+	}
+	if err := precondition(book.Version); err != nil {
+		return book, err
+	}
 
-	// Append to the Book
-	if result := b.db.First(&book, id); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
-		return
+	current, err := json.Marshal(book)
+	if err != nil {
+		return book, err
+	}
+	changed, err := change(current)
+	if err != nil {
+		return book, err
 	}
 
-	b.db.Save(&updatedBook)
-	// Send a 201 created response
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedBook)
-}
+	var updatedBook models.Book
+	if err := decodeStrict(changed, &updatedBook); err != nil {
+		return book, err
+	}
+	if err := normalizeISBN(&updatedBook); err != nil {
+		return book, err
+	}
+	if err := validate(&updatedBook); err != nil {
+		return book, err
+	}
 
-// swagger:route DELETE /books/{id} books DeleteBook
-// Deletes and returns the book of given id
-// responses:
-//  201: bookResponse
+	// Replace the book unless it was changed since it was read
+	updatedBook.ID = book.ID
+	updatedBook.CreatedAt = book.CreatedAt
+	updatedBook.Version = book.Version + 1
+	err = b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&updatedBook).
+			Where("version = ?", book.Version).
+			Select("*").Omit("Work", "Publisher", "Categories", "Tags").
+			Updates(&updatedBook)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return http_errors.NewPreconditionFailedError("the book was changed concurrently")
+		}
+		if err := events.Publish(tx, events.BookUpdated, updatedBook.ID, updatedBook); err != nil {
+			return err
+		}
+		if updatedBook.Stock == book.Stock {
+			return nil
+		}
+		return events.Publish(tx, events.StockChanged, book.ID,
+			events.StockChange{BookID: book.ID, Previous: book.Stock, Stock: updatedBook.Stock})
+	})
+	return updatedBook, err
+}
 
 // DeleteBook deletes given book according to given id
 func (b *BookRepository) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic parameter
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
-
+	id, err := pathID(r, "id")
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
+	if err := b.deleteBook(r.Context(), id, ifMatch(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, "Deleted")
+}
+
+// deleteBook deletes the book provided precondition accepts its version
+func (b *BookRepository) deleteBook(ctx context.Context, id uint, precondition func(version uint) error) error {
 	// Find the book by id
 	var book models.Book
 
-	if result := b.db.First(&book, id); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := b.db.WithContext(ctx).First(&book, id); result.Error != nil {
+		return result.Error
+	}
+	if err := precondition(book.Version); err != nil {
+		return err
+	}
+	// Delete that book unless it was changed since it was read
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("version = ?", book.Version).Delete(&book)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return http_errors.NewPreconditionFailedError("the book was changed concurrently")
+		}
+		return events.Publish(tx, events.BookDeleted, book.ID, book)
+	})
+}
+
+// GetBookByISBN returns the book with the given ISBN, the ISBN can be sent
+// in ISBN-10 or ISBN-13 form with or without hyphens
+func (b *BookRepository) GetBookByISBN(w http.ResponseWriter, r *http.Request) {
+	normalized, err := isbn.Normalize(mux.Vars(r)["isbn"])
+	if err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.InvalidISBN, err), err))
+		return
+	}
+
+	db, err := preload(b.db, r, BookPreloads...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var book models.Book
+	if result := db.Where("isbn = ?", normalized).First(&book); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+	if notModified(w, r, book.Version) {
 		return
 	}
-	// Delete that book
-	b.db.Delete(&book)
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode("Deleted")
+	writeVersioned(w, r, http.StatusOK, book.Version, book)
 }
 
 // FindBookByName returns books found according to given search query
@@ -209,134 +474,268 @@ func (b *BookRepository) FindBookByName(w http.ResponseWriter, r *http.Request)
 
 	var books []models.Book
 
-	if result := b.db.Where("title ILIKE ? ", "%"+vars["name"]+"%").Find(&books); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := replica(b.db).WithContext(r.Context()).Where("title ILIKE ? ", "%"+vars["name"]+"%").Find(&books); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, books)
+}
+
+// SetBookCategories replaces the categories of the given book with the
+// category ids sent in the body
+func (b *BookRepository) SetBookCategories(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var ids []uint
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	var book models.Book
+	if result := b.db.WithContext(r.Context()).First(&book, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	var categories []models.Category
+	if len(ids) > 0 {
+		if result := b.db.WithContext(r.Context()).Find(&categories, ids); result.Error != nil {
+			writeError(w, r, result.Error)
+			return
+		}
+		if len(categories) != len(ids) {
+			writeError(w, r, http_errors.NewRestError(http.StatusBadRequest,
+				fmt.Sprintf("%s: some categories do not exist", http_errors.BadRequest), ids))
+			return
+		}
+	}
+
+	book.Categories = categories
+	err = b.db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&book).Association("Categories").Replace(categories); err != nil {
+			return err
+		}
+		return events.Publish(tx, events.BookUpdated, book.ID, book)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	fmt.Println(vars["name"])
+	writeJSON(w, http.StatusOK, book)
+}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+// SetBookTags replaces the tags of the given book with the tag names sent in
+// the body, unknown tags are created
+func (b *BookRepository) SetBookTags(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var names []string
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	var book models.Book
+	if result := b.db.WithContext(r.Context()).First(&book, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	tags := make([]models.Tag, 0, len(names))
+	err = b.db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		seen := make(map[string]bool)
+		for _, name := range names {
+			name = models.NormalizeTag(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			tag := models.Tag{Name: name}
+			if err := tx.Where(models.Tag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+				return err
+			}
+			tags = append(tags, tag)
+		}
+		if err := tx.Model(&book).Association("Tags").Replace(tags); err != nil {
+			return err
+		}
+		book.Tags = tags
+		return events.Publish(tx, events.BookUpdated, book.ID, book)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	json.NewEncoder(w).Encode(books)
+	writeJSON(w, http.StatusOK, book)
 }
 
 // BuyBookByID buys book and returns the new state of the given book
 func (b *BookRepository) BuyBookByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := pathID(r, "id")
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
-	quantity, err := strconv.Atoi(vars["quantity"])
+	// like the gRPC and GraphQL purchases, quantities below 1 are rejected
+	quantity, err := pathID(r, "quantity")
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
-	// Find the book by id
-	var book models.Book
 
-	if result := b.db.First(&book, id); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	// Clients sending If-Match only buy the version they have seen
+	var precondition func(version uint) error
+	if r.Header.Get("If-Match") != "" {
+		precondition = ifMatch(r)
+	}
+	book, err := b.buyBook(r.Context(), id, int(quantity), precondition)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	// Send a 201 created response
+	writeVersioned(w, r, http.StatusCreated, book.Version, book)
+}
 
-	// Update that book
-	book.Stock -= quantity
-	b.db.Save(&book)
+// buyBook takes quantity copies of the book from the stock and returns the
+// bought book. The book is updated in place, so concurrent purchases are
+// all counted; with a precondition only the version it accepts is bought.
+// Buying more copies than are in stock fails with 409.
+func (b *BookRepository) buyBook(ctx context.Context, id uint, quantity int, precondition func(version uint) error) (models.Book, error) {
+	// Find the book by id
+	var book models.Book
 
-	// Send a 201 created response
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(book)
+	if result := b.db.WithContext(ctx).First(&book, id); result.Error != nil {
+		return book, result.Error
+	}
+	if precondition != nil {
+		if err := precondition(book.Version); err != nil {
+			return book, err
+		}
+	}
+	err := b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := tx.Model(&book)
+		if precondition != nil {
+			db = db.Where("version = ?", book.Version)
+		}
+		// the stock never drops below zero, Book.Validate rejects such books
+		result := db.Where("stock >= ?", quantity).Updates(map[string]interface{}{
+			"stock":   gorm.Expr("stock - ?", quantity),
+			"version": gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var current models.Book
+			if err := tx.First(&current, id).Error; err != nil {
+				return err
+			}
+			if precondition != nil && current.Version != book.Version {
+				return http_errors.NewPreconditionFailedError("the book was changed concurrently")
+			}
+			return http_errors.NewInsufficientStockError(
+				fmt.Sprintf("%d copies asked for, %d in stock", quantity, current.Stock))
+		}
+
+		// the stock before the purchase is only known inside the transaction
+		var bought models.Book
+		if err := tx.First(&bought, id).Error; err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.BookPurchased, id,
+			events.Purchase{BookID: id, Quantity: quantity, Stock: bought.Stock}); err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.StockChanged, id,
+			events.StockChange{BookID: id, Previous: bought.Stock + quantity, Stock: bought.Stock}); err != nil {
+			return err
+		}
+		book = bought
+		return nil
+	})
+	if err != nil {
+		return book, err
+	}
+	metrics.BookPurchased(quantity)
+	return book, nil
 }
 
 // GetBooksCount returns number of books
 func (b *BookRepository) GetBooksCount(w http.ResponseWriter, r *http.Request) {
 	var count int
 
-	b.db.Raw("SELECT COUNT(books.title)	FROM books WHERE books.deleted_at is null").Scan(&count)
+	replica(b.db).WithContext(r.Context()).Raw("SELECT COUNT(books.title)	FROM books WHERE books.deleted_at is null").Scan(&count)
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(count)
+	writeJSON(w, http.StatusOK, count)
 }
 
 // GetBooksWithAuthorById returns book with its author information
 func (b *BookRepository) GetBooksWithAuthorById(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic parameter
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
-
+	id, err := pathID(r, "id")
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
 	var Book models.Books
 
-	if result := b.db.Preload("Authors").First(&Book, id); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := b.db.WithContext(r.Context()).Preload("Authors").First(&Book, id); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(Book)
+	writeJSON(w, http.StatusOK, Book)
 }
 
 // GetAllBooksWithAuthorById returns all books with their author information
 func (b *BookRepository) GetAllBooksWithAuthorById(w http.ResponseWriter, r *http.Request) {
 	var Books []models.Books
 
-	if result := b.db.Preload("Authors").Find(&Books); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+	if result := replica(b.db).WithContext(r.Context()).Preload("Authors").Find(&Books); result.Error != nil {
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(Books)
+	writeJSON(w, http.StatusOK, Books)
 }
 
 // GetBooksByPagesLessThenWithAuthorInformation returns all books which have less pages then given page number
 func (b *BookRepository) GetBooksByPagesLessThenWithAuthorInformation(w http.ResponseWriter, r *http.Request) {
 	// Read dynamic parameter
-	vars := mux.Vars(r)
-	pages, err := strconv.Atoi(vars["pages"])
-
+	pages, err := pathID(r, "pages")
 	if err != nil {
-		log.Fatalln(err)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(err))
+		writeError(w, r, err)
 		return
 	}
 
 	var Books []models.Books
 
-	if result := b.db.
+	if result := replica(b.db).WithContext(r.Context()).
 		Table("books").
 		Select("*").
 		Where("books.page < ? ", pages).
 		Joins("left join authors on authors.id = books.author_id").
 		Scan(&Books); result.Error != nil {
-		fmt.Println(result.Error)
-		json.NewEncoder(w).Encode(http_errors.ParseErrors(result.Error))
+		writeError(w, r, result.Error)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	json.NewEncoder(w).Encode(Books)
+	writeJSON(w, http.StatusOK, Books)
 }
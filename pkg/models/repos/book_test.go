@@ -0,0 +1,153 @@
+package repos
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/isbn"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+)
+
+func TestMigrationNormalizesStoredISBNs(t *testing.T) {
+	db := newTestDB(t)
+	// a database from before ISBNs were validated
+	if err := db.Exec("DROP INDEX idx_books_isbn").Error; err != nil {
+		t.Fatal(err)
+	}
+	author := models.Author{Name: "Frank Herbert"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	stored := []string{"0-441-01359-7", "9780441013593", "12345", "", "978 0 441 01359 3"}
+	for i, number := range stored {
+		book := models.Book{Title: "Dune", Page: 412, Price: "9.99", ISBN: number, AuthorID: &author.ID}
+		if err := db.Create(&book).Error; err != nil {
+			t.Fatal(err)
+		}
+		// the last one is deleted, it may keep the ISBN
+		if i == len(stored)-1 {
+			if err := db.Delete(&book).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := NewBookRepository(db).Migration(); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasIndex(&models.Book{}, "idx_books_isbn") {
+		t.Fatal("index idx_books_isbn was not created")
+	}
+
+	dune, err := isbn.Normalize("9780441013593")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{dune, "", "", "", dune}
+	var books []models.Book
+	if err := db.Unscoped().Order("id").Find(&books).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, book := range books {
+		if book.ISBN != want[i] {
+			t.Errorf("book %d stored %q: ISBN %q, want %q", book.ID, stored[i], book.ISBN, want[i])
+		}
+	}
+}
+
+func TestAddBookValidates(t *testing.T) {
+	db := newTestDB(t)
+	books := NewBookRepository(db)
+	if err := db.Create(&models.Author{Name: "Frank Herbert"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		isbn   string
+	}{
+		{"invalid json", `{"title":`, http.StatusBadRequest, ""},
+		{"wrong type", `{"title":"Dune","page":"many"}`, http.StatusBadRequest, ""},
+		{"missing title", `{"page":412,"AuthorID":1}`, http.StatusBadRequest, ""},
+		{"negative stock", `{"title":"Dune","stock":-1,"AuthorID":1}`, http.StatusBadRequest, ""},
+		{"invalid isbn", `{"title":"Dune","ISBN":"0-441-01359-8","AuthorID":1}`, http.StatusBadRequest, ""},
+		{"valid", `{"title":"Dune","page":412,"ISBN":"0441013597","AuthorID":1}`, http.StatusCreated, "978-0-441-01359-3"},
+		{"unknown registrant ranges", `{"title":"Saatleri Ayarlama Enstitüsü","ISBN":"978-605-123-456-4","AuthorID":1}`, http.StatusCreated, "9786051234564"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, books.AddBook, http.MethodPost, "/books/", "/books/", tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			var book models.Book
+			if err := json.Unmarshal(rec.Body.Bytes(), &book); err != nil {
+				t.Fatal(err)
+			}
+			if book.ISBN != tt.isbn {
+				t.Errorf("ISBN %q, want %q", book.ISBN, tt.isbn)
+			}
+		})
+	}
+
+	var count int64
+	db.Model(&models.Book{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d books stored, want 2", count)
+	}
+}
+
+func TestBuyBook(t *testing.T) {
+	db := newTestDB(t)
+	books := NewBookRepository(db)
+	author := models.Author{Name: "Frank Herbert"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Book{Title: "Dune", Page: 412, Stock: 3, Price: "9.99", AuthorID: &author.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// the purchases run in order on the same book
+	tests := []struct {
+		name     string
+		quantity string
+		header   http.Header
+		status   int
+		stock    int
+	}{
+		{"zero copies", "0", nil, http.StatusBadRequest, 3},
+		{"negative copies", "-1", nil, http.StatusBadRequest, 3},
+		{"more than in stock", "4", nil, http.StatusConflict, 3},
+		{"current version", "2", http.Header{"If-Match": {`"1"`}}, http.StatusCreated, 1},
+		{"stale version", "1", http.Header{"If-Match": {`"1"`}}, http.StatusPreconditionFailed, 1},
+		{"more than left", "2", nil, http.StatusConflict, 1},
+		{"last copy", "1", nil, http.StatusCreated, 0},
+		{"sold out", "1", http.Header{"If-Match": {`"3"`}}, http.StatusConflict, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, books.BuyBookByID, http.MethodPatch, "/books/buy/{id}/{quantity}", "/books/buy/1/"+tt.quantity, "", tt.header)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var book models.Book
+			if err := db.First(&book, 1).Error; err != nil {
+				t.Fatal(err)
+			}
+			if book.Stock != tt.stock {
+				t.Errorf("stock %d, want %d", book.Stock, tt.stock)
+			}
+			// a stored book stays valid, so it can still be changed
+			if err := book.Validate(); err != nil {
+				t.Errorf("stored book is invalid: %v", err)
+			}
+		})
+	}
+}
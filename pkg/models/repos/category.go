@@ -0,0 +1,343 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CategoryRepository struct {
+	db *gorm.DB
+}
+
+func NewCategoryRepository(db *gorm.DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+func (c *CategoryRepository) Migration() error {
+	return c.db.AutoMigrate(&models.Category{})
+}
+
+// categorySubtree selects the ids of the given category and all of its
+// descendants, it can be used as a subquery argument.
+func categorySubtree(id uint) clause.Expr {
+	return gorm.Expr(`WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+		WHERE categories.deleted_at IS NULL
+	) SELECT id FROM subtree`, id)
+}
+
+// categoryCounts returns the number of books directly in each category and
+// the number of distinct books in each category's subtree.
+func categoryCounts(db *gorm.DB) (direct map[uint]int64, total map[uint]int64, err error) {
+	type count struct {
+		CategoryID uint
+		Books      int64
+	}
+
+	var directCounts []count
+	if result := db.Raw(`SELECT book_categories.category_id, COUNT(DISTINCT books.id) AS books
+		FROM book_categories
+		JOIN books ON books.id = book_categories.book_id AND books.deleted_at IS NULL
+		GROUP BY book_categories.category_id`).Scan(&directCounts); result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	var totalCounts []count
+	if result := db.Raw(`WITH RECURSIVE closure AS (
+			SELECT id AS ancestor_id, id AS descendant_id FROM categories WHERE deleted_at IS NULL
+			UNION ALL
+			SELECT closure.ancestor_id, categories.id FROM closure
+			JOIN categories ON categories.parent_id = closure.descendant_id
+			WHERE categories.deleted_at IS NULL
+		)
+		SELECT closure.ancestor_id AS category_id, COUNT(DISTINCT books.id) AS books
+		FROM closure
+		JOIN book_categories ON book_categories.category_id = closure.descendant_id
+		JOIN books ON books.id = book_categories.book_id AND books.deleted_at IS NULL
+		GROUP BY closure.ancestor_id`).Scan(&totalCounts); result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	direct = make(map[uint]int64, len(directCounts))
+	for _, c := range directCounts {
+		direct[c.CategoryID] = c.Books
+	}
+	total = make(map[uint]int64, len(totalCounts))
+	for _, c := range totalCounts {
+		total[c.CategoryID] = c.Books
+	}
+	return direct, total, nil
+}
+
+// countedCategories lists every category with its book counts filled
+func (c *CategoryRepository) countedCategories(ctx context.Context) ([]models.Category, error) {
+	db := c.db.WithContext(ctx)
+	var categories []models.Category
+	if result := db.Order("name").Find(&categories); result.Error != nil {
+		return nil, result.Error
+	}
+
+	direct, total, err := categoryCounts(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range categories {
+		categories[i].BookCount = direct[categories[i].ID]
+		categories[i].TotalBookCount = total[categories[i].ID]
+	}
+	return categories, nil
+}
+
+// GetAllCategories lists all available categories
+func (c *CategoryRepository) GetAllCategories(w http.ResponseWriter, r *http.Request) {
+	db, err := preload(replica(c.db), r, "Children")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var categories []models.Category
+	if result := db.Order("name").Find(&categories); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, categories)
+}
+
+// GetCategoryTree returns the whole category hierarchy starting from the
+// root categories, every node carries its book counts
+func (c *CategoryRepository) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	categories, err := c.countedCategories(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	children := make(map[uint][]models.Category)
+	var roots []models.Category
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+		} else {
+			children[*category.ParentID] = append(children[*category.ParentID], category)
+		}
+	}
+
+	var attach func(nodes []models.Category)
+	attach = func(nodes []models.Category) {
+		for i := range nodes {
+			nodes[i].Children = children[nodes[i].ID]
+			attach(nodes[i].Children)
+		}
+	}
+	attach(roots)
+
+	writeJSON(w, http.StatusOK, roots)
+}
+
+// GetCategoryCounts returns every category with the number of books in it
+// and in its descendants
+func (c *CategoryRepository) GetCategoryCounts(w http.ResponseWriter, r *http.Request) {
+	categories, err := c.countedCategories(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, categories)
+}
+
+// GetCategoryByID returns category information according to given id
+func (c *CategoryRepository) GetCategoryByID(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	db, err := preload(c.db, r, "Children")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var category models.Category
+	if result := db.First(&category, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, category)
+}
+
+// GetCategoryBooks returns the books of the given category including the
+// books of all its descendant categories
+func (c *CategoryRepository) GetCategoryBooks(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var category models.Category
+	if result := c.db.WithContext(r.Context()).First(&category, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	db, err := preload(replica(c.db), r, BookPreloads...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var books []models.Book
+	if result := db.
+		Where("books.id IN (SELECT book_id FROM book_categories WHERE category_id IN (?))", categorySubtree(id)).
+		Find(&books); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, books)
+}
+
+// AddCategory creates a new category
+func (c *CategoryRepository) AddCategory(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var category models.Category
+	if err := json.Unmarshal(body, &category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if result := c.db.WithContext(r.Context()).Omit("Children", "Books").Create(&category); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, category)
+}
+
+// UpdateCategory updates the given category, a category cannot be moved
+// under itself or one of its descendants
+func (c *CategoryRepository) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var category models.Category
+	if result := c.db.WithContext(r.Context()).First(&category, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	// Apply the request body on top of the stored category
+	if err := json.Unmarshal(body, &category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	category.ID = id
+
+	if category.ParentID != nil {
+		var cycle bool
+		if result := c.db.WithContext(r.Context()).
+			Raw("SELECT EXISTS (SELECT 1 FROM (?) AS subtree WHERE subtree.id = ?)", categorySubtree(id), *category.ParentID).
+			Scan(&cycle); result.Error != nil {
+			writeError(w, r, result.Error)
+			return
+		}
+		if cycle {
+			writeError(w, r, http_errors.NewRestError(http.StatusBadRequest,
+				fmt.Sprintf("%s: a category cannot be moved under itself or its descendants", http_errors.BadRequest), nil))
+			return
+		}
+	}
+
+	if result := c.db.WithContext(r.Context()).Omit("Children", "Books").Save(&category); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, category)
+}
+
+// DeleteCategory deletes given category according to given id, categories
+// which still have subcategories cannot be deleted
+func (c *CategoryRepository) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var category models.Category
+	if result := c.db.WithContext(r.Context()).First(&category, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	var children int64
+	if result := c.db.WithContext(r.Context()).Model(&models.Category{}).Where("parent_id = ?", id).Count(&children); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+	if children > 0 {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: category has subcategories, move or delete them first", http_errors.BadRequest), nil))
+		return
+	}
+
+	err = c.db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&category).Association("Books").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Deleted")
+}
+
+// FindCategoryByName returns categories found according to given search query
+func (c *CategoryRepository) FindCategoryByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var categories []models.Category
+	if result := replica(c.db).WithContext(r.Context()).Where("name ILIKE ? ", "%"+vars["name"]+"%").Find(&categories); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, categories)
+}
@@ -0,0 +1,126 @@
+package repos
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+)
+
+// newCategoryTree stores Fiction > Science Fiction > Cyberpunk and Poetry,
+// Neuromancer is filed under Cyberpunk and Dune under Science Fiction.
+func newCategoryTree(t *testing.T) *CategoryRepository {
+	t.Helper()
+	db := newTestDB(t)
+	author := models.Author{Name: "William Gibson"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	parent := func(id uint) *uint { return &id }
+	for _, category := range []models.Category{
+		{Name: "Fiction"},
+		{Name: "Science Fiction", ParentID: parent(1)},
+		{Name: "Cyberpunk", ParentID: parent(2)},
+		{Name: "Poetry"},
+	} {
+		if err := db.Create(&category).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, book := range []struct {
+		title    string
+		category uint
+	}{{"Neuromancer", 3}, {"Dune", 2}} {
+		stored := models.Book{Title: book.title, AuthorID: &author.ID, Price: "9.99"}
+		if err := db.Create(&stored).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Exec("INSERT INTO book_categories (book_id, category_id) VALUES (?, ?)", stored.ID, book.category).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewCategoryRepository(db)
+}
+
+func TestUpdateCategoryCycle(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"under itself", "/categories/2", `{"ParentID":2}`, http.StatusBadRequest},
+		{"under its child", "/categories/1", `{"ParentID":2}`, http.StatusBadRequest},
+		{"under its grandchild", "/categories/1", `{"ParentID":3}`, http.StatusBadRequest},
+		{"under another root", "/categories/2", `{"ParentID":4}`, http.StatusOK},
+		{"to the root", "/categories/3", `{"ParentID":null}`, http.StatusOK},
+		{"under its parent's sibling", "/categories/3", `{"ParentID":4}`, http.StatusOK},
+		{"missing", "/categories/9", `{"ParentID":1}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			categories := newCategoryTree(t)
+			rec := serve(t, categories.UpdateCategory, http.MethodPut, "/categories/{id}", tt.target, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCategoryBooksAndCounts(t *testing.T) {
+	categories := newCategoryTree(t)
+
+	tests := []struct {
+		target string
+		titles []string
+	}{
+		{"/categories/1/books", []string{"Dune", "Neuromancer"}},
+		{"/categories/2/books", []string{"Dune", "Neuromancer"}},
+		{"/categories/3/books", []string{"Neuromancer"}},
+		{"/categories/4/books", nil},
+	}
+	for _, tt := range tests {
+		var books []models.Book
+		rec := serve(t, categories.GetCategoryBooks, http.MethodGet, "/categories/{id}/books", tt.target, "", nil)
+		decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &books)
+		var titles []string
+		for _, book := range books {
+			titles = append(titles, book.Title)
+		}
+		sort.Strings(titles)
+		if strings.Join(titles, ",") != strings.Join(tt.titles, ",") {
+			t.Errorf("%s: books %q, want %q", tt.target, titles, tt.titles)
+		}
+	}
+
+	var counted []models.Category
+	rec := serve(t, categories.GetCategoryCounts, http.MethodGet, "/categories/counts", "/categories/counts", "", nil)
+	decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &counted)
+	want := map[string][2]int64{
+		"Fiction":         {0, 2},
+		"Science Fiction": {1, 2},
+		"Cyberpunk":       {1, 1},
+		"Poetry":          {0, 0},
+	}
+	for _, category := range counted {
+		if got := [2]int64{category.BookCount, category.TotalBookCount}; got != want[category.Name] {
+			t.Errorf("%s: direct and total counts %v, want %v", category.Name, got, want[category.Name])
+		}
+	}
+
+	var roots []models.Category
+	rec = serve(t, categories.GetCategoryTree, http.MethodGet, "/categories/tree", "/categories/tree", "", nil)
+	decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &roots)
+	if len(roots) != 2 || roots[0].Name != "Fiction" || len(roots[0].Children) != 1 ||
+		len(roots[0].Children[0].Children) != 1 || roots[0].Children[0].Children[0].Name != "Cyberpunk" {
+		t.Errorf("tree %+v, want Fiction > Science Fiction > Cyberpunk and Poetry", roots)
+	}
+
+	rec = serve(t, categories.DeleteCategory, http.MethodDelete, "/categories/{id}", "/categories/2", "", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("deleting a category with subcategories: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,120 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+)
+
+// slowQueries makes every query wait until its context is done, like a
+// query still running when the deadline expires or the client goes away.
+// started receives a value once a query waits, the errors the queries
+// ended with are sent to errs.
+func slowQueries(t *testing.T, db *gorm.DB) (started chan struct{}, errs chan error) {
+	t.Helper()
+	started = make(chan struct{}, 1)
+	errs = make(chan error, 1)
+	err := db.Callback().Query().Before("gorm:query").Register("test:slow", func(tx *gorm.DB) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-tx.Statement.Context.Done():
+		case <-time.After(5 * time.Second):
+			tx.AddError(errors.New("the query does not use the request context"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Callback().Query().After("gorm:query").Register("test:errors", func(tx *gorm.DB) {
+		select {
+		case errs <- tx.Error:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return started, errs
+}
+
+func newContextTestBook(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	author := models.Author{Name: "Iain M. Banks"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Book{Title: "Excession", Page: 451, Stock: 1, Price: "10.00", AuthorID: &author.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeadlineCancelsQueries(t *testing.T) {
+	db := newTestDB(t)
+	newContextTestBook(t, db)
+	_, errs := slowQueries(t, db)
+
+	r := mux.NewRouter()
+	r.Use(middleware.Deadline(20*time.Millisecond, nil))
+	r.HandleFunc("/books/{id}", NewBookRepository(db).GetBookByID).Methods(http.MethodGet)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books/1", nil))
+
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("query ended with %v, want %v", err, context.DeadlineExceeded)
+	}
+	if rec.Code != http.StatusRequestTimeout {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusRequestTimeout, rec.Body)
+	}
+	var restErr http_errors.RestError
+	if err := json.Unmarshal(rec.Body.Bytes(), &restErr); err != nil {
+		t.Fatal(err)
+	}
+	if restErr.ErrError != http_errors.RequestTimeoutError.Error() {
+		t.Errorf("message %q, want %q", restErr.ErrError, http_errors.RequestTimeoutError)
+	}
+}
+
+func TestClientGoneCancelsQueries(t *testing.T) {
+	db := newTestDB(t)
+	newContextTestBook(t, db)
+	started, errs := slowQueries(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := mux.NewRouter()
+	r.HandleFunc("/books/{id}", NewBookRepository(db).GetBookByID).Methods(http.MethodGet)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books/1", nil).WithContext(ctx))
+		done <- rec
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query did not start")
+	}
+	cancel()
+	rec := <-done
+
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("query ended with %v, want %v", err, context.Canceled)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("answered %d to a client which went away: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,99 @@
+package repos
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+// etag is the entity tag of a record's version, every write changes it.
+func etag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// versioned reports whether the response to r only depends on the version
+// of the record. Preloaded associations change without it, so those
+// responses carry no ETag.
+func versioned(r *http.Request) bool {
+	return r.URL.Query().Get("preload") == ""
+}
+
+// writeVersioned sends v like writeJSON with the ETag of its version.
+func writeVersioned(w http.ResponseWriter, r *http.Request, status int, version uint, v interface{}) {
+	if versioned(r) {
+		w.Header().Set("ETag", etag(version))
+	}
+	writeJSON(w, status, v)
+}
+
+// notModified answers a conditional GET with 304 Not Modified when its
+// If-None-Match header lists the current version of the record.
+func notModified(w http.ResponseWriter, r *http.Request, version uint) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !versioned(r) || !etagListed(header, version, true) {
+		return false
+	}
+	w.Header().Set("ETag", etag(version))
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch ensures a request modifying a record was sent for its current
+// version, so clients cannot overwrite changes they have not seen. Requests
+// without If-Match fail with 428 Precondition Required, requests for an
+// older version with 412 Precondition Failed.
+func checkIfMatch(r *http.Request, version uint) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return http_errors.NewPreconditionRequiredError("If-Match header with the ETag of the record is required")
+	}
+	if !etagListed(header, version, false) {
+		return http_errors.NewPreconditionFailedError("the record was changed, its current ETag is " + etag(version))
+	}
+	return nil
+}
+
+// ifMatch is the precondition of a request modifying a record, see
+// checkIfMatch.
+func ifMatch(r *http.Request) func(version uint) error {
+	return func(version uint) error {
+		return checkIfMatch(r, version)
+	}
+}
+
+// versionIs is the precondition of a change made for the given version,
+// the counterpart of If-Match for the apis without http headers.
+func versionIs(expected uint) func(version uint) error {
+	return func(version uint) error {
+		if version != expected {
+			return http_errors.NewPreconditionFailedError(fmt.Sprintf("the record was changed, its current version is %d", version))
+		}
+		return nil
+	}
+}
+
+// etagListed reports whether the comma separated list of entity tags in
+// header contains the tag of version or is "*". Weak tags only match when
+// weak is set, If-Match requires the strong comparison.
+func etagListed(header string, version uint, weak bool) bool {
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package repos
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+func TestModifyBookPreconditions(t *testing.T) {
+	db := newTestDB(t)
+	books := NewBookRepository(db)
+	author := models.Author{Name: "Frank Herbert"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	book := models.Book{Title: "Dune", Page: 412, Stock: 3, Price: "9.99", AuthorID: &author.ID}
+	if err := db.Create(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+	body := `{"title":"Dune Messiah","page":256,"stock":1,"price":"8.99","stockCode":"","ISBN":"","AuthorID":1,"WorkID":null,"PublisherID":null,"format":"","edition":0,"publishedYear":0}`
+
+	tests := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		header  http.Header
+		body    string
+		status  int
+	}{
+		{"put without If-Match", http.MethodPut, books.UpdateBook, nil, body, http.StatusPreconditionRequired},
+		{"put with stale If-Match", http.MethodPut, books.UpdateBook, http.Header{"If-Match": {`"7"`}}, body, http.StatusPreconditionFailed},
+		{"patch without If-Match", http.MethodPatch, books.PatchBook, http.Header{"Content-Type": {"application/merge-patch+json"}}, `{"stock":2}`, http.StatusPreconditionRequired},
+		{"patch with stale If-Match", http.MethodPatch, books.PatchBook, http.Header{"Content-Type": {"application/merge-patch+json"}, "If-Match": {`"7"`}}, `{"stock":2}`, http.StatusPreconditionFailed},
+		{"delete without If-Match", http.MethodDelete, books.DeleteBook, nil, "", http.StatusPreconditionRequired},
+		{"delete with stale If-Match", http.MethodDelete, books.DeleteBook, http.Header{"If-Match": {`"7"`}}, "", http.StatusPreconditionFailed},
+		{"put with current If-Match", http.MethodPut, books.UpdateBook, http.Header{"If-Match": {`"1"`}}, body, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.handler, tt.method, "/books/{id}", "/books/1", tt.body, tt.header)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code < 400 {
+				return
+			}
+			var restErr http_errors.RestError
+			if err := json.Unmarshal(rec.Body.Bytes(), &restErr); err != nil {
+				t.Fatal(err)
+			}
+			if restErr.ErrStatus != tt.status {
+				t.Errorf("code %d, want %d", restErr.ErrStatus, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package repos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+// reconnectDelay is the retry field sent to the clients of the stream, how
+// long browsers wait before they reconnect.
+const reconnectDelay = 3 * time.Second
+
+// StreamOptions configure the event stream.
+type StreamOptions struct {
+	// Heartbeat is how often a comment is sent while there are no events,
+	// so proxies keep the connection open.
+	Heartbeat time.Duration
+	// WriteTimeout bounds every single write, the stream itself is not
+	// bounded by the server's write timeout.
+	WriteTimeout time.Duration
+	// ReplayLimit is the most events resent to a resuming client.
+	ReplayLimit int
+}
+
+// EventRepository streams the domain events as server-sent events.
+type EventRepository struct {
+	broker *events.Broker
+	opts   StreamOptions
+}
+
+func NewEventRepository(broker *events.Broker, opts StreamOptions) *EventRepository {
+	return &EventRepository{broker: broker, opts: opts}
+}
+
+// StreamEvents sends the events of books and authors as they are committed,
+// as text/event-stream. ?entity=book|author, ?book=<id>[,<id>] and
+// ?type=<event type>[,<type>] select the events. Clients sending
+// Last-Event-ID first get the events they missed, a reset event tells them
+// to reload when they missed more than the replay limit.
+func (e *EventRepository) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := streamFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var lastID uint64
+	resume := r.Header.Get("Last-Event-ID") != ""
+	if resume {
+		if lastID, err = strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 0); err != nil {
+			writeError(w, r, http_errors.NewValidationError(http.StatusBadRequest, http_errors.BadRequest.Error(),
+				[]http_errors.Detail{{In: "header", Name: "Last-Event-ID", Message: "must be the id of an event"}}))
+			return
+		}
+	}
+
+	sub, err := e.broker.Subscribe(r.Context(), filter)
+	if errors.Is(err, events.ErrClosed) {
+		writeError(w, r, http_errors.NewRestError(http.StatusServiceUnavailable, "Server is shutting down", err.Error()))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer e.broker.Unsubscribe(sub)
+
+	var missed []events.Event
+	complete := true
+	if resume {
+		if missed, complete, err = e.broker.Replay(r.Context(), filter, uint(lastID), sub.From, e.opts.ReplayLimit); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx would buffer the stream otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, from now on errors end the stream and the
+	// client reconnects
+	logger := logging.FromContext(r.Context())
+	rc := http.NewResponseController(w)
+	send := func(write func(w io.Writer) error) error {
+		if e.opts.WriteTimeout > 0 {
+			if err := rc.SetWriteDeadline(time.Now().Add(e.opts.WriteTimeout)); err != nil {
+				return err
+			}
+		}
+		if err := write(w); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	err = send(func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay.Milliseconds())
+		return err
+	})
+	if !complete && err == nil {
+		err = send(func(w io.Writer) error {
+			return writeReset(w, sub.From, e.opts.ReplayLimit)
+		})
+	}
+	// events committed late may be replayed and streamed both
+	replayed := make(map[uint]bool, len(missed))
+	for _, event := range missed {
+		if err != nil {
+			break
+		}
+		replayed[event.ID] = true
+		err = send(func(w io.Writer) error {
+			return writeEvent(w, event)
+		})
+	}
+
+	heartbeat := time.NewTicker(e.opts.Heartbeat)
+	defer heartbeat.Stop()
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				logger.Debug("event stream ended", "reason", sub.Err())
+				return
+			}
+			if replayed[event.ID] {
+				continue
+			}
+			err = send(func(w io.Writer) error {
+				return writeEvent(w, event)
+			})
+		case <-heartbeat.C:
+			err = send(func(w io.Writer) error {
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err
+			})
+		}
+	}
+	logger.Debug("event stream cannot be written", "error", err)
+}
+
+// writeEvent writes the event as a server-sent event named after its type.
+func writeEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// writeReset tells a client it missed too many events to be replayed, its
+// last event id moves on to the end of the outbox.
+func writeReset(w io.Writer, last uint, limit int) error {
+	data, err := json.Marshal(map[string]string{
+		"reason": fmt.Sprintf("more than %d events were missed, reload the current state", limit),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: reset\ndata: %s\n\n", last, data)
+	return err
+}
+
+// streamFilter reads the filter of the event stream from the query.
+func streamFilter(r *http.Request) (events.Filter, error) {
+	query := r.URL.Query()
+	var books []uint
+	for _, value := range splitList(query["book"]) {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil || id == 0 {
+			return events.Filter{}, badQuery("book", "must be a list of positive integers")
+		}
+		books = append(books, uint(id))
+	}
+	return newStreamFilter(query.Get("entity"), books, splitList(query["type"]))
+}
+
+// newStreamFilter checks the filter of an event stream.
+func newStreamFilter(entity string, books []uint, types []string) (events.Filter, error) {
+	var filter events.Filter
+	switch entity {
+	case "", "book", "author":
+		filter.Entity = entity
+	default:
+		return filter, badQuery("entity", "must be book or author")
+	}
+	filter.BookIDs = books
+	if len(filter.BookIDs) > 0 && filter.Entity == "author" {
+		return filter, badQuery("book", "cannot be combined with entity=author")
+	}
+	for _, value := range types {
+		eventType, err := events.ParseType(value)
+		if err != nil {
+			return filter, badQuery("type", err.Error())
+		}
+		filter.Types = append(filter.Types, eventType)
+	}
+	return filter, nil
+}
+
+// splitList splits repeated and comma separated query values.
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,96 @@
+package repos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+)
+
+// stream requests the event stream for the given time and returns what
+// was sent by then.
+func stream(t *testing.T, repo *EventRepository, target, lastEventID string, d time.Duration) *httptest.ResponseRecorder {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc("/events", repo.StreamEvents).Methods(http.MethodGet)
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStreamEvents(t *testing.T) {
+	db := newTestDB(t)
+	for id := uint(1); id <= 4; id++ {
+		entity, eventType := "book", events.BookUpdated
+		if id == 3 {
+			entity, eventType = "author", events.AuthorUpdated
+		}
+		event := events.Event{ID: id, OccurredAt: time.Now(), Type: eventType, Entity: entity, EntityID: id, Data: events.Data(`{}`)}
+		if err := db.Create(&event).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	broker := events.NewBroker(db, time.Hour, 10)
+	repo := NewEventRepository(broker, StreamOptions{Heartbeat: time.Hour, ReplayLimit: 2})
+
+	tests := []struct {
+		name        string
+		target      string
+		lastEventID string
+		status      int
+		sent        []string
+		notSent     []string
+	}{
+		{"new client", "/events", "", http.StatusOK,
+			[]string{"retry: 3000\n\n"}, []string{"id: "}},
+		{"resuming client", "/events?entity=book", "1", http.StatusOK,
+			[]string{"id: 2\nevent: BookUpdated\n", "id: 4\nevent: BookUpdated\n"}, []string{"id: 3\n", "event: reset"}},
+		{"missed too many", "/events", "0", http.StatusOK,
+			[]string{"id: 4\nevent: reset\n"}, []string{"id: 1\n"}},
+		{"bad last event id", "/events", "latest", http.StatusBadRequest, nil, nil},
+		{"books of authors", "/events?entity=author&book=1", "", http.StatusBadRequest, nil, nil},
+		{"unknown type", "/events?type=BookRead", "", http.StatusBadRequest, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := stream(t, repo, tt.target, tt.lastEventID, 100*time.Millisecond)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("content type %q", got)
+			}
+			body := rec.Body.String()
+			for _, part := range tt.sent {
+				if !strings.Contains(body, part) {
+					t.Errorf("stream %q does not contain %q", body, part)
+				}
+			}
+			for _, part := range tt.notSent {
+				if strings.Contains(body, part) {
+					t.Errorf("stream %q contains %q", body, part)
+				}
+			}
+		})
+	}
+
+	// a broker shutting down refuses new streams
+	broker.Close()
+	if rec := stream(t, repo, "/events", "", time.Second); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("stream of a closed broker: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,121 @@
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/export"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+)
+
+type ExportRepository struct {
+	db           *gorm.DB
+	writeTimeout time.Duration
+}
+
+// NewExportRepository returns the export of db. writeTimeout bounds the
+// writes of every flushed part of an export, not the whole export.
+func NewExportRepository(db *gorm.DB, writeTimeout time.Duration) *ExportRepository {
+	return &ExportRepository{db: db, writeTimeout: writeTimeout}
+}
+
+// exportFlushRows is how many books are written between two flushes.
+const exportFlushRows = 500
+
+// ExportBooks streams the books matching the filters of the book list
+// endpoint in the format given by ?format=csv|jsonl|marcxml. Rows are read
+// from the database and written to the client one at a time. The write
+// deadline is extended for every flushed part, so large exports are not cut
+// off by the server's write timeout.
+func (e *ExportRepository) ExportBooks(w http.ResponseWriter, r *http.Request) {
+	format, err := export.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadQueryParams, err), err))
+		return
+	}
+
+	db := replica(e.db).WithContext(r.Context()).Model(&models.Book{}).
+		Select(`books.id, books.title, books.page, books.stock, books.price, books.stock_code, books.isbn,
+			books.format, books.edition, books.published_year, books.author_id,
+			authors.name AS author_name, publishers.name AS publisher_name`).
+		Joins("LEFT JOIN authors ON authors.id = books.author_id AND authors.deleted_at IS NULL").
+		Joins("LEFT JOIN publishers ON publishers.id = books.publisher_id AND publishers.deleted_at IS NULL").
+		Order("books.id")
+	db, err = filterBooks(db, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rows, err := db.Rows()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	rc := http.NewResponseController(w)
+	extendDeadline := func() error {
+		if e.writeTimeout <= 0 {
+			return nil
+		}
+		err := rc.SetWriteDeadline(time.Now().Add(e.writeTimeout))
+		if errors.Is(err, http.ErrNotSupported) {
+			return nil
+		}
+		return err
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books.%s"`, export.FileExtension(format)))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, from now on errors can only be logged and
+	// the client sees a truncated document
+	logger := logging.FromContext(r.Context())
+	if err := extendDeadline(); err != nil {
+		logger.Error("export: cannot extend the write deadline", "error", err)
+		return
+	}
+	writer, err := export.NewWriter(w, format)
+	if err != nil {
+		logger.Error("export: cannot start document", "error", err)
+		return
+	}
+	count := 0
+	for rows.Next() {
+		var rec export.Record
+		if err := e.db.ScanRows(rows, &rec); err != nil {
+			logger.Error("export: cannot scan book", "error", err)
+			return
+		}
+		if err := writer.Write(rec); err != nil {
+			logger.Error("export: cannot write book", "book_id", rec.ID, "error", err)
+			return
+		}
+
+		count++
+		if count%exportFlushRows == 0 {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				logger.Error("export: cannot flush books", "error", err)
+				return
+			}
+			if err := extendDeadline(); err != nil {
+				logger.Error("export: cannot extend the write deadline", "error", err)
+				return
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("export: cannot read books", "error", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		logger.Error("export: cannot complete document", "error", err)
+	}
+}
@@ -0,0 +1,84 @@
+package repos
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+)
+
+// slowFlusher makes every flush of an export take delay, like a client
+// reading slowly.
+type slowFlusher struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+func (w slowFlusher) Flush() {
+	time.Sleep(w.delay)
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w slowFlusher) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// TestExportOutlivesWriteTimeout streams an export for longer than the
+// write timeout of the server and the request deadline of the router.
+func TestExportOutlivesWriteTimeout(t *testing.T) {
+	db := newTestDB(t)
+	author := models.Author{Name: "Iain M. Banks"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	books := make([]models.Book, 3*exportFlushRows)
+	for i := range books {
+		books[i] = models.Book{Title: "Excession", Page: 451, Stock: 1, Price: "10.00", AuthorID: &author.ID}
+	}
+	if err := db.CreateInBatches(books, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	const writeTimeout = time.Second
+	r := mux.NewRouter()
+	r.Use(middleware.Deadline(50*time.Millisecond, map[string]time.Duration{"GET /export/books": 0}))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(slowFlusher{w, writeTimeout * 2 / 5}, r)
+		})
+	})
+	r.HandleFunc("/export/books", NewExportRepository(db, writeTimeout).ExportBooks).Methods(http.MethodGet)
+	server := httptest.NewUnstartedServer(r)
+	server.Config.WriteTimeout = writeTimeout
+	server.Start()
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/export/books?format=jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	lines := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("export cut off after %d books: %v", lines, err)
+	}
+	if lines != len(books) {
+		t.Errorf("%d books exported, want %d", lines, len(books))
+	}
+	if took := time.Since(start); took <= writeTimeout {
+		t.Errorf("export took %s, the test needs it to outlive the write timeout", took)
+	}
+}
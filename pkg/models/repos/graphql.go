@@ -0,0 +1,407 @@
+package repos
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/trace/noop"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+)
+
+//go:embed schema.graphql
+var graphSchema string
+
+// maxQueryDepth bounds how deeply GraphQL queries may nest, e.g.
+// author → books → author.
+const maxQueryDepth = 10
+
+// GraphQLRepository serves the books and authors over GraphQL. Reads and
+// writes go through the book and author repositories, so they follow the
+// same rules as the REST routes.
+type GraphQLRepository struct {
+	db     *gorm.DB
+	schema *graphql.Schema
+}
+
+func NewGraphQLRepository(books *BookRepository, authors *AuthorRepository) *GraphQLRepository {
+	root := &graphResolver{books: books, authors: authors}
+	return &GraphQLRepository{
+		db: books.db,
+		schema: graphql.MustParseSchema(graphSchema, root,
+			graphql.MaxDepth(maxQueryDepth),
+			graphql.Tracer(noop.Tracer{})),
+	}
+}
+
+// Query runs the GraphQL request in the body, {"query", "operationName",
+// "variables"}. Failed fields are reported in the errors of the response
+// with the status the REST api would answer with in their extensions.
+func (g *GraphQLRepository) Query(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err))
+		return
+	}
+	defer r.Body.Close()
+	if params.Query == "" {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: query is required", http_errors.BadRequest), params))
+		return
+	}
+
+	ctx := withLoaders(r.Context(), newLoaders(g.db))
+	response := g.schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// CanAccessAuthors reports whether the client of the request ctx belongs
+// to may use the authors, only the author user and admins can.
+func CanAccessAuthors(ctx context.Context) bool {
+	principal, ok := middleware.PrincipalFromContext(ctx)
+	return ok && (principal.Kind == "user" || principal.Kind == "admin")
+}
+
+// graphError is the error of a GraphQL field, it carries the status the
+// REST api answers the same problem with.
+type graphError struct {
+	message   string
+	status    int
+	requestID string
+}
+
+func (e graphError) Error() string {
+	return e.message
+}
+
+func (e graphError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"status": e.status}
+	if e.requestID != "" {
+		extensions["requestId"] = e.requestID
+	}
+	return extensions
+}
+
+// fieldError turns the error of a resolver into a graphError, server
+// errors are logged like the ones of writeError.
+func fieldError(ctx context.Context, err error) error {
+	restErr := http_errors.ParseErrors(err)
+	logger := logging.FromContext(ctx)
+	if restErr.Status() >= http.StatusInternalServerError {
+		logger.Error("graphql field failed", "status", restErr.Status(), "error", err)
+	} else {
+		logger.Debug("graphql field rejected", "status", restErr.Status(), "error", err)
+	}
+
+	message := restErr.Error()
+	var rest http_errors.RestError
+	if errors.As(restErr, &rest) {
+		message = rest.ErrError
+	}
+	return graphError{message: message, status: restErr.Status(), requestID: middleware.RequestIDFromContext(ctx)}
+}
+
+// authorsAllowed rejects the author fields like the /authors routes.
+func authorsAllowed(ctx context.Context) error {
+	if !CanAccessAuthors(ctx) {
+		return fieldError(ctx, http_errors.NewRestError(http.StatusUnauthorized, "Token not found", "authors require a token"))
+	}
+	return nil
+}
+
+// parseID reads a GraphQL id argument.
+func parseID(ctx context.Context, name string, id graphql.ID) (uint, error) {
+	parsed, err := strconv.ParseUint(string(id), 10, 0)
+	if err != nil || parsed == 0 {
+		return 0, fieldError(ctx, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: %s must be a positive integer", http_errors.BadRequest, name), id))
+	}
+	return uint(parsed), nil
+}
+
+// pageArgs checks the paging arguments of a list like paging does.
+func pageArgs(ctx context.Context, page, perPage int32) (offset, limit int, err error) {
+	if page <= 0 {
+		return 0, 0, fieldError(ctx, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: page must be a positive integer", http_errors.BadRequest), page))
+	}
+	if perPage <= 0 || perPage > maxPageSize {
+		return 0, 0, fieldError(ctx, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: perPage must be between 1 and %d", http_errors.BadRequest, maxPageSize), perPage))
+	}
+	return int(page-1) * int(perPage), int(perPage), nil
+}
+
+// versionMatches is the precondition of a mutation sent with the version
+// it was made for, the GraphQL counterpart of If-Match.
+func versionMatches(expected int32) func(version uint) error {
+	if expected < 0 {
+		// versions start at 1, so it never matches
+		expected = 0
+	}
+	return versionIs(uint(expected))
+}
+
+// graphResolver resolves the queries and mutations of the schema.
+type graphResolver struct {
+	books   *BookRepository
+	authors *AuthorRepository
+}
+
+func (g *graphResolver) Book(ctx context.Context, args struct{ ID graphql.ID }) (*bookResolver, error) {
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return nil, err
+	}
+	var book models.Book
+	if err := g.books.db.WithContext(ctx).Take(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fieldError(ctx, err)
+	}
+	return &bookResolver{book}, nil
+}
+
+func (g *graphResolver) BookByISBN(ctx context.Context, args struct{ ISBN string }) (*bookResolver, error) {
+	book, err := g.books.bookByISBN(ctx, args.ISBN)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fieldError(ctx, err)
+	}
+	return &bookResolver{book}, nil
+}
+
+func (g *graphResolver) Books(ctx context.Context, args struct {
+	Filter  *bookFilter
+	Page    int32
+	PerPage int32
+}) (*bookList, error) {
+	offset, limit, err := pageArgs(ctx, args.Page, args.PerPage)
+	if err != nil {
+		return nil, err
+	}
+	var search bookSearch
+	if args.Filter != nil {
+		if search, err = args.Filter.search(ctx); err != nil {
+			return nil, err
+		}
+	}
+	books, total, err := g.books.searchBooks(ctx, search, offset, limit)
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+
+	list := &bookList{total: total}
+
+	l := loadersFromContext(ctx)
+	for _, book := range books {
+		if book.AuthorID != nil {
+			l.authors.prime(*book.AuthorID)
+			l.authorBooks.prime(*book.AuthorID)
+		}
+		list.nodes = append(list.nodes, &bookResolver{book})
+	}
+	return list, nil
+}
+
+func (g *graphResolver) Author(ctx context.Context, args struct{ ID graphql.ID }) (*authorResolver, error) {
+	if err := authorsAllowed(ctx); err != nil {
+		return nil, err
+	}
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return nil, err
+	}
+	var author models.Author
+	if err := g.authors.db.WithContext(ctx).Take(&author, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fieldError(ctx, err)
+	}
+	loadersFromContext(ctx).authors.store(author.ID, author)
+	return &authorResolver{author}, nil
+}
+
+func (g *graphResolver) Authors(ctx context.Context, args struct {
+	Filter  *authorFilter
+	Page    int32
+	PerPage int32
+}) (*authorList, error) {
+	if err := authorsAllowed(ctx); err != nil {
+		return nil, err
+	}
+	offset, limit, err := pageArgs(ctx, args.Page, args.PerPage)
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	if args.Filter != nil && args.Filter.Name != nil {
+		name = *args.Filter.Name
+	}
+	authors, total, err := g.authors.searchAuthors(ctx, name, offset, limit)
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+
+	list := &authorList{total: total}
+
+	l := loadersFromContext(ctx)
+	for _, author := range authors {
+		l.authors.store(author.ID, author)
+		l.authorBooks.prime(author.ID)
+		list.nodes = append(list.nodes, &authorResolver{author})
+	}
+	return list, nil
+}
+
+func (g *graphResolver) CreateBook(ctx context.Context, args struct{ Input bookInput }) (*bookResolver, error) {
+	doc, err := args.Input.document(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var book models.Book
+	if err := json.Unmarshal(doc, &book); err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	if err := g.books.createBook(ctx, &book); err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	return &bookResolver{book}, nil
+}
+
+func (g *graphResolver) UpdateBook(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version int32
+	Input   bookInput
+}) (*bookResolver, error) {
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := args.Input.document(ctx)
+	if err != nil {
+		return nil, err
+	}
+	book, err := g.books.changeBook(ctx, id, versionMatches(args.Version), func(current []byte) ([]byte, error) {
+		return bookRepresentation.mergePatch(current, patch)
+	})
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	return &bookResolver{book}, nil
+}
+
+func (g *graphResolver) DeleteBook(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version int32
+}) (graphql.ID, error) {
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := g.books.deleteBook(ctx, id, versionMatches(args.Version)); err != nil {
+		return "", fieldError(ctx, err)
+	}
+	return args.ID, nil
+}
+
+func (g *graphResolver) BuyBook(ctx context.Context, args struct {
+	ID       graphql.ID
+	Quantity int32
+	Version  *int32
+}) (*bookResolver, error) {
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return nil, err
+	}
+	if args.Quantity <= 0 {
+		return nil, fieldError(ctx, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: quantity must be a positive integer", http_errors.BadRequest), args.Quantity))
+	}
+	var precondition func(version uint) error
+	if args.Version != nil {
+		precondition = versionMatches(*args.Version)
+	}
+	book, err := g.books.buyBook(ctx, id, int(args.Quantity), precondition)
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	return &bookResolver{book}, nil
+}
+
+func (g *graphResolver) CreateAuthor(ctx context.Context, args struct{ Input authorInput }) (*authorResolver, error) {
+	if err := authorsAllowed(ctx); err != nil {
+		return nil, err
+	}
+	doc, err := args.Input.document()
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	var author models.Author
+	if err := json.Unmarshal(doc, &author); err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	if err := g.authors.createAuthor(ctx, &author); err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	return &authorResolver{author}, nil
+}
+
+func (g *graphResolver) UpdateAuthor(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version int32
+	Input   authorInput
+}) (*authorResolver, error) {
+	if err := authorsAllowed(ctx); err != nil {
+		return nil, err
+	}
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := args.Input.document()
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	author, err := g.authors.changeAuthor(ctx, id, versionMatches(args.Version), func(current []byte) ([]byte, error) {
+		return authorRepresentation.mergePatch(current, patch)
+	})
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	return &authorResolver{author}, nil
+}
+
+func (g *graphResolver) DeleteAuthor(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version int32
+}) (graphql.ID, error) {
+	if err := authorsAllowed(ctx); err != nil {
+		return "", err
+	}
+	id, err := parseID(ctx, "id", args.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := g.authors.deleteAuthor(ctx, id, versionMatches(args.Version)); err != nil {
+		return "", fieldError(ctx, err)
+	}
+	return args.ID, nil
+}
@@ -0,0 +1,90 @@
+package repos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"gorm.io/gorm"
+)
+
+// countQueries counts the select queries run on db.
+func countQueries(t *testing.T, db *gorm.DB) *atomic.Int64 {
+	t.Helper()
+	var queries atomic.Int64
+	count := func(*gorm.DB) { queries.Add(1) }
+	if err := db.Callback().Query().Before("gorm:query").Register("test:count", count); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("test:count", count); err != nil {
+		t.Fatal(err)
+	}
+	return &queries
+}
+
+// TestGraphQLBatchesNestedLists checks that the queries of a request do not
+// grow with the number of listed books, the authors and their books are
+// each read with a single query.
+func TestGraphQLBatchesNestedLists(t *testing.T) {
+	const query = `{ books(perPage: 50) { nodes { title author { name books { title author { name } } } } } }`
+
+	queriesFor := func(authors int) int64 {
+		db := newTestDB(t)
+		for i := 1; i <= authors; i++ {
+			author := models.Author{Name: fmt.Sprintf("Author %d", i)}
+			if err := db.Create(&author).Error; err != nil {
+				t.Fatal(err)
+			}
+			for j := 1; j <= 2; j++ {
+				book := models.Book{Title: fmt.Sprintf("Book %d.%d", i, j), Page: 100, Stock: 1, Price: "5.00", AuthorID: &author.ID}
+				if err := db.Create(&book).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		graph := NewGraphQLRepository(NewBookRepository(db), NewAuthorRepository(db, trash.Block))
+		queries := countQueries(t, db)
+		body, _ := json.Marshal(map[string]string{"query": query})
+		rec := serve(t, graph.Query, http.MethodPost, "/graphql", "/graphql", string(body), nil)
+
+		var response struct {
+			Data struct {
+				Books struct {
+					Nodes []struct {
+						Author struct {
+							Books []struct {
+								Author struct{ Name string }
+							}
+						}
+					}
+				}
+			}
+			Errors []interface{}
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Errors) > 0 || len(response.Data.Books.Nodes) != 2*authors {
+			t.Fatalf("unexpected response: %s", rec.Body)
+		}
+		for _, node := range response.Data.Books.Nodes {
+			if len(node.Author.Books) != 2 || node.Author.Books[0].Author.Name == "" {
+				t.Fatalf("unexpected author books: %s", rec.Body)
+			}
+		}
+		return queries.Load()
+	}
+
+	// the count and the page of books, their authors and the authors' books
+	const want = 4
+	for _, authors := range []int{1, 10} {
+		if got := queriesFor(authors); got != want {
+			t.Errorf("%d queries for %d authors, want %d", got, authors, want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+)
+
+type bookResolver struct {
+	book models.Book
+}
+
+func (b *bookResolver) ID() graphql.ID          { return uintID(b.book.ID) }
+func (b *bookResolver) Version() int32          { return int32(b.book.Version) }
+func (b *bookResolver) Title() string           { return b.book.Title }
+func (b *bookResolver) Page() int32             { return int32(b.book.Page) }
+func (b *bookResolver) Stock() int32            { return int32(b.book.Stock) }
+func (b *bookResolver) Price() string           { return b.book.Price }
+func (b *bookResolver) StockCode() string       { return b.book.StockCode }
+func (b *bookResolver) ISBN() string            { return b.book.ISBN }
+func (b *bookResolver) Format() string          { return b.book.Format }
+func (b *bookResolver) Edition() int32          { return int32(b.book.Edition) }
+func (b *bookResolver) PublishedYear() int32    { return int32(b.book.PublishedYear) }
+func (b *bookResolver) CreatedAt() graphql.Time { return graphql.Time{Time: b.book.CreatedAt} }
+func (b *bookResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: b.book.UpdatedAt} }
+
+func (b *bookResolver) AuthorID() *graphql.ID {
+	if b.book.AuthorID == nil {
+		return nil
+	}
+	id := uintID(*b.book.AuthorID)
+	return &id
+}
+
+// Author is open to everybody like /books/withauthors, books without an
+// author or with a deleted one have none.
+func (b *bookResolver) Author(ctx context.Context) (*authorResolver, error) {
+	if b.book.AuthorID == nil {
+		return nil, nil
+	}
+	author, found, err := loadersFromContext(ctx).authors.load(ctx, *b.book.AuthorID)
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &authorResolver{author}, nil
+}
+
+type authorResolver struct {
+	author models.Author
+}
+
+func (a *authorResolver) ID() graphql.ID          { return uintID(a.author.ID) }
+func (a *authorResolver) Version() int32          { return int32(a.author.Version) }
+func (a *authorResolver) Name() string            { return a.author.Name }
+func (a *authorResolver) CreatedAt() graphql.Time { return graphql.Time{Time: a.author.CreatedAt} }
+func (a *authorResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: a.author.UpdatedAt} }
+
+func (a *authorResolver) Books(ctx context.Context) ([]*bookResolver, error) {
+	books, _, err := loadersFromContext(ctx).authorBooks.load(ctx, a.author.ID)
+	if err != nil {
+		return nil, fieldError(ctx, err)
+	}
+	resolvers := make([]*bookResolver, len(books))
+	for i, book := range books {
+		resolvers[i] = &bookResolver{book}
+	}
+	return resolvers, nil
+}
+
+type bookList struct {
+	total int64
+	nodes []*bookResolver
+}
+
+func (l *bookList) TotalCount() int32      { return int32(l.total) }
+func (l *bookList) Nodes() []*bookResolver { return l.nodes }
+
+type authorList struct {
+	total int64
+	nodes []*authorResolver
+}
+
+func (l *authorList) TotalCount() int32        { return int32(l.total) }
+func (l *authorList) Nodes() []*authorResolver { return l.nodes }
+
+type bookFilter struct {
+	Title    *string
+	AuthorID *graphql.ID
+	Category *graphql.ID
+	Tags     *[]string
+	InStock  *bool
+	MaxPages *int32
+}
+
+// search returns the filter as the search of the books.
+func (f *bookFilter) search(ctx context.Context) (bookSearch, error) {
+	var search bookSearch
+	var err error
+	if f.Title != nil {
+		search.Title = *f.Title
+	}
+	if f.AuthorID != nil {
+		if search.AuthorID, err = parseID(ctx, "authorId", *f.AuthorID); err != nil {
+			return search, err
+		}
+	}
+	if f.Category != nil {
+		if search.Category, err = parseID(ctx, "category", *f.Category); err != nil {
+			return search, err
+		}
+	}
+	if f.Tags != nil {
+		search.Tags = *f.Tags
+	}
+	search.InStock = f.InStock
+	if f.MaxPages != nil {
+		maxPages := int(*f.MaxPages)
+		search.MaxPages = &maxPages
+	}
+	return search, nil
+}
+
+type authorFilter struct {
+	Name *string
+}
+
+// bookInput are the fields of a book sent with a mutation, fields left out
+// are not changed.
+type bookInput struct {
+	Title         graphql.NullString
+	Page          graphql.NullInt
+	Stock         graphql.NullInt
+	Price         graphql.NullString
+	StockCode     graphql.NullString
+	ISBN          graphql.NullString
+	AuthorID      nullID
+	WorkID        nullID
+	PublisherID   nullID
+	Format        graphql.NullString
+	Edition       graphql.NullInt
+	PublishedYear graphql.NullInt
+}
+
+// document returns the fields which were sent as a JSON Merge Patch of the
+// book's json document, fields sent as null are removed.
+func (in bookInput) document(ctx context.Context) ([]byte, error) {
+	fields := map[string]interface{}{}
+	setString := func(name string, value graphql.NullString) {
+		if value.Set {
+			fields[name] = value.Value
+		}
+	}
+	setInt := func(name string, value graphql.NullInt) {
+		if value.Set {
+			fields[name] = value.Value
+		}
+	}
+	setString("title", in.Title)
+	setInt("page", in.Page)
+	setInt("stock", in.Stock)
+	setString("price", in.Price)
+	setString("stockCode", in.StockCode)
+	setString("ISBN", in.ISBN)
+	setString("format", in.Format)
+	setInt("edition", in.Edition)
+	setInt("publishedYear", in.PublishedYear)
+
+	for _, ref := range []struct {
+		field, arg string
+		id         nullID
+	}{
+		{"AuthorID", "authorId", in.AuthorID},
+		{"WorkID", "workId", in.WorkID},
+		{"PublisherID", "publisherId", in.PublisherID},
+	} {
+		if !ref.id.Set {
+			continue
+		}
+		if ref.id.Value == nil {
+			fields[ref.field] = nil
+			continue
+		}
+		parsed, err := parseID(ctx, ref.arg, *ref.id.Value)
+		if err != nil {
+			return nil, err
+		}
+		fields[ref.field] = parsed
+	}
+	return json.Marshal(fields)
+}
+
+// authorInput are the fields of an author sent with a mutation.
+type authorInput struct {
+	Name graphql.NullString
+}
+
+func (in authorInput) document() ([]byte, error) {
+	fields := map[string]interface{}{}
+	if in.Name.Set {
+		fields["Name"] = in.Name.Value
+	}
+	return json.Marshal(fields)
+}
+
+// nullID is an ID which can be null, Set tells null from left out.
+type nullID struct {
+	Value *graphql.ID
+	Set   bool
+}
+
+func (nullID) ImplementsGraphQLType(name string) bool {
+	return name == "ID"
+}
+
+func (n *nullID) UnmarshalGraphQL(input interface{}) error {
+	n.Set = true
+	switch value := input.(type) {
+	case nil:
+	case string:
+		id := graphql.ID(value)
+		n.Value = &id
+	case int32:
+		id := graphql.ID(strconv.Itoa(int(value)))
+		n.Value = &id
+	default:
+		return fmt.Errorf("wrong type for ID: %T", input)
+	}
+	return nil
+}
+
+func (n *nullID) Nullable() {}
+
+func uintID(id uint) graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(id), 10))
+}
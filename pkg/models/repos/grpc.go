@@ -0,0 +1,468 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/librarypb"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// GRPCRepository serves the LibraryService of librarypb. Reads and writes
+// go through the book and author repositories and the events through the
+// broker, so they follow the same rules as the REST routes.
+type GRPCRepository struct {
+	librarypb.UnimplementedLibraryServiceServer
+
+	books       *BookRepository
+	authors     *AuthorRepository
+	broker      *events.Broker
+	replayLimit int
+}
+
+// NewGRPCRepository returns the gRPC service, at most replayLimit missed
+// events are resent to resuming watchers.
+func NewGRPCRepository(books *BookRepository, authors *AuthorRepository, broker *events.Broker, replayLimit int) *GRPCRepository {
+	return &GRPCRepository{books: books, authors: authors, broker: broker, replayLimit: replayLimit}
+}
+
+// grpcError turns the error of a method into the gRPC status matching the
+// status the REST api answers with, server errors are logged like the ones
+// of writeError.
+func grpcError(ctx context.Context, err error) error {
+	st := http_errors.GRPCStatus(err)
+	logger := logging.FromContext(ctx)
+	switch st.Code() {
+	case codes.Internal, codes.Unknown:
+		logger.Error("grpc call failed", "code", st.Code(), "error", err)
+	default:
+		logger.Debug("grpc call rejected", "code", st.Code(), "error", err)
+	}
+	return st.Err()
+}
+
+// invalid is the error of an invalid argument.
+func invalid(ctx context.Context, format string, args ...interface{}) error {
+	return grpcError(ctx, http_errors.NewRestError(http.StatusBadRequest,
+		fmt.Sprintf("%s: %s", http_errors.BadRequest, fmt.Sprintf(format, args...)), nil))
+}
+
+// requireAuthors rejects the author methods like the /authors routes.
+func requireAuthors(ctx context.Context) error {
+	if !CanAccessAuthors(ctx) {
+		return grpcError(ctx, http_errors.NewRestError(http.StatusUnauthorized, "Token not found", "authors require a token"))
+	}
+	return nil
+}
+
+func requireID(ctx context.Context, name string, id uint64) (uint, error) {
+	if id == 0 {
+		return 0, invalid(ctx, "%s must be a positive integer", name)
+	}
+	return uint(id), nil
+}
+
+// requireVersion is the precondition of a change made for version, like
+// If-Match it is required.
+func requireVersion(version uint64) func(version uint) error {
+	if version == 0 {
+		return func(uint) error {
+			return http_errors.NewPreconditionRequiredError("the version of the record must be sent")
+		}
+	}
+	return versionIs(uint(version))
+}
+
+// pageOf checks the paging of a search, 0 selects the first page and the
+// default page size.
+func pageOf(ctx context.Context, page, perPage int32) (offset, limit int, err error) {
+	if page == 0 {
+		page = 1
+	}
+	if perPage == 0 {
+		perPage = defaultPageSize
+	}
+	if page < 0 {
+		return 0, 0, invalid(ctx, "page must be a positive integer")
+	}
+	if perPage < 0 || perPage > maxPageSize {
+		return 0, 0, invalid(ctx, "per_page must be between 1 and %d", maxPageSize)
+	}
+	return int(page-1) * int(perPage), int(perPage), nil
+}
+
+func (g *GRPCRepository) GetBook(ctx context.Context, req *librarypb.GetBookRequest) (*librarypb.Book, error) {
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	var book models.Book
+	if err := g.books.db.WithContext(ctx).Take(&book, id).Error; err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return bookMessage(book), nil
+}
+
+func (g *GRPCRepository) GetBookByISBN(ctx context.Context, req *librarypb.GetBookByISBNRequest) (*librarypb.Book, error) {
+	book, err := g.books.bookByISBN(ctx, req.GetIsbn())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return bookMessage(book), nil
+}
+
+func (g *GRPCRepository) SearchBooks(ctx context.Context, req *librarypb.SearchBooksRequest) (*librarypb.SearchBooksResponse, error) {
+	offset, limit, err := pageOf(ctx, req.GetPage(), req.GetPerPage())
+	if err != nil {
+		return nil, err
+	}
+	search := bookSearch{
+		Title:    req.GetTitle(),
+		AuthorID: uint(req.GetAuthorId()),
+		Category: uint(req.GetCategory()),
+		Tags:     req.GetTags(),
+	}
+	if req.GetInStock() != nil {
+		inStock := req.GetInStock().GetValue()
+		search.InStock = &inStock
+	}
+	if req.GetMaxPages() != 0 {
+		maxPages := int(req.GetMaxPages())
+		search.MaxPages = &maxPages
+	}
+
+	books, total, err := g.books.searchBooks(ctx, search, offset, limit)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	resp := &librarypb.SearchBooksResponse{TotalCount: total}
+	for _, book := range books {
+		resp.Books = append(resp.Books, bookMessage(book))
+	}
+	return resp, nil
+}
+
+func (g *GRPCRepository) CreateBook(ctx context.Context, req *librarypb.CreateBookRequest) (*librarypb.Book, error) {
+	doc, err := bookDocument(req.GetBook())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	var book models.Book
+	if err := json.Unmarshal(doc, &book); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if err := g.books.createBook(ctx, &book); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return bookMessage(book), nil
+}
+
+func (g *GRPCRepository) UpdateBook(ctx context.Context, req *librarypb.UpdateBookRequest) (*librarypb.Book, error) {
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	patch, err := bookDocument(req.GetBook())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	book, err := g.books.changeBook(ctx, id, requireVersion(req.GetVersion()), func(current []byte) ([]byte, error) {
+		return bookRepresentation.mergePatch(current, patch)
+	})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return bookMessage(book), nil
+}
+
+func (g *GRPCRepository) DeleteBook(ctx context.Context, req *librarypb.DeleteBookRequest) (*emptypb.Empty, error) {
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := g.books.deleteBook(ctx, id, requireVersion(req.GetVersion())); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *GRPCRepository) BuyBook(ctx context.Context, req *librarypb.BuyBookRequest) (*librarypb.Book, error) {
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetQuantity() <= 0 {
+		return nil, invalid(ctx, "quantity must be a positive integer")
+	}
+	var precondition func(version uint) error
+	if req.GetVersion() != nil {
+		precondition = versionIs(uint(req.GetVersion().GetValue()))
+	}
+	book, err := g.books.buyBook(ctx, id, int(req.GetQuantity()), precondition)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return bookMessage(book), nil
+}
+
+func (g *GRPCRepository) GetAuthor(ctx context.Context, req *librarypb.GetAuthorRequest) (*librarypb.Author, error) {
+	if err := requireAuthors(ctx); err != nil {
+		return nil, err
+	}
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	var author models.Author
+	if err := g.authors.db.WithContext(ctx).Take(&author, id).Error; err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return authorMessage(author), nil
+}
+
+func (g *GRPCRepository) SearchAuthors(ctx context.Context, req *librarypb.SearchAuthorsRequest) (*librarypb.SearchAuthorsResponse, error) {
+	if err := requireAuthors(ctx); err != nil {
+		return nil, err
+	}
+	offset, limit, err := pageOf(ctx, req.GetPage(), req.GetPerPage())
+	if err != nil {
+		return nil, err
+	}
+	authors, total, err := g.authors.searchAuthors(ctx, req.GetName(), offset, limit)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	resp := &librarypb.SearchAuthorsResponse{TotalCount: total}
+	for _, author := range authors {
+		resp.Authors = append(resp.Authors, authorMessage(author))
+	}
+	return resp, nil
+}
+
+func (g *GRPCRepository) CreateAuthor(ctx context.Context, req *librarypb.CreateAuthorRequest) (*librarypb.Author, error) {
+	if err := requireAuthors(ctx); err != nil {
+		return nil, err
+	}
+	var author models.Author
+	if name := req.GetAuthor().GetName(); name != nil {
+		author.Name = name.GetValue()
+	}
+	if err := g.authors.createAuthor(ctx, &author); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return authorMessage(author), nil
+}
+
+func (g *GRPCRepository) UpdateAuthor(ctx context.Context, req *librarypb.UpdateAuthorRequest) (*librarypb.Author, error) {
+	if err := requireAuthors(ctx); err != nil {
+		return nil, err
+	}
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if name := req.GetAuthor().GetName(); name != nil {
+		fields["Name"] = name.GetValue()
+	}
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	author, err := g.authors.changeAuthor(ctx, id, requireVersion(req.GetVersion()), func(current []byte) ([]byte, error) {
+		return authorRepresentation.mergePatch(current, patch)
+	})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return authorMessage(author), nil
+}
+
+func (g *GRPCRepository) DeleteAuthor(ctx context.Context, req *librarypb.DeleteAuthorRequest) (*emptypb.Empty, error) {
+	if err := requireAuthors(ctx); err != nil {
+		return nil, err
+	}
+	id, err := requireID(ctx, "id", req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := g.authors.deleteAuthor(ctx, id, requireVersion(req.GetVersion())); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// WatchEvents streams the events like StreamEvents. A watcher resuming
+// with after_id first gets the events it missed, or a reset event when it
+// missed more than the replay limit. Streams end with Unavailable when the
+// server shuts down and with ResourceExhausted when the watcher fell too
+// far behind, it resumes with the id of the last event it got.
+func (g *GRPCRepository) WatchEvents(req *librarypb.WatchEventsRequest, stream librarypb.LibraryService_WatchEventsServer) error {
+	ctx := stream.Context()
+	books := make([]uint, 0, len(req.GetBookIds()))
+	for _, id := range req.GetBookIds() {
+		if id == 0 {
+			return invalid(ctx, "book_ids must be positive integers")
+		}
+		books = append(books, uint(id))
+	}
+	filter, err := newStreamFilter(req.GetEntity(), books, req.GetTypes())
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+
+	sub, err := g.broker.Subscribe(ctx, filter)
+	if errors.Is(err, events.ErrClosed) {
+		return status.Error(codes.Unavailable, "Server is shutting down")
+	}
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+	defer g.broker.Unsubscribe(sub)
+
+	var missed []events.Event
+	complete := true
+	if req.GetAfterId() > 0 {
+		if missed, complete, err = g.broker.Replay(ctx, filter, uint(req.GetAfterId()), sub.From, g.replayLimit); err != nil {
+			return grpcError(ctx, err)
+		}
+	}
+	if !complete {
+		reset, err := json.Marshal(map[string]string{
+			"reason": fmt.Sprintf("more than %d events were missed, reload the current state", g.replayLimit),
+		})
+		if err != nil {
+			return grpcError(ctx, err)
+		}
+		if err := stream.Send(&librarypb.Event{Id: uint64(sub.From), Type: "reset", Data: reset}); err != nil {
+			return err
+		}
+	}
+	// events committed late may be replayed and streamed both
+	replayed := make(map[uint]bool, len(missed))
+	for _, event := range missed {
+		replayed[event.ID] = true
+		if err := stream.Send(eventMessage(event)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case event, ok := <-sub.Events():
+			if !ok {
+				if errors.Is(sub.Err(), events.ErrSlow) {
+					return status.Error(codes.ResourceExhausted, "the watcher fell behind, resume with after_id")
+				}
+				return status.Error(codes.Unavailable, "Server is shutting down")
+			}
+			if replayed[event.ID] {
+				continue
+			}
+			if err := stream.Send(eventMessage(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// bookDocument returns the fields set in input as a JSON Merge Patch of
+// the book's json document, references set to 0 are removed.
+func bookDocument(input *librarypb.BookInput) ([]byte, error) {
+	fields := map[string]interface{}{}
+	setString := func(name string, value *wrapperspb.StringValue) {
+		if value != nil {
+			fields[name] = value.GetValue()
+		}
+	}
+	setInt := func(name string, value *wrapperspb.Int32Value) {
+		if value != nil {
+			fields[name] = value.GetValue()
+		}
+	}
+	setID := func(name string, value *wrapperspb.UInt64Value) {
+		switch {
+		case value == nil:
+		case value.GetValue() == 0:
+			fields[name] = nil
+		default:
+			fields[name] = value.GetValue()
+		}
+	}
+	setString("title", input.GetTitle())
+	setInt("page", input.GetPage())
+	setInt("stock", input.GetStock())
+	setString("price", input.GetPrice())
+	setString("stockCode", input.GetStockCode())
+	setString("ISBN", input.GetIsbn())
+	setID("AuthorID", input.GetAuthorId())
+	setID("WorkID", input.GetWorkId())
+	setID("PublisherID", input.GetPublisherId())
+	setString("format", input.GetFormat())
+	setInt("edition", input.GetEdition())
+	setInt("publishedYear", input.GetPublishedYear())
+	return json.Marshal(fields)
+}
+
+func bookMessage(book models.Book) *librarypb.Book {
+	msg := &librarypb.Book{
+		Id:            uint64(book.ID),
+		Version:       uint64(book.Version),
+		Title:         book.Title,
+		Page:          int32(book.Page),
+		Stock:         int32(book.Stock),
+		Price:         book.Price,
+		StockCode:     book.StockCode,
+		Isbn:          book.ISBN,
+		Format:        book.Format,
+		Edition:       int32(book.Edition),
+		PublishedYear: int32(book.PublishedYear),
+		CreatedAt:     timestamppb.New(book.CreatedAt),
+		UpdatedAt:     timestamppb.New(book.UpdatedAt),
+	}
+	if book.AuthorID != nil {
+		msg.AuthorId = uint64(*book.AuthorID)
+	}
+	if book.WorkID != nil {
+		msg.WorkId = uint64(*book.WorkID)
+	}
+	if book.PublisherID != nil {
+		msg.PublisherId = uint64(*book.PublisherID)
+	}
+	return msg
+}
+
+func authorMessage(author models.Author) *librarypb.Author {
+	return &librarypb.Author{
+		Id:        uint64(author.ID),
+		Version:   uint64(author.Version),
+		Name:      author.Name,
+		CreatedAt: timestamppb.New(author.CreatedAt),
+		UpdatedAt: timestamppb.New(author.UpdatedAt),
+	}
+}
+
+func eventMessage(event events.Event) *librarypb.Event {
+	return &librarypb.Event{
+		Id:         uint64(event.ID),
+		Type:       string(event.Type),
+		Entity:     event.Entity,
+		EntityId:   uint64(event.EntityID),
+		OccurredAt: timestamppb.New(event.OccurredAt),
+		RequestId:  event.RequestID,
+		Data:       event.Data,
+	}
+}
@@ -0,0 +1,121 @@
+package repos
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/librarypb"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/gorm"
+)
+
+// newTestClient serves the library service over an in-memory connection
+// behind the interceptors of the server and returns a client of it.
+func newTestClient(t *testing.T, db *gorm.DB) librarypb.LibraryServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.UnaryRequestID,
+		middleware.UnaryAccessLog,
+		middleware.UnaryDeadline(5*time.Second),
+	))
+	librarypb.RegisterLibraryServiceServer(srv, NewGRPCRepository(NewBookRepository(db), NewAuthorRepository(db, trash.Block), nil, 0))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return librarypb.NewLibraryServiceClient(conn)
+}
+
+func TestGRPCBooks(t *testing.T) {
+	db := newTestDB(t)
+	author := models.Author{Name: "Ursula K. Le Guin"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"The Dispossessed", "The Lathe of Heaven"} {
+		if err := db.Create(&models.Book{Title: title, Page: 300, Stock: 2, Price: "7.50", AuthorID: &author.ID}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := newTestClient(t, db)
+	ctx := context.Background()
+
+	var header metadata.MD
+	book, err := client.GetBook(ctx, &librarypb.GetBookRequest{Id: 2}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.GetTitle() != "The Lathe of Heaven" || book.GetAuthorId() != uint64(author.ID) {
+		t.Errorf("got book %q of author %d", book.GetTitle(), book.GetAuthorId())
+	}
+	if ids := header.Get(strings.ToLower(middleware.RequestIDHeader)); len(ids) != 1 || ids[0] == "" {
+		t.Errorf("request id header %v", ids)
+	}
+
+	list, err := client.SearchBooks(ctx, &librarypb.SearchBooksRequest{AuthorId: uint64(author.ID), PerPage: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.GetTotalCount() != 2 || len(list.GetBooks()) != 1 {
+		t.Errorf("got %d of %d books, want 1 of 2", len(list.GetBooks()), list.GetTotalCount())
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	client := newTestClient(t, newTestDB(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"missing book", func() error {
+			_, err := client.GetBook(ctx, &librarypb.GetBookRequest{Id: 42})
+			return err
+		}, codes.NotFound},
+		{"missing isbn", func() error {
+			_, err := client.GetBookByISBN(ctx, &librarypb.GetBookByISBNRequest{Isbn: "9780441013593"})
+			return err
+		}, codes.NotFound},
+		{"zero id", func() error {
+			_, err := client.GetBook(ctx, &librarypb.GetBookRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"negative page", func() error {
+			_, err := client.SearchBooks(ctx, &librarypb.SearchBooksRequest{Page: -1})
+			return err
+		}, codes.InvalidArgument},
+		{"page too large", func() error {
+			_, err := client.SearchBooks(ctx, &librarypb.SearchBooksRequest{PerPage: maxPageSize + 1})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code %s, want %s: %v", code, tt.code, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	postgres "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/db"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// writeJSON sends v as a json response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends err as a RestError with its matching status code and the
+// id of the request, server errors are logged with the request's logger.
+// Nothing is sent to clients which went away, their queries were cancelled.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	logger := logging.FromContext(r.Context())
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		logger.Debug("client went away", "error", err)
+		return
+	}
+
+	restErr := http_errors.ParseErrors(err)
+	if restErr.Status() >= http.StatusInternalServerError {
+		logger.Error("request failed", "status", restErr.Status(), "error", err)
+	} else {
+		logger.Debug("request rejected", "status", restErr.Status(), "error", err)
+	}
+
+	writeJSON(w, restErr.Status(), http_errors.WithRequestID(restErr, middleware.RequestIDFromContext(r.Context())))
+}
+
+// pathID reads the given dynamic parameter as a positive id
+func pathID(r *http.Request, name string) (uint, error) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil || id <= 0 {
+		return 0, http_errors.NewValidationError(http.StatusBadRequest, http_errors.BadRequest.Error(),
+			[]http_errors.Detail{{In: "path", Name: name, Message: "must be a positive integer"}})
+	}
+	return uint(id), nil
+}
+
+// validate runs the validation of an entity, its problems are reported to
+// the client as a bad request
+func validate(entity interface{ Validate() error }) error {
+	err := entity.Validate()
+	var invalid *models.ValidationError
+	if errors.As(err, &invalid) {
+		return http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: %s", http_errors.BadRequest, invalid), invalid.Problems)
+	}
+	return err
+}
+
+// replica runs the queries of db on a read replica when one is configured.
+// List and search queries use it as they can live with replication lag,
+// preloaded associations are still read from the primary.
+func replica(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Use(postgres.ReplicaResolver))
+}
+
+// preload applies the associations requested with the comma separated
+// "preload" query parameter, e.g. ?preload=Editions,Series. Only the
+// associations listed in allowed can be requested. The returned db runs
+// its statements with the request's context.
+func preload(db *gorm.DB, r *http.Request, allowed ...string) (*gorm.DB, error) {
+	db = db.WithContext(r.Context())
+	param := r.URL.Query().Get("preload")
+	if param == "" {
+		return db, nil
+	}
+
+	for _, requested := range strings.Split(param, ",") {
+		requested = strings.TrimSpace(requested)
+		found := false
+		for _, association := range allowed {
+			if strings.EqualFold(requested, association) {
+				db = db.Preload(association)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, badQuery("preload", fmt.Sprintf("cannot preload %q, allowed: %s", requested, strings.Join(allowed, ", ")))
+		}
+	}
+	return db, nil
+}
+
+// paging reads ?page= (from 1) and ?per_page= of paged lists
+func paging(r *http.Request) (page, perPage int, err error) {
+	query := r.URL.Query()
+	page, perPage = 1, defaultPageSize
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page <= 0 {
+			return 0, 0, badQuery("page", "must be a positive integer")
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage <= 0 || perPage > maxPageSize {
+			return 0, 0, badQuery("per_page", fmt.Sprintf("must be between 1 and %d", maxPageSize))
+		}
+	}
+	return page, perPage, nil
+}
+
+// badQuery is the error of an invalid query parameter, the parameter and
+// the problem are sent in the details like the ones of the validator
+func badQuery(name, message string) error {
+	return http_errors.NewValidationError(http.StatusBadRequest, http_errors.BadQueryParams.Error(),
+		[]http_errors.Detail{{In: "query", Name: name, Message: message}})
+}
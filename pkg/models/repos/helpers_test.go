@@ -0,0 +1,57 @@
+package repos
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+// TestBadParametersAreExplained checks that clients are told which
+// parameter was rejected and why, not only that the request was bad.
+func TestBadParametersAreExplained(t *testing.T) {
+	db := newTestDB(t)
+	books := NewBookRepository(db)
+	entries := NewAuditRepository(db)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		template string
+		target   string
+		message  string
+		detail   http_errors.Detail
+	}{
+		{"path id", books.GetBookByID, "/books/{id}", "/books/abc",
+			"Bad request: path id must be a positive integer",
+			http_errors.Detail{In: "path", Name: "id", Message: "must be a positive integer"}},
+		{"page", entries.GetAuditEntries, "/audit", "/audit?page=0",
+			"Invalid query params: query page must be a positive integer",
+			http_errors.Detail{In: "query", Name: "page", Message: "must be a positive integer"}},
+		{"per_page", entries.GetAuditEntries, "/audit", "/audit?per_page=1000",
+			"Invalid query params: query per_page must be between 1 and 500",
+			http_errors.Detail{In: "query", Name: "per_page", Message: "must be between 1 and 500"}},
+		{"preload", books.GetBookByID, "/books/{id}", "/books/1?preload=Author",
+			`Invalid query params: query preload cannot preload "Author", allowed: Work, Work.Series, Publisher, Categories, Tags`,
+			http_errors.Detail{In: "query", Name: "preload", Message: `cannot preload "Author", allowed: Work, Work.Series, Publisher, Categories, Tags`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.handler, http.MethodGet, tt.template, tt.target, "", nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			var restErr http_errors.RestError
+			if err := json.Unmarshal(rec.Body.Bytes(), &restErr); err != nil {
+				t.Fatal(err)
+			}
+			if restErr.ErrError != tt.message {
+				t.Errorf("message %q, want %q", restErr.ErrError, tt.message)
+			}
+			if len(restErr.ErrDetails) != 1 || restErr.ErrDetails[0] != tt.detail {
+				t.Errorf("details %+v, want %+v", restErr.ErrDetails, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package repos
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/importer"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+)
+
+type ImportRepository struct {
+	importer *importer.Importer
+}
+
+func NewImportRepository(db *gorm.DB) *ImportRepository {
+	return &ImportRepository{importer: importer.New(db)}
+}
+
+// Import imports the books or authors sent in the body and returns a report
+// of every row. The body format is read from ?format=csv|jsonl|json or from
+// the Content-Type header; ?entity=books|authors, ?dry_run=true,
+// ?create_authors=true, ?on_conflict=skip|update and ?batch_size=n tune the
+// import.
+func (i *ImportRepository) Import(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	query := r.URL.Query()
+
+	opts := importer.Options{
+		Entity:     importer.Entity(query.Get("entity")),
+		Format:     importer.Format(query.Get("format")),
+		OnConflict: importer.Conflict(query.Get("on_conflict")),
+	}
+	if opts.Format == "" {
+		opts.Format = importer.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+
+	var err error
+	if opts.DryRun, err = queryBool(query.Get("dry_run")); err == nil {
+		opts.CreateAuthors, err = queryBool(query.Get("create_authors"))
+	}
+	if err == nil && query.Get("batch_size") != "" {
+		opts.BatchSize, err = strconv.Atoi(query.Get("batch_size"))
+	}
+	if err == nil {
+		err = opts.Validate()
+	}
+	if err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadQueryParams, err), err))
+		return
+	}
+
+	report, err := i.importer.Import(r.Context(), r.Body, opts)
+	if err != nil && report == nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err))
+		return
+	}
+	if err != nil {
+		// the input broke off, the report still lists every row read so far
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// queryBool parses an optional boolean query parameter
+func queryBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
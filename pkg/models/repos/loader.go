@@ -0,0 +1,130 @@
+package repos
+
+import (
+	"context"
+	"sync"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+)
+
+// loader batches the lookups of records by id within a GraphQL request.
+// Lists prime it with the ids their items will ask for, the first load then
+// fetches all of them with a single query instead of one per item.
+type loader[V any] struct {
+	fetch func(ctx context.Context, ids []uint) (map[uint]V, error)
+
+	mu      sync.Mutex
+	pending map[uint]bool
+	looked  map[uint]bool
+	loaded  map[uint]V
+}
+
+func newLoader[V any](fetch func(ctx context.Context, ids []uint) (map[uint]V, error)) *loader[V] {
+	return &loader[V]{
+		fetch:   fetch,
+		pending: make(map[uint]bool),
+		looked:  make(map[uint]bool),
+		loaded:  make(map[uint]V),
+	}
+}
+
+// prime adds ids to the next fetch.
+func (l *loader[V]) prime(ids ...uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		if !l.looked[id] {
+			l.pending[id] = true
+		}
+	}
+}
+
+// store adds a record which was already read.
+func (l *loader[V]) store(id uint, v V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.looked[id], l.loaded[id] = true, v
+	delete(l.pending, id)
+}
+
+// load returns the record of id, found is false when it does not exist.
+// Concurrent loads wait for the fetch in progress, which usually brings
+// their record along.
+func (l *loader[V]) load(ctx context.Context, id uint) (v V, found bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.looked[id] {
+		v, found = l.loaded[id]
+		return v, found, nil
+	}
+
+	l.pending[id] = true
+	ids := make([]uint, 0, len(l.pending))
+	for pending := range l.pending {
+		ids = append(ids, pending)
+	}
+	l.pending = make(map[uint]bool)
+
+	fetched, err := l.fetch(ctx, ids)
+	if err != nil {
+		return v, false, err
+	}
+	for _, looked := range ids {
+		l.looked[looked] = true
+	}
+	for key, value := range fetched {
+		l.loaded[key] = value
+	}
+	v, found = l.loaded[id]
+	return v, found, nil
+}
+
+// loaders are the loaders of a single GraphQL request, records are cached
+// for the request only.
+type loaders struct {
+	// authors by id
+	authors *loader[models.Author]
+	// authorBooks are the books of each author, by author id
+	authorBooks *loader[[]models.Book]
+}
+
+func newLoaders(db *gorm.DB) *loaders {
+	l := &loaders{}
+	l.authors = newLoader(func(ctx context.Context, ids []uint) (map[uint]models.Author, error) {
+		var authors []models.Author
+		if err := db.WithContext(ctx).Where("id IN ?", ids).Find(&authors).Error; err != nil {
+			return nil, err
+		}
+		found := make(map[uint]models.Author, len(authors))
+		for _, author := range authors {
+			found[author.ID] = author
+		}
+		return found, nil
+	})
+	l.authorBooks = newLoader(func(ctx context.Context, ids []uint) (map[uint][]models.Book, error) {
+		var books []models.Book
+		if err := db.WithContext(ctx).Where("author_id IN ?", ids).Order("id").Find(&books).Error; err != nil {
+			return nil, err
+		}
+		found := make(map[uint][]models.Book, len(ids))
+		for _, id := range ids {
+			found[id] = []models.Book{}
+		}
+		for _, book := range books {
+			found[*book.AuthorID] = append(found[*book.AuthorID], book)
+		}
+		return found, nil
+	})
+	return l
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
@@ -0,0 +1,200 @@
+package repos
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// acceptPatch lists the patch formats PATCH requests may use.
+var acceptPatch = strings.Join([]string{mergePatchType, jsonPatchType}, ", ")
+
+// representation describes the json document of an entity which PUT
+// requests replace and PATCH requests modify.
+type representation struct {
+	// writable fields must all be sent with PUT
+	writable []string
+	// readOnly fields are ignored in PUT bodies and cannot be patched
+	readOnly []string
+}
+
+var (
+	bookRepresentation = representation{
+		writable: []string{"title", "page", "stock", "price", "stockCode", "ISBN", "AuthorID",
+			"WorkID", "PublisherID", "format", "edition", "publishedYear"},
+		readOnly: []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "version",
+			"Work", "Publisher", "Categories", "Tags"},
+	}
+	authorRepresentation = representation{
+		writable: []string{"Name"},
+		readOnly: []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "version", "Books"},
+	}
+)
+
+// replace reads the body of a PUT request, which has to be a complete
+// representation. It returns the writable fields as a json object.
+func (rep representation) replace(body []byte) ([]byte, error) {
+	fields, err := rep.fields(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, name := range rep.writable {
+		if _, ok := fields[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: %s", http_errors.MissingFields, strings.Join(missing, ", ")),
+			"PUT replaces the whole record, use PATCH to change single fields")
+	}
+	return rep.writableJSON(fields)
+}
+
+// patch applies the patch in the body of r to current, the stored record's
+// json document, according to the request's content type. It returns the
+// writable fields of the patched document as a json object, fields the
+// patch removed are left out.
+func (rep representation) patch(r *http.Request, current, body []byte) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var patched []byte
+	switch mediaType {
+	case mergePatchType:
+		return rep.mergePatch(current, body)
+	case jsonPatchType:
+		operations, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, http_errors.NewRestError(http.StatusBadRequest,
+				fmt.Sprintf("%s: invalid json patch", http_errors.BadRequest), err)
+		}
+		patched, err = operations.Apply(current)
+		if err != nil {
+			// the patch is well formed but does not fit the current record
+			if errors.Is(err, jsonpatch.ErrTestFailed) || errors.Is(err, jsonpatch.ErrMissing) ||
+				errors.Is(err, jsonpatch.ErrInvalidIndex) {
+				return nil, http_errors.NewRestError(http.StatusConflict,
+					fmt.Sprintf("json patch cannot be applied: %s", err), err)
+			}
+			return nil, http_errors.NewRestError(http.StatusBadRequest,
+				fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err)
+		}
+	default:
+		return nil, http_errors.NewRestError(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Content type must be one of %s", acceptPatch), mediaType)
+	}
+	return rep.patched(current, patched)
+}
+
+// mergePatch applies the JSON Merge Patch body to current, see patch.
+func (rep representation) mergePatch(current, body []byte) ([]byte, error) {
+	patched, err := jsonpatch.MergePatch(current, body)
+	if err != nil {
+		return nil, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: invalid merge patch", http_errors.BadRequest), err)
+	}
+	return rep.patched(current, patched)
+}
+
+// patched checks that the patch turning current into patched left the
+// read-only fields alone and returns the writable fields of patched.
+func (rep representation) patched(current, patched []byte) ([]byte, error) {
+	before, err := rep.fields(current)
+	if err != nil {
+		return nil, err
+	}
+	after, err := rep.fields(patched)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range rep.readOnly {
+		if !sameJSON(before[name], after[name]) {
+			return nil, http_errors.NewRestError(http.StatusBadRequest,
+				fmt.Sprintf("%s: %s is read-only", http_errors.BadRequest, name), name)
+		}
+	}
+	return rep.writableJSON(after)
+}
+
+// fields splits the json object doc into its fields, fields which are
+// neither writable nor read-only are rejected.
+func (rep representation) fields(doc []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil || fields == nil {
+		return nil, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: a json object is required", http_errors.BadRequest), err)
+	}
+
+	var unknown []string
+	for name := range fields {
+		if !contains(rep.writable, name) && !contains(rep.readOnly, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: unknown fields %s", http_errors.BadRequest, strings.Join(unknown, ", ")), unknown)
+	}
+	return fields, nil
+}
+
+func (rep representation) writableJSON(fields map[string]json.RawMessage) ([]byte, error) {
+	writable := make(map[string]json.RawMessage, len(rep.writable))
+	for _, name := range rep.writable {
+		if value, ok := fields[name]; ok {
+			writable[name] = value
+		}
+	}
+	return json.Marshal(writable)
+}
+
+// decodeStrict unmarshals the writable fields into v, values of the wrong
+// type are reported as bad requests.
+func decodeStrict(doc []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return http_errors.NewRestError(http.StatusBadRequest,
+			fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err)
+	}
+	return nil
+}
+
+// sameJSON compares two json values regardless of their formatting, a
+// missing value only equals another missing one.
+func sameJSON(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
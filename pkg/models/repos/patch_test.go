@@ -0,0 +1,101 @@
+package repos
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+var testRepresentation = representation{
+	writable: []string{"name", "stock"},
+	readOnly: []string{"ID", "version"},
+}
+
+const testRecord = `{"ID":1,"version":2,"name":"Dune","stock":3}`
+
+// checkPatchResult compares the result of a representation method with the
+// document or the status and message it should end with.
+func checkPatchResult(t *testing.T, got []byte, err error, want string, status int, message string) {
+	t.Helper()
+	if status == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !sameJSON(got, []byte(want)) {
+			t.Errorf("got %s, want %s", got, want)
+		}
+		return
+	}
+	var restErr http_errors.RestError
+	if !errors.As(err, &restErr) {
+		t.Fatalf("got %s, %v, want a %d error", got, err, status)
+	}
+	if restErr.ErrStatus != status || !strings.Contains(restErr.ErrError, message) {
+		t.Errorf("error %d %q, want %d %q", restErr.ErrStatus, restErr.ErrError, status, message)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		status      int
+		message     string
+	}{
+		{"merge patch", mergePatchType, `{"stock":4}`, `{"name":"Dune","stock":4}`, 0, ""},
+		{"merge patch removes null", mergePatchType, `{"name":null}`, `{"stock":3}`, 0, ""},
+		{"merge patch with charset", mergePatchType + "; charset=utf-8", `{"name":"Dune Messiah"}`, `{"name":"Dune Messiah","stock":3}`, 0, ""},
+		{"merge patch keeps read-only value", mergePatchType, `{"version":2,"stock":1}`, `{"name":"Dune","stock":1}`, 0, ""},
+		{"merge patch changes read-only", mergePatchType, `{"ID":7}`, "", http.StatusBadRequest, "ID is read-only"},
+		{"merge patch removes read-only", mergePatchType, `{"version":null}`, "", http.StatusBadRequest, "version is read-only"},
+		{"merge patch adds unknown", mergePatchType, `{"price":"1.00","author":1}`, "", http.StatusBadRequest, "unknown fields author, price"},
+		{"merge patch not json", mergePatchType, `{"stock":`, "", http.StatusBadRequest, "invalid merge patch"},
+		{"json patch", jsonPatchType, `[{"op":"replace","path":"/stock","value":5},{"op":"remove","path":"/name"}]`, `{"stock":5}`, 0, ""},
+		{"json patch passing test", jsonPatchType, `[{"op":"test","path":"/version","value":2},{"op":"replace","path":"/stock","value":0}]`, `{"name":"Dune","stock":0}`, 0, ""},
+		{"json patch failing test", jsonPatchType, `[{"op":"test","path":"/version","value":1},{"op":"replace","path":"/stock","value":0}]`, "", http.StatusConflict, "json patch cannot be applied"},
+		{"json patch missing path", jsonPatchType, `[{"op":"remove","path":"/missing"}]`, "", http.StatusConflict, "json patch cannot be applied"},
+		{"json patch changes read-only", jsonPatchType, `[{"op":"replace","path":"/ID","value":7}]`, "", http.StatusBadRequest, "ID is read-only"},
+		{"json patch adds unknown", jsonPatchType, `[{"op":"add","path":"/price","value":"1.00"}]`, "", http.StatusBadRequest, "unknown fields price"},
+		{"json patch not a list", jsonPatchType, `{"op":"remove","path":"/name"}`, "", http.StatusBadRequest, "invalid json patch"},
+		{"plain json", "application/json", `{"stock":4}`, "", http.StatusUnsupportedMediaType, "Content type must be one of " + acceptPatch},
+		{"no content type", "", `{"stock":4}`, "", http.StatusUnsupportedMediaType, "Content type must be one of " + acceptPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/", nil)
+			r.Header.Set("Content-Type", tt.contentType)
+			got, err := testRepresentation.patch(r, []byte(testRecord), []byte(tt.body))
+			checkPatchResult(t, got, err, tt.want, tt.status, tt.message)
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		status  int
+		message string
+	}{
+		{"complete", `{"name":"Dune Messiah","stock":1}`, `{"name":"Dune Messiah","stock":1}`, 0, ""},
+		{"read-only fields are ignored", `{"ID":9,"version":1,"name":"Dune","stock":1}`, `{"name":"Dune","stock":1}`, 0, ""},
+		{"null is a value", `{"name":null,"stock":1}`, `{"name":null,"stock":1}`, 0, ""},
+		{"missing field", `{"name":"Dune"}`, "", http.StatusBadRequest, "Missing fields: stock"},
+		{"unknown field", `{"name":"Dune","stock":1,"price":"1.00"}`, "", http.StatusBadRequest, "unknown fields price"},
+		{"not an object", `["Dune"]`, "", http.StatusBadRequest, "a json object is required"},
+		{"null", `null`, "", http.StatusBadRequest, "a json object is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testRepresentation.replace([]byte(tt.body))
+			checkPatchResult(t, got, err, tt.want, tt.status, tt.message)
+		})
+	}
+}
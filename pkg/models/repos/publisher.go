@@ -0,0 +1,157 @@
+package repos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+)
+
+type PublisherRepository struct {
+	db *gorm.DB
+}
+
+func NewPublisherRepository(db *gorm.DB) *PublisherRepository {
+	return &PublisherRepository{db: db}
+}
+
+func (p *PublisherRepository) Migration() error {
+	return p.db.AutoMigrate(&models.Publisher{})
+}
+
+// GetAllPublishers lists all available publishers
+func (p *PublisherRepository) GetAllPublishers(w http.ResponseWriter, r *http.Request) {
+	db, err := preload(replica(p.db), r, "Books")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var publishers []models.Publisher
+	if result := db.Find(&publishers); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, publishers)
+}
+
+// GetPublisherByID returns publisher information according to given id
+func (p *PublisherRepository) GetPublisherByID(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	db, err := preload(p.db, r, "Books")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var publisher models.Publisher
+	if result := db.First(&publisher, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, publisher)
+}
+
+// AddPublisher creates a new publisher
+func (p *PublisherRepository) AddPublisher(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var publisher models.Publisher
+	if err := json.Unmarshal(body, &publisher); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if result := p.db.WithContext(r.Context()).Create(&publisher); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, publisher)
+}
+
+// UpdatePublisher updates the given publisher
+func (p *PublisherRepository) UpdatePublisher(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var publisher models.Publisher
+	if result := p.db.WithContext(r.Context()).First(&publisher, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	// Apply the request body on top of the stored publisher
+	if err := json.Unmarshal(body, &publisher); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	publisher.ID = id
+
+	if result := p.db.WithContext(r.Context()).Omit("Books").Save(&publisher); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, publisher)
+}
+
+// DeletePublisher deletes given publisher according to given id
+func (p *PublisherRepository) DeletePublisher(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var publisher models.Publisher
+	if result := p.db.WithContext(r.Context()).First(&publisher, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	if result := p.db.WithContext(r.Context()).Delete(&publisher); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Deleted")
+}
+
+// FindPublisherByName returns publishers found according to given search query
+func (p *PublisherRepository) FindPublisherByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var publishers []models.Publisher
+	if result := replica(p.db).WithContext(r.Context()).Where("name ILIKE ? ", "%"+vars["name"]+"%").Find(&publishers); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, publishers)
+}
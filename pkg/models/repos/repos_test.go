@@ -0,0 +1,59 @@
+package repos
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a migrated sqlite database of its own for every test.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "library.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, migration := range []func() error{
+		NewAuthorRepository(db, trash.Block).Migration,
+		NewPublisherRepository(db).Migration,
+		NewSeriesRepository(db).Migration,
+		NewWorkRepository(db).Migration,
+		NewCategoryRepository(db).Migration,
+		NewTagRepository(db).Migration,
+		NewBookRepository(db).Migration,
+		NewAuditRepository(db).Migration,
+		NewWebhookRepository(db).Migration,
+	} {
+		if err := migration(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// serve sends a request to handler registered for method and path, path
+// variables are set from the template.
+func serve(t *testing.T, handler http.HandlerFunc, method, template, target string, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc(template, handler).Methods(method)
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,174 @@
+package repos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+)
+
+type SeriesRepository struct {
+	db *gorm.DB
+}
+
+func NewSeriesRepository(db *gorm.DB) *SeriesRepository {
+	return &SeriesRepository{db: db}
+}
+
+func (s *SeriesRepository) Migration() error {
+	return s.db.AutoMigrate(&models.Series{})
+}
+
+// GetAllSeries lists all available series
+func (s *SeriesRepository) GetAllSeries(w http.ResponseWriter, r *http.Request) {
+	db, err := preload(replica(s.db), r, "Works")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var series []models.Series
+	if result := db.Find(&series); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	for i := range series {
+		sortByVolume(series[i].Works)
+	}
+
+	writeJSON(w, http.StatusOK, series)
+}
+
+// GetSeriesByID returns series information according to given id
+func (s *SeriesRepository) GetSeriesByID(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	db, err := preload(s.db, r, "Works")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var series models.Series
+	if result := db.First(&series, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+	sortByVolume(series.Works)
+
+	writeJSON(w, http.StatusOK, series)
+}
+
+// AddSeries creates a new series
+func (s *SeriesRepository) AddSeries(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var series models.Series
+	if err := json.Unmarshal(body, &series); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if result := s.db.WithContext(r.Context()).Create(&series); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, series)
+}
+
+// UpdateSeries updates the given series
+func (s *SeriesRepository) UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var series models.Series
+	if result := s.db.WithContext(r.Context()).First(&series, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	// Apply the request body on top of the stored series
+	if err := json.Unmarshal(body, &series); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	series.ID = id
+
+	if result := s.db.WithContext(r.Context()).Omit("Works").Save(&series); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, series)
+}
+
+// DeleteSeries deletes given series according to given id
+func (s *SeriesRepository) DeleteSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var series models.Series
+	if result := s.db.WithContext(r.Context()).First(&series, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	if result := s.db.WithContext(r.Context()).Delete(&series); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Deleted")
+}
+
+// FindSeriesByName returns series found according to given search query
+func (s *SeriesRepository) FindSeriesByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var series []models.Series
+	if result := replica(s.db).WithContext(r.Context()).Where("name ILIKE ? ", "%"+vars["name"]+"%").Find(&series); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, series)
+}
+
+// sortByVolume orders works by their position in the series, works without a
+// volume number are listed last
+func sortByVolume(works []models.Work) {
+	sort.SliceStable(works, func(i, j int) bool {
+		if works[j].SeriesVolume == nil {
+			return works[i].SeriesVolume != nil
+		}
+		return works[i].SeriesVolume != nil && *works[i].SeriesVolume < *works[j].SeriesVolume
+	})
+}
@@ -0,0 +1,72 @@
+package repos
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+)
+
+type TagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+func (t *TagRepository) Migration() error {
+	return t.db.AutoMigrate(&models.Tag{})
+}
+
+// TagCount is a tag together with the number of books carrying it
+type TagCount struct {
+	ID        uint   `json:"ID"`
+	Name      string `json:"Name"`
+	BookCount int64  `json:"BookCount"`
+}
+
+// GetAllTags lists all tags with their number of books
+func (t *TagRepository) GetAllTags(w http.ResponseWriter, r *http.Request) {
+	var tags []TagCount
+
+	if result := replica(t.db).WithContext(r.Context()).Raw(`SELECT tags.id, tags.name, COUNT(books.id) AS book_count
+		FROM tags
+		LEFT JOIN book_tags ON book_tags.tag_id = tags.id
+		LEFT JOIN books ON books.id = book_tags.book_id AND books.deleted_at IS NULL
+		GROUP BY tags.id, tags.name
+		ORDER BY tags.name`).Scan(&tags); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// GetTagBooks returns the books carrying the given tag
+func (t *TagRepository) GetTagBooks(w http.ResponseWriter, r *http.Request) {
+	name := models.NormalizeTag(mux.Vars(r)["name"])
+
+	var tag models.Tag
+	if result := t.db.WithContext(r.Context()).Where("name = ?", name).First(&tag); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	db, err := preload(replica(t.db), r, BookPreloads...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var books []models.Book
+	if result := db.
+		Where("books.id IN (SELECT book_id FROM book_tags WHERE tag_id = ?)", tag.ID).
+		Find(&books); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, books)
+}
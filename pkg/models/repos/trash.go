@@ -0,0 +1,123 @@
+package repos
+
+import (
+	"net/http"
+	"strconv"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"gorm.io/gorm"
+)
+
+// TrashRepository lists, restores and purges deleted books and authors.
+type TrashRepository struct {
+	db    *gorm.DB
+	trash *trash.Trash
+}
+
+func NewTrashRepository(db *gorm.DB, trash *trash.Trash) *TrashRepository {
+	return &TrashRepository{db: db, trash: trash}
+}
+
+// GetDeletedBooks lists the deleted books, most recently deleted first
+func (t *TrashRepository) GetDeletedBooks(w http.ResponseWriter, r *http.Request) {
+	var books []models.Book
+	if result := t.db.WithContext(r.Context()).Unscoped().
+		Where("deleted_at IS NOT NULL").Order("deleted_at DESC").
+		Find(&books); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, books)
+}
+
+// GetDeletedAuthors lists the deleted authors, most recently deleted first
+func (t *TrashRepository) GetDeletedAuthors(w http.ResponseWriter, r *http.Request) {
+	var authors []models.Author
+	if result := t.db.WithContext(r.Context()).Unscoped().
+		Where("deleted_at IS NOT NULL").Order("deleted_at DESC").
+		Find(&authors); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, authors)
+}
+
+// RestoreBook brings back the given deleted book
+func (t *TrashRepository) RestoreBook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	book, err := t.trash.RestoreBook(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeVersioned(w, r, http.StatusOK, book.Version, book)
+}
+
+// RestoreAuthor brings back the given deleted author, with ?books=true also
+// the author's deleted books
+func (t *TrashRepository) RestoreAuthor(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	books := false
+	if value := r.URL.Query().Get("books"); value != "" {
+		books, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, r, badQuery("books", "must be true or false"))
+			return
+		}
+	}
+
+	author, err := t.trash.RestoreAuthor(r.Context(), id, books)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeVersioned(w, r, http.StatusOK, author.Version, author)
+}
+
+// PurgeBook deletes the given book for good, whether it was deleted before
+// or not
+func (t *TrashRepository) PurgeBook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := t.trash.PurgeBook(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Purged")
+}
+
+// PurgeAuthor deletes the given author for good, its books are handled
+// according to the delete policy
+func (t *TrashRepository) PurgeAuthor(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := t.trash.PurgeAuthor(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Purged")
+}
@@ -0,0 +1,194 @@
+package repos
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/trash"
+	"gorm.io/gorm"
+)
+
+// newShelf stores an author with a book on the shelf and a book in the
+// trash, authors are deleted with policy.
+func newShelf(t *testing.T, policy trash.Policy) (*gorm.DB, *AuthorRepository, *TrashRepository) {
+	t.Helper()
+	db := newTestDB(t)
+	author := models.Author{Name: "Ursula K. Le Guin"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	books := []models.Book{
+		{Title: "The Dispossessed", Price: "7.50", AuthorID: &author.ID},
+		{Title: "The Lathe of Heaven", Price: "6.50", AuthorID: &author.ID},
+	}
+	if err := db.Create(&books).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&books[1]).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, NewAuthorRepository(db, policy), NewTrashRepository(db, trash.New(db, policy))
+}
+
+// bookState describes a book as "shelf", "trash" or "purged" and its
+// author.
+func bookState(t *testing.T, db *gorm.DB, id uint) (string, *uint) {
+	t.Helper()
+	var book models.Book
+	result := db.Unscoped().Limit(1).Find(&book, id)
+	switch {
+	case result.Error != nil:
+		t.Fatal(result.Error)
+	case result.RowsAffected == 0:
+		return "purged", nil
+	case book.DeletedAt.Valid:
+		return "trash", book.AuthorID
+	}
+	return "shelf", book.AuthorID
+}
+
+func TestAuthorPolicies(t *testing.T) {
+	tests := []struct {
+		policy trash.Policy
+		// the status of deleting and then purging the author
+		deleted, purged int
+		// the books after the delete and after the purge
+		afterDelete, afterPurge [2]string
+		// whether the books still have an author after the delete
+		orphaned bool
+	}{
+		{trash.Block, http.StatusConflict, http.StatusConflict,
+			[2]string{"shelf", "trash"}, [2]string{"shelf", "trash"}, false},
+		{trash.Cascade, http.StatusOK, http.StatusOK,
+			[2]string{"trash", "trash"}, [2]string{"purged", "purged"}, false},
+		{trash.Orphan, http.StatusOK, http.StatusOK,
+			[2]string{"shelf", "trash"}, [2]string{"shelf", "trash"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			db, authors, trashed := newShelf(t, tt.policy)
+
+			rec := serve(t, authors.DeleteAuthor, http.MethodDelete, "/authors/{id}", "/authors/1", "",
+				http.Header{"If-Match": {etag(1)}})
+			if rec.Code != tt.deleted {
+				t.Fatalf("delete: status %d, want %d: %s", rec.Code, tt.deleted, rec.Body)
+			}
+			for i, want := range tt.afterDelete {
+				state, author := bookState(t, db, uint(i+1))
+				if state != want {
+					t.Errorf("book %d after the delete is %s, want %s", i+1, state, want)
+				}
+				// only the books on the shelf are orphaned by a delete
+				if orphaned := author == nil; orphaned != (tt.orphaned && i == 0) {
+					t.Errorf("book %d after the delete has author %v", i+1, author)
+				}
+			}
+
+			rec = serve(t, trashed.PurgeAuthor, http.MethodDelete, "/authors/{id}", "/authors/1?purge=true", "", nil)
+			if rec.Code != tt.purged {
+				t.Fatalf("purge: status %d, want %d: %s", rec.Code, tt.purged, rec.Body)
+			}
+			for i, want := range tt.afterPurge {
+				state, author := bookState(t, db, uint(i+1))
+				if state != want {
+					t.Errorf("book %d after the purge is %s, want %s", i+1, state, want)
+				}
+				// no book points at a purged author
+				if tt.purged == http.StatusOK && author != nil {
+					t.Errorf("book %d still has the purged author %d", i+1, *author)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreBook(t *testing.T) {
+	db, _, trashed := newShelf(t, trash.Orphan)
+
+	rec := serve(t, trashed.RestoreBook, http.MethodPost, "/books/{id}/restore", "/books/1/restore", "", nil)
+	if rec.Code == http.StatusOK {
+		t.Errorf("restoring a book on the shelf: status %d", rec.Code)
+	}
+
+	// the deleted book of a purged author comes back without an author
+	rec = serve(t, trashed.PurgeAuthor, http.MethodDelete, "/authors/{id}", "/authors/1?purge=true", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("purge: status %d: %s", rec.Code, rec.Body)
+	}
+	var book models.Book
+	rec = serve(t, trashed.RestoreBook, http.MethodPost, "/books/{id}/restore", "/books/2/restore", "", nil)
+	decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &book)
+	if book.AuthorID != nil || book.DeletedAt.Valid {
+		t.Errorf("restored book %+v, want it on the shelf without an author", book)
+	}
+	// orphaning and restoring both changed the book
+	if book.Version != 3 || rec.Header().Get("ETag") != etag(3) {
+		t.Errorf("restored book has version %d and ETag %s, want 3", book.Version, rec.Header().Get("ETag"))
+	}
+	if state, _ := bookState(t, db, 2); state != "shelf" {
+		t.Errorf("restored book is %s", state)
+	}
+}
+
+func TestRestoreAuthorWithBooks(t *testing.T) {
+	db, authors, trashed := newShelf(t, trash.Cascade)
+	rec := serve(t, authors.DeleteAuthor, http.MethodDelete, "/authors/{id}", "/authors/1", "",
+		http.Header{"If-Match": {etag(1)}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, trashed.RestoreAuthor, http.MethodPost, "/authors/{id}/restore", "/authors/1/restore?books=maybe", "", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("?books=maybe: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = serve(t, trashed.RestoreAuthor, http.MethodPost, "/authors/{id}/restore", "/authors/1/restore?books=true", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", rec.Code, rec.Body)
+	}
+	// the book deleted before its author comes back as well
+	for id := uint(1); id <= 2; id++ {
+		if state, author := bookState(t, db, id); state != "shelf" || author == nil || *author != 1 {
+			t.Errorf("book %d is %s with author %v, want it on the shelf", id, state, author)
+		}
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	db, _, _ := newShelf(t, trash.Block)
+	gone := models.Author{Name: "James Tiptree Jr."}
+	if err := db.Create(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&gone).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the author of the books is deleted, but one book is still on the shelf
+	if err := db.Exec("UPDATE authors SET deleted_at = ? WHERE id = 1", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	purger := trash.New(db, trash.Block)
+	books, authors, err := purger.PurgeExpired(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil || books != 0 || authors != 0 {
+		t.Errorf("purging what was deleted an hour ago: %d books, %d authors, %v", books, authors, err)
+	}
+
+	books, authors, err = purger.PurgeExpired(context.Background(), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if books != 1 || authors != 1 {
+		t.Errorf("purged %d books and %d authors, want the deleted book and the author without books", books, authors)
+	}
+	if state, _ := bookState(t, db, 1); state != "shelf" {
+		t.Errorf("the book on the shelf is %s", state)
+	}
+	var left int64
+	if err := db.Unscoped().Model(&models.Author{}).Where("id = 1").Count(&left).Error; err != nil || left != 1 {
+		t.Errorf("the author of the book on the shelf was purged")
+	}
+}
@@ -0,0 +1,187 @@
+package repos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/webhooks"
+	"gorm.io/gorm"
+)
+
+// WebhookRepository registers webhooks and shows their deliveries.
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+// Migration creates the outbox together with the webhook tables
+func (wh *WebhookRepository) Migration() error {
+	return wh.db.AutoMigrate(&events.Event{}, &webhooks.Webhook{}, &webhooks.Delivery{})
+}
+
+// RegisteredWebhook is sent once when a webhook is registered, it is the
+// only response carrying the secret
+type RegisteredWebhook struct {
+	webhooks.Webhook
+	Secret string `json:"secret"`
+}
+
+// GetWebhooks lists the registered webhooks
+func (wh *WebhookRepository) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks := []webhooks.Webhook{}
+	if result := wh.db.WithContext(r.Context()).Order("id").Find(&hooks); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// AddWebhook registers a webhook for the url and event types in the body,
+// a secret is generated unless one is sent
+func (wh *WebhookRepository) AddWebhook(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URL    string              `json:"url"`
+		Events webhooks.EventTypes `json:"events"`
+		Secret string              `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err))
+		return
+	}
+	defer r.Body.Close()
+
+	hook := webhooks.Webhook{URL: body.URL, Events: body.Events, Secret: body.Secret}
+	if err := hook.Validate(); err != nil {
+		writeError(w, r, http_errors.NewRestError(http.StatusBadRequest, fmt.Sprintf("%s: %s", http_errors.BadRequest, err), err))
+		return
+	}
+	if hook.Secret == "" {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		hook.Secret = secret
+	}
+
+	if result := wh.db.WithContext(r.Context()).Create(&hook); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, RegisteredWebhook{Webhook: hook, Secret: hook.Secret})
+}
+
+// DeleteWebhook removes the given webhook together with its deliveries
+func (wh *WebhookRepository) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = wh.db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var hook webhooks.Webhook
+		if err := tx.First(&hook, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("webhook_id = ?", id).Delete(&webhooks.Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&hook).Error
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Deleted")
+}
+
+// GetDeliveries lists the deliveries with their events, most recent first.
+// ?status=dead shows the dead letters, ?webhook=<id> the deliveries of a
+// single webhook. They are paged like the audit trail.
+func (wh *WebhookRepository) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	db := wh.db.WithContext(r.Context()).Model(&webhooks.Delivery{})
+	if status := query.Get("status"); status != "" {
+		if _, err := webhooks.ParseStatus(status); err != nil {
+			writeError(w, r, badQuery("status", err.Error()))
+			return
+		}
+		db = db.Where("status = ?", status)
+	}
+	if webhook := query.Get("webhook"); webhook != "" {
+		id, err := strconv.Atoi(webhook)
+		if err != nil || id <= 0 {
+			writeError(w, r, badQuery("webhook", "must be a positive integer"))
+			return
+		}
+		db = db.Where("webhook_id = ?", id)
+	}
+	page, perPage, err := paging(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// the count and the page are two statements on the same conditions
+	db = db.Session(&gorm.Session{})
+
+	var total int64
+	if result := db.Count(&total); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	deliveries := []webhooks.Delivery{}
+	if result := db.Preload("Event").Preload("Webhook").
+		Order("id DESC").Offset((page - 1) * perPage).Limit(perPage).
+		Find(&deliveries); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// RetryDelivery sends the given delivery again right away, dead letters get
+// all their attempts back
+func (wh *WebhookRepository) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var delivery webhooks.Delivery
+	err = wh.db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&delivery, id).Error; err != nil {
+			return err
+		}
+		if delivery.Status == webhooks.Delivered {
+			return http_errors.NewRestError(http.StatusConflict, "Delivery was already delivered", delivery.ID)
+		}
+		return tx.Model(&delivery).Updates(map[string]interface{}{
+			"status":          webhooks.Pending,
+			"attempts":        0,
+			"next_attempt_at": time.Now().UTC(),
+		}).Error
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
@@ -0,0 +1,189 @@
+package repos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"gorm.io/gorm"
+)
+
+// WorkPreloads are the associations a work can be requested with
+var WorkPreloads = []string{"Author", "Series", "Editions", "Editions.Publisher"}
+
+type WorkRepository struct {
+	db *gorm.DB
+}
+
+func NewWorkRepository(db *gorm.DB) *WorkRepository {
+	return &WorkRepository{db: db}
+}
+
+func (wk *WorkRepository) Migration() error {
+	return wk.db.AutoMigrate(&models.Work{})
+}
+
+// GetAllWorks lists all available works
+func (wk *WorkRepository) GetAllWorks(w http.ResponseWriter, r *http.Request) {
+	db, err := preload(replica(wk.db), r, WorkPreloads...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var works []models.Work
+	if result := db.Find(&works); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, works)
+}
+
+// GetWorkByID returns work information according to given id
+func (wk *WorkRepository) GetWorkByID(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	db, err := preload(wk.db, r, WorkPreloads...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var work models.Work
+	if result := db.First(&work, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, work)
+}
+
+// AddWork creates a new work
+func (wk *WorkRepository) AddWork(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var work models.Work
+	if err := json.Unmarshal(body, &work); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if result := wk.db.WithContext(r.Context()).Create(&work); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, work)
+}
+
+// UpdateWork updates the given work
+func (wk *WorkRepository) UpdateWork(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var work models.Work
+	if result := wk.db.WithContext(r.Context()).First(&work, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	// Apply the request body on top of the stored work
+	if err := json.Unmarshal(body, &work); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	work.ID = id
+
+	if result := wk.db.WithContext(r.Context()).Omit("Author", "Series", "Editions").Save(&work); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, work)
+}
+
+// DeleteWork deletes given work according to given id
+func (wk *WorkRepository) DeleteWork(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var work models.Work
+	if result := wk.db.WithContext(r.Context()).First(&work, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	if result := wk.db.WithContext(r.Context()).Delete(&work); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, "Deleted")
+}
+
+// GetWorkEditions returns every edition of the given work
+func (wk *WorkRepository) GetWorkEditions(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var work models.Work
+	if result := wk.db.WithContext(r.Context()).First(&work, id); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	db, err := preload(wk.db, r, "Publisher")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var editions []models.Book
+	if result := db.Where("work_id = ?", id).Order("edition, published_year").Find(&editions); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, editions)
+}
+
+// FindWorkByName returns works found according to given search query
+func (wk *WorkRepository) FindWorkByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var works []models.Work
+	if result := replica(wk.db).WithContext(r.Context()).Where("title ILIKE ? ", "%"+vars["name"]+"%").Find(&works); result.Error != nil {
+		writeError(w, r, result.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, works)
+}
@@ -0,0 +1,97 @@
+package repos
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+)
+
+// decode unmarshals the body of a response, which must have status.
+func decode(t *testing.T, body []byte, code, status int, v interface{}) {
+	t.Helper()
+	if code != status {
+		t.Fatalf("status %d, want %d: %s", code, status, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkEditions(t *testing.T) {
+	db := newTestDB(t)
+	works := NewWorkRepository(db)
+	series := NewSeriesRepository(db)
+	author := models.Author{Name: "Frank Herbert"}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	publishers := []models.Publisher{{Name: "Chilton Books"}, {Name: "Ace"}}
+	if err := db.Create(&publishers).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Series{Name: "Dune Chronicles"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// the works are added out of order, the last one has no volume
+	for _, body := range []string{
+		`{"Title":"Dune Messiah","AuthorID":1,"SeriesID":1,"SeriesVolume":2}`,
+		`{"Title":"Dune","AuthorID":1,"SeriesID":1,"SeriesVolume":1}`,
+		`{"Title":"The Road to Dune","AuthorID":1,"SeriesID":1}`,
+	} {
+		var work models.Work
+		rec := serve(t, works.AddWork, http.MethodPost, "/works/", "/works/", body, nil)
+		decode(t, rec.Body.Bytes(), rec.Code, http.StatusCreated, &work)
+	}
+
+	// the hardcover and paperback of Dune are editions of the same work
+	dune := uint(2)
+	for _, edition := range []models.Book{
+		{Title: "Dune", Page: 535, Format: "paperback", Edition: 2, PublishedYear: 1990, PublisherID: &publishers[1].ID},
+		{Title: "Dune", Page: 412, Format: "hardcover", Edition: 1, PublishedYear: 1965, PublisherID: &publishers[0].ID},
+	} {
+		edition.AuthorID, edition.WorkID, edition.Price = &author.ID, &dune, "9.99"
+		if err := db.Create(&edition).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var editions []models.Book
+	rec := serve(t, works.GetWorkEditions, http.MethodGet, "/works/{id}/editions", "/works/2/editions?preload=Publisher", "", nil)
+	decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &editions)
+	if len(editions) != 2 || editions[0].Format != "hardcover" || editions[1].Format != "paperback" {
+		t.Fatalf("editions %+v, want the hardcover first", editions)
+	}
+	if editions[0].Publisher == nil || editions[0].Publisher.Name != "Chilton Books" {
+		t.Errorf("publisher of the first edition %+v, want Chilton Books", editions[0].Publisher)
+	}
+
+	var work models.Work
+	rec = serve(t, works.GetWorkByID, http.MethodGet, "/works/{id}", "/works/2?preload=editions,series", "", nil)
+	decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &work)
+	if len(work.Editions) != 2 || work.Series == nil || work.Series.Name != "Dune Chronicles" || work.Author != nil {
+		t.Errorf("work %+v, want its editions and series only", work)
+	}
+
+	var chronicles models.Series
+	rec = serve(t, series.GetSeriesByID, http.MethodGet, "/series/{id}", "/series/1?preload=Works", "", nil)
+	decode(t, rec.Body.Bytes(), rec.Code, http.StatusOK, &chronicles)
+	var titles []string
+	for _, work := range chronicles.Works {
+		titles = append(titles, work.Title)
+	}
+	if want := []string{"Dune", "Dune Messiah", "The Road to Dune"}; len(titles) != 3 || titles[0] != want[0] || titles[1] != want[1] || titles[2] != want[2] {
+		t.Errorf("works %q, want them by volume %q", titles, want)
+	}
+
+	rec = serve(t, works.GetWorkByID, http.MethodGet, "/works/{id}", "/works/2?preload=Publisher", "", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown preload: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = serve(t, works.GetWorkEditions, http.MethodGet, "/works/{id}/editions", "/works/9/editions", "", nil)
+	if rec.Code == http.StatusOK {
+		t.Errorf("editions of a missing work: status %d", rec.Code)
+	}
+}
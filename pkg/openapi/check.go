@@ -0,0 +1,67 @@
+package openapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Check compares d with the routes the api serves. It reports every route
+// without operation, every operation without route, operations added by
+// AddRoutes which were never documented, path parameters which are not
+// described and operation ids used twice.
+func (d *Document) Check(routes []Route) []string {
+	var problems []string
+	served := make(map[Route]bool, len(routes))
+	for _, route := range routes {
+		served[route] = true
+		if _, ok := d.Operation(route.Method, route.Path); !ok {
+			problems = append(problems, fmt.Sprintf("%s is not described", route))
+		}
+	}
+
+	ids := make(map[string]Route)
+	for _, route := range d.Operations() {
+		if !served[route] {
+			problems = append(problems, fmt.Sprintf("%s is described but not served", route))
+		}
+		op, _ := d.Operation(route.Method, route.Path)
+		if op.Summary == "" {
+			problems = append(problems, fmt.Sprintf("%s is not documented", route))
+		}
+		if op.OperationID == "" {
+			problems = append(problems, fmt.Sprintf("%s has no operation id", route))
+		} else if other, ok := ids[op.OperationID]; ok {
+			problems = append(problems, fmt.Sprintf("%s uses the operation id %s of %s", route, op.OperationID, other))
+		} else {
+			ids[op.OperationID] = route
+		}
+		for _, name := range PathParams(route.Path) {
+			if p, ok := op.Parameter("path", name); !ok || !p.Required {
+				problems = append(problems, fmt.Sprintf("%s does not describe the path parameter %s", route, name))
+			}
+		}
+		if len(op.Responses) == 0 {
+			problems = append(problems, fmt.Sprintf("%s has no responses", route))
+		}
+	}
+	return problems
+}
+
+// PathParams lists the names of the parameters of a path template.
+func PathParams(path string) []string {
+	var names []string
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// Parameter returns the parameter of op named name which is sent in in.
+func (op *Operation) Parameter(in, name string) (Parameter, bool) {
+	for _, p := range op.Parameters {
+		if p.In == in && strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Parameter{}, false
+}
@@ -0,0 +1,21 @@
+package openapi
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+// Docs serves a page browsing the document served at specURL, requests can
+// be tried out from it. The page needs no assets from other hosts.
+func Docs(title, specURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		docsTemplate.Execute(w, struct{ Title, SpecURL string }{title, specURL})
+	})
+}
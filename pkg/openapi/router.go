@@ -0,0 +1,106 @@
+package openapi
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// pathParam matches the parameters of path templates, with the pattern of
+// router variables like {id:[0-9]+} in the second group.
+var pathParam = regexp.MustCompile(`\{([^{}:]+)(:[^{}]*)?\}`)
+
+// numericPatterns are the variable patterns of routes which only match
+// unsigned integers.
+var numericPatterns = map[string]bool{":[0-9]+": true, `:\d+`: true}
+
+// Routes lists the method and path template of every route of router.
+// Routes for the same method and path which differ in other matchers, e.g.
+// a query parameter, are listed once; variable patterns are left out.
+func Routes(router *mux.Router) ([]Route, error) {
+	var routes []Route
+	err := walkRoutes(router, func(route Route, _ string) {
+		routes = append(routes, route)
+	})
+	sortRoutes(routes)
+	return routes, err
+}
+
+// AddRoutes adds an operation for every route of router which has none
+// yet, so the document covers every route the api serves. The operations
+// get an id from their method and path, the first path segment as tag and
+// their path parameters, integers when the route's pattern only matches
+// digits. Their summary, body and responses are left for the caller to
+// document, Check reports the operations without summary.
+func (d *Document) AddRoutes(router *mux.Router) error {
+	return walkRoutes(router, func(route Route, template string) {
+		if _, ok := d.Operation(route.Method, route.Path); ok {
+			return
+		}
+		op := &Operation{OperationID: operationID(route)}
+		if segment := strings.Split(strings.Trim(route.Path, "/"), "/")[0]; segment != "" && !strings.Contains(segment, "{") {
+			op.Tags = []string{segment}
+		}
+		for _, match := range pathParam.FindAllStringSubmatch(template, -1) {
+			schema := String()
+			if numericPatterns[match[2]] {
+				schema = Integer().Min(0)
+			}
+			op.Parameters = append(op.Parameters, Parameter{Name: match[1], In: "path", Required: true, Schema: schema})
+		}
+		d.Add(route.Method, route.Path, op)
+	})
+}
+
+// walkRoutes calls fn with every method and path of router once, template
+// is the path with its variable patterns.
+func walkRoutes(router *mux.Router, fn func(route Route, template string)) error {
+	seen := make(map[Route]bool)
+	return router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// subrouter prefixes match every method
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := pathParam.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			r := Route{Method: strings.ToUpper(method), Path: path}
+			if !seen[r] {
+				seen[r] = true
+				fn(r, template)
+			}
+		}
+		return nil
+	})
+}
+
+// operationID derives an id from the method and path of route, e.g.
+// getBooksIdWithauthors for GET /books/{id}/withauthors.
+func operationID(route Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+	for _, word := range strings.FieldsFunc(route.Path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		b.WriteString(upperFirst(word))
+	}
+	return b.String()
+}
+
+// SetParameter adds p to op, replacing the parameter of the same name sent
+// in the same place.
+func (op *Operation) SetParameter(p Parameter) {
+	for i, existing := range op.Parameters {
+		if existing.In == p.In && strings.EqualFold(existing.Name, p.Name) {
+			op.Parameters[i] = p
+			return
+		}
+	}
+	op.Parameters = append(op.Parameters, p)
+}
@@ -0,0 +1,313 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is the JSON Schema subset the document uses.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+}
+
+// Types are the json types a value may have, a single type is written as a
+// string.
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Has reports whether t allows values of the json type name.
+func (t Types) Has(name string) bool {
+	for _, allowed := range t {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+func String() *Schema  { return &Schema{Type: Types{"string"}} }
+func Integer() *Schema { return &Schema{Type: Types{"integer"}} }
+func Boolean() *Schema { return &Schema{Type: Types{"boolean"}} }
+
+// Any accepts every json value.
+func Any() *Schema { return &Schema{} }
+
+// ID is a positive integer id.
+func ID() *Schema {
+	return Integer().Min(1)
+}
+
+// DateTime is an RFC 3339 time.
+func DateTime() *Schema {
+	return &Schema{Type: Types{"string"}, Format: "date-time"}
+}
+
+func ArrayOf(items *Schema) *Schema {
+	return &Schema{Type: Types{"array"}, Items: items}
+}
+
+// Object is an object with the given properties, required lists the
+// properties which must be present.
+func Object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: Types{"object"}, Properties: properties, Required: required}
+}
+
+// Enum is a string which must be one of values.
+func Enum[T ~string](values ...T) *Schema {
+	s := String()
+	for _, value := range values {
+		s.Enum = append(s.Enum, string(value))
+	}
+	return s
+}
+
+// Min sets the minimum of a number.
+func (s *Schema) Min(min float64) *Schema {
+	s.Minimum = &min
+	return s
+}
+
+// Max sets the maximum of a number.
+func (s *Schema) Max(max float64) *Schema {
+	s.Maximum = &max
+	return s
+}
+
+// Describe sets the description of the schema.
+func (s *Schema) Describe(description string) *Schema {
+	s.Description = description
+	return s
+}
+
+// OrNull allows null besides the values of s.
+func (s *Schema) OrNull() *Schema {
+	if s.Ref != "" || len(s.Type) == 0 {
+		if len(s.Type) == 0 && s.Ref == "" && len(s.AnyOf) == 0 {
+			// anything already includes null
+			return s
+		}
+		return &Schema{AnyOf: []*Schema{s, {Type: Types{"null"}}}}
+	}
+	if s.Type.Has("null") {
+		return s
+	}
+	nullable := *s
+	nullable.Type = append(append(Types{}, s.Type...), "null")
+	return &nullable
+}
+
+// Define makes sample's type use schema instead of the reflected one, e.g.
+// for types with their own json encoding.
+func (d *Document) Define(sample interface{}, schema *Schema) {
+	d.defined[reflect.TypeOf(sample)] = schema
+}
+
+// Schema returns the schema of the json encoding of sample's type. Named
+// structs are added to the components and referenced, fields tagged with
+// omitempty may be missing, pointers, slices and maps which are not may be
+// null. Types with their own json encoding accept any value unless they
+// were defined with Define.
+func (d *Document) Schema(sample interface{}) *Schema {
+	return d.schemaOf(reflect.TypeOf(sample))
+}
+
+// Component adds the schema of the struct sample under name, e.g. when its
+// type name says too little, and returns a reference to it.
+func (d *Document) Component(name string, sample interface{}) *Schema {
+	t := reflect.TypeOf(sample)
+	if existing, ok := d.types[t]; ok {
+		return Ref(existing)
+	}
+	return d.addComponent(name, t)
+}
+
+// Ref references the component schema name.
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Resolve returns the component schema s references, s itself when it is
+// no reference.
+func (d *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	if schema, ok := d.defined[t]; ok {
+		copied := *schema
+		return &copied
+	}
+
+	switch {
+	case t == timeType:
+		return DateTime()
+	case t == rawMessageType:
+		return Any()
+	case t.Kind() == reflect.Pointer:
+		// nil pointers are null, others are encoded like their element
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return Any()
+	case t.Kind() != reflect.String && (t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)):
+		return String()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: Types{"integer"}, Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: Types{"integer"}, Format: "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Integer().Min(0)
+	case reflect.Float32:
+		return &Schema{Type: Types{"number"}, Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: Types{"number"}, Format: "double"}
+	case reflect.String:
+		return String()
+	case reflect.Pointer:
+		return d.schemaOf(t.Elem()).OrNull()
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: Types{"string"}, Format: "byte"}
+		}
+		return ArrayOf(d.schemaOf(t.Elem())).OrNull()
+	case reflect.Array:
+		return ArrayOf(d.schemaOf(t.Elem()))
+	case reflect.Map:
+		return (&Schema{Type: Types{"object"}, AdditionalProperties: d.schemaOf(t.Elem())}).OrNull()
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return d.component(t)
+	}
+	return Any()
+}
+
+// component references the component schema of the named struct t, it is
+// added on first use.
+func (d *Document) component(t reflect.Type) *Schema {
+	if name, ok := d.types[t]; ok {
+		return Ref(name)
+	}
+
+	name := t.Name()
+	if _, taken := d.Components.Schemas[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = upperFirst(pkg) + name
+	}
+	return d.addComponent(name, t)
+}
+
+func (d *Document) addComponent(name string, t reflect.Type) *Schema {
+	// registered before the fields, so recursive types reference themselves
+	d.types[t] = name
+	d.Components.Schemas[name] = &Schema{}
+	*d.Components.Schemas[name] = *d.structSchema(t)
+	return Ref(name)
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := Object(make(map[string]*Schema))
+	d.addFields(s, t)
+	return s
+}
+
+// addFields adds the json fields of struct t to s, the fields of embedded
+// structs without json name are promoted like encoding/json does.
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				d.addFields(s, fieldType)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		omitEmpty := strings.Contains(","+options+",", ",omitempty,")
+		schema := d.schemaOf(fieldType)
+		if omitEmpty {
+			// nil values are left out instead of sent as null
+			schema = notNull(schema)
+		}
+		s.Properties[name] = schema
+	}
+}
+
+// notNull removes null from the types of s.
+func notNull(s *Schema) *Schema {
+	if len(s.AnyOf) == 2 && s.AnyOf[1].Type.Has("null") && len(s.AnyOf[1].Type) == 1 {
+		return s.AnyOf[0]
+	}
+	if !s.Type.Has("null") || len(s.Type) == 1 {
+		return s
+	}
+	copied := *s
+	copied.Type = nil
+	for _, name := range s.Type {
+		if name != "null" {
+			copied.Type = append(copied.Type, name)
+		}
+	}
+	return &copied
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
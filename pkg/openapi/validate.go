@@ -0,0 +1,206 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxProblems bounds the problems reported for a single value, a broken
+// import should not be answered with one problem per row.
+const maxProblems = 20
+
+// problem is a value which does not match its schema, pointer is the JSON
+// Pointer of the value.
+type problem struct {
+	pointer string
+	message string
+}
+
+// valueCheck checks json values decoded with UseNumber against the schemas
+// of a document.
+type valueCheck struct {
+	doc      *Document
+	problems []problem
+}
+
+func (c *valueCheck) add(pointer, format string, args ...interface{}) {
+	if len(c.problems) < maxProblems {
+		c.problems = append(c.problems, problem{pointer, fmt.Sprintf(format, args...)})
+	}
+}
+
+func (c *valueCheck) check(s *Schema, value interface{}, pointer string) {
+	s = c.doc.Resolve(s)
+	if s == nil || len(c.problems) >= maxProblems {
+		return
+	}
+
+	if len(s.AnyOf) > 0 {
+		var first []problem
+		matched := false
+		for i, alternative := range s.AnyOf {
+			sub := valueCheck{doc: c.doc}
+			sub.check(alternative, value, pointer)
+			if len(sub.problems) == 0 {
+				matched = true
+				break
+			}
+			if i == 0 {
+				first = sub.problems
+			}
+		}
+		if !matched {
+			// the first alternative is the value, the others mostly null
+			for _, p := range first {
+				c.add(p.pointer, "%s", p.message)
+			}
+			return
+		}
+	}
+
+	if len(s.Type) > 0 {
+		actual := jsonType(value)
+		if !s.Type.Has(actual) && !(actual == "integer" && s.Type.Has("number")) {
+			c.add(pointer, "must be %s", strings.Join(s.Type, " or "))
+			return
+		}
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		allowed := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			allowed[i] = fmt.Sprint(v)
+		}
+		c.add(pointer, "must be one of %s", strings.Join(allowed, ", "))
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if s.MinLength != nil && utf8.RuneCountInString(value) < *s.MinLength {
+			c.add(pointer, "must be at least %d characters long", *s.MinLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				c.add(pointer, "must be an RFC 3339 date-time")
+			}
+		}
+	case json.Number:
+		n, err := value.Float64()
+		if err != nil {
+			c.add(pointer, "must be a number")
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			c.add(pointer, "must be at least %s", formatNumber(*s.Minimum))
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			c.add(pointer, "must be at most %s", formatNumber(*s.Maximum))
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range value {
+				c.check(s.Items, item, pointer+"/"+strconv.Itoa(i))
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				c.add(pointer+"/"+escapePointer(name), "is required")
+			}
+		}
+		for name, field := range value {
+			if schema, ok := s.Properties[name]; ok {
+				c.check(schema, field, pointer+"/"+escapePointer(name))
+			} else if s.AdditionalProperties != nil {
+				c.check(s.AdditionalProperties, field, pointer+"/"+escapePointer(name))
+			}
+		}
+	}
+}
+
+// jsonType returns the json type of value, numbers without fraction are
+// integers.
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if n, err := value.Float64(); err == nil && n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range enum {
+		if other, err := json.Marshal(allowed); err == nil && bytes.Equal(encoded, other) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// escapePointer escapes a property name as JSON Pointer reference token.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// decodeJSON decodes a single json value keeping numbers as json.Number.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the json value")
+	}
+	return value, nil
+}
+
+// paramValue converts a parameter sent as text to the json value its
+// schema describes, values which cannot be converted are kept as string so
+// the check reports them.
+func (d *Document) paramValue(s *Schema, raw string) interface{} {
+	s = d.Resolve(s)
+	if s == nil {
+		return raw
+	}
+	switch {
+	case s.Type.Has("integer") || s.Type.Has("number"):
+		if raw != "" && strings.IndexByte("-0123456789", raw[0]) >= 0 && json.Valid([]byte(raw)) {
+			return json.Number(raw)
+		}
+	case s.Type.Has("boolean"):
+		// the handlers read flags with strconv.ParseBool
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
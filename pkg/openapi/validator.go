@@ -0,0 +1,316 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+// maxValidatedBody is the size up to which json bodies are checked, larger
+// ones, e.g. imports, are passed on unchecked instead of held in memory.
+const maxValidatedBody = 1 << 20
+
+// Validator checks the path and query parameters, the content type and the
+// json body of every request against the operation of its route before it
+// reaches the handler. Requests which do not match are answered with 400
+// or 415 and the details of every problem. Header parameters are left to
+// the handlers, e.g. a missing If-Match is answered with 428 by them.
+//
+// With responses set the responses are checked as well. As they are
+// already on their way their problems are only logged, it is meant for
+// development. It must be registered on the router with Use.
+func (d *Document) Validator(responses bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op, ok := d.routeOperation(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if restErr := d.checkRequest(op, r); restErr != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(restErr.Status())
+				json.NewEncoder(w).Encode(http_errors.WithRequestID(restErr, middleware.RequestIDFromContext(r.Context())))
+				return
+			}
+			if !responses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if problems := d.checkResponse(op, recorder); len(problems) > 0 {
+				logging.FromContext(r.Context()).Error("response does not match the openapi document",
+					"operation", op.OperationID, "status", recorder.Status(), "problems", problems)
+			}
+		})
+	}
+}
+
+// routeOperation returns the operation of the route r matched.
+func (d *Document) routeOperation(r *http.Request) (*Operation, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil, false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return nil, false
+	}
+	return d.Operation(r.Method, pathParam.ReplaceAllString(template, "{$1}"))
+}
+
+// checkRequest returns the error r is answered with when it does not match
+// op, the body is put back for the handler.
+func (d *Document) checkRequest(op *Operation, r *http.Request) http_errors.RestErr {
+	var details []http_errors.Detail
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			details = d.checkParam(details, p, []string{vars[p.Name]})
+		case "query":
+			values, ok := query[p.Name]
+			if !ok {
+				if p.Required {
+					details = append(details, http_errors.Detail{In: p.In, Name: p.Name, Message: "is required"})
+				}
+				continue
+			}
+			details = d.checkParam(details, p, values)
+		}
+	}
+
+	if op.RequestBody != nil {
+		bodyDetails, unsupported := d.checkBody(op.RequestBody, r)
+		if unsupported != nil {
+			return http_errors.NewValidationError(http.StatusUnsupportedMediaType,
+				contentTypeError(op.RequestBody), []http_errors.Detail{*unsupported})
+		}
+		details = append(details, bodyDetails...)
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	err := http_errors.BadQueryParams
+	for _, detail := range details {
+		if detail.In != "query" {
+			err = http_errors.BadRequest
+		}
+	}
+	return http_errors.NewValidationError(http.StatusBadRequest, err.Error(), details)
+}
+
+// checkParam checks the values of the parameter p, lists are sent repeated
+// or, when p is not exploded, comma separated.
+func (d *Document) checkParam(details []http_errors.Detail, p Parameter, values []string) []http_errors.Detail {
+	schema := d.Resolve(p.Schema)
+	var value interface{}
+	if schema != nil && schema.Type.Has("array") {
+		items := []interface{}{}
+		for _, v := range values {
+			parts := []string{v}
+			if p.Explode != nil && !*p.Explode {
+				parts = strings.Split(v, ",")
+			}
+			for _, part := range parts {
+				items = append(items, d.paramValue(schema.Items, strings.TrimSpace(part)))
+			}
+		}
+		value = items
+	} else {
+		// the handlers read the first value
+		value = d.paramValue(schema, values[0])
+	}
+
+	check := valueCheck{doc: d}
+	check.check(schema, value, "")
+	for _, problem := range check.problems {
+		details = append(details, http_errors.Detail{In: p.In, Name: p.Name, Pointer: problem.pointer, Message: problem.message})
+	}
+	return details
+}
+
+// checkBody checks the body of r against body. unsupported is set when the
+// content type is not one of body's, json bodies up to maxValidatedBody are
+// checked against their schema.
+func (d *Document) checkBody(body *RequestBody, r *http.Request) (details []http_errors.Detail, unsupported *http_errors.Detail) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" && r.ContentLength == 0 {
+		if body.Required {
+			details = append(details, http_errors.Detail{In: "body", Message: "is required"})
+		}
+		return details, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	content, ok := body.Content[mediaType]
+	if !ok {
+		message := "is missing"
+		if contentType != "" {
+			message = strconv.Quote(mediaType) + " is not supported"
+		}
+		return nil, &http_errors.Detail{In: "header", Name: "Content-Type", Message: message}
+	}
+	if !isJSON(mediaType) || content.Schema == nil {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > maxValidatedBody {
+		// read errors are left to the handler, it runs into them as well
+		return nil, nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			details = append(details, http_errors.Detail{In: "body", Message: "is required"})
+		}
+		return details, nil
+	}
+
+	value, err := decodeJSON(data)
+	if err != nil {
+		return append(details, http_errors.Detail{In: "body", Message: "is not valid json: " + err.Error()}), nil
+	}
+	check := valueCheck{doc: d}
+	check.check(content.Schema, value, "")
+	for _, p := range check.problems {
+		details = append(details, http_errors.Detail{In: "body", Pointer: p.pointer, Message: p.message})
+	}
+	return details, nil
+}
+
+// contentTypeError is the message of requests sent with a content type
+// body does not accept.
+func contentTypeError(body *RequestBody) string {
+	types := make([]string, 0, len(body.Content))
+	for mediaType := range body.Content {
+		types = append(types, mediaType)
+	}
+	if len(types) == 1 && types[0] == "application/json" {
+		return http_errors.ContentType.Error()
+	}
+	sort.Strings(types)
+	return "Content type must be one of " + strings.Join(types, ", ")
+}
+
+// checkResponse lists the problems of the response recorder captured.
+func (d *Document) checkResponse(op *Operation, recorder *responseRecorder) []string {
+	status := recorder.Status()
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		if response, ok = op.Responses["default"]; !ok {
+			return []string{"status " + strconv.Itoa(status) + " is not described"}
+		}
+	}
+	if len(response.Content) == 0 || recorder.written == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(recorder.contentType)
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return []string{"content type " + strconv.Quote(recorder.contentType) + " is not described"}
+	}
+	if !recorder.capture || content.Schema == nil {
+		return nil
+	}
+
+	value, err := decodeJSON(recorder.body.Bytes())
+	if err != nil {
+		return []string{"body is not valid json: " + err.Error()}
+	}
+	check := valueCheck{doc: d}
+	check.check(content.Schema, value, "")
+	problems := make([]string, len(check.problems))
+	for i, p := range check.problems {
+		problems[i] = http_errors.Detail{In: "body", Pointer: p.pointer, Message: p.message}.String()
+	}
+	return problems
+}
+
+// isJSON reports whether mediaType is json, e.g. application/json or
+// application/merge-patch+json.
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// readCloser reads the checked part of a body before the rest and closes
+// the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseRecorder passes a response on and keeps a copy of json bodies up
+// to maxValidatedBody, streams and larger bodies are passed on only.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	contentType string
+	capture     bool
+	body        bytes.Buffer
+	written     int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.contentType = rec.Header().Get("Content-Type")
+		mediaType, _, _ := mime.ParseMediaType(rec.contentType)
+		rec.capture = isJSON(mediaType)
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.capture {
+		if rec.body.Len()+len(b) > maxValidatedBody {
+			rec.capture = false
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.written += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Status returns the status code sent, 200 when the handler wrote nothing.
+func (rec *responseRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,208 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+// testDocument describes GET and POST /books and PUT /books/{id} of a
+// router whose handlers answer 200 when the validator let the request pass.
+func testDocument() (*Document, *mux.Router) {
+	doc := New(Info{Title: "test"})
+	one := 1
+	title := String()
+	title.MinLength = &one
+	doc.Components.Schemas["Book"] = Object(map[string]*Schema{
+		"title":       title,
+		"page":        Integer().Min(0),
+		"price":       String(),
+		"publishedAt": DateTime(),
+		"format":      Enum("hardcover", "paperback"),
+		"tags":        ArrayOf(String()),
+		"publisherId": Integer().OrNull(),
+	}, "title")
+
+	explode := false
+	body := &RequestBody{Required: true, Content: map[string]MediaType{
+		"application/json":             {Schema: Ref("Book")},
+		"application/merge-patch+json": {Schema: Ref("Book")},
+	}}
+	ok := map[string]*Response{"200": {Description: "ok", Content: map[string]MediaType{"application/json": {Schema: Ref("Book")}}}}
+	doc.Add(http.MethodGet, "/books", &Operation{
+		OperationID: "listBooks",
+		Parameters: []Parameter{
+			{Name: "per_page", In: "query", Schema: Integer().Min(1).Max(500)},
+			{Name: "inStock", In: "query", Schema: Boolean()},
+			{Name: "tags", In: "query", Explode: &explode, Schema: ArrayOf(Integer().Min(1))},
+			{Name: "title", In: "query", Required: true, Schema: String()},
+		},
+		Responses: ok,
+	})
+	doc.Add(http.MethodPost, "/books", &Operation{OperationID: "createBook", RequestBody: body, Responses: ok})
+	doc.Add(http.MethodPut, "/books/{id}", &Operation{
+		OperationID: "updateBook",
+		Parameters:  []Parameter{{Name: "id", In: "path", Required: true, Schema: Integer().Min(1)}},
+		RequestBody: &RequestBody{Content: map[string]MediaType{"application/json": {Schema: Ref("Book")}}},
+		Responses:   ok,
+	})
+
+	r := mux.NewRouter()
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r.HandleFunc("/books", handler).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/books/{id}", handler).Methods(http.MethodPut)
+	r.HandleFunc("/healthz", handler).Methods(http.MethodGet)
+	r.Use(doc.Validator(false))
+	return doc, r
+}
+
+func TestValidatorRequests(t *testing.T) {
+	_, router := testDocument()
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		status      int
+		message     string
+		details     []string
+	}{
+		{"valid query", http.MethodGet, "/books?title=dune&per_page=10&inStock=1&tags=1,2", "", "", http.StatusOK, "", nil},
+		{"missing required query", http.MethodGet, "/books", "", "", http.StatusBadRequest, "Invalid query params",
+			[]string{"query title is required"}},
+		{"query out of range", http.MethodGet, "/books?title=dune&per_page=501", "", "", http.StatusBadRequest, "Invalid query params",
+			[]string{"query per_page must be at most 500"}},
+		{"query not a number", http.MethodGet, "/books?title=dune&per_page=ten", "", "", http.StatusBadRequest, "Invalid query params",
+			[]string{"query per_page must be integer"}},
+		{"query not a boolean", http.MethodGet, "/books?title=dune&inStock=maybe", "", "", http.StatusBadRequest, "Invalid query params",
+			[]string{"query inStock must be boolean"}},
+		{"comma separated list item", http.MethodGet, "/books?title=dune&tags=1,0,x", "", "", http.StatusBadRequest, "Invalid query params",
+			[]string{"query tags/1 must be at least 1", "query tags/2 must be integer"}},
+		{"undocumented route", http.MethodGet, "/healthz?anything=1", "", "", http.StatusOK, "", nil},
+
+		{"valid body", http.MethodPost, "/books", "application/json",
+			`{"title":"Dune","page":412,"publishedAt":"1965-08-01T00:00:00Z","format":"paperback","tags":["sf"],"publisherId":null}`,
+			http.StatusOK, "", nil},
+		{"valid merge patch body", http.MethodPost, "/books", "application/merge-patch+json; charset=utf-8", `{"title":"Dune"}`, http.StatusOK, "", nil},
+		{"invalid body", http.MethodPost, "/books", "application/json",
+			`{"title":"","page":-1,"publishedAt":"1965","format":"scroll","tags":[1],"publisherId":"x"}`,
+			http.StatusBadRequest, "Bad request", []string{
+				"body /format must be one of hardcover, paperback",
+				"body /page must be at least 0",
+				"body /publishedAt must be an RFC 3339 date-time",
+				"body /publisherId must be integer or null",
+				"body /tags/0 must be string",
+				"body /title must be at least 1 characters long",
+			}},
+		{"missing required field", http.MethodPost, "/books", "application/json", `{"page":1}`, http.StatusBadRequest, "Bad request",
+			[]string{"body /title is required"}},
+		{"not an object", http.MethodPost, "/books", "application/json", `["Dune"]`, http.StatusBadRequest, "Bad request",
+			[]string{"body must be object"}},
+		{"not json", http.MethodPost, "/books", "application/json", `{"title":`, http.StatusBadRequest, "Bad request",
+			[]string{"body is not valid json: unexpected EOF"}},
+		{"missing required body", http.MethodPost, "/books", "", "", http.StatusBadRequest, "Bad request",
+			[]string{"body is required"}},
+		{"unsupported content type", http.MethodPost, "/books", "text/plain", "Dune", http.StatusUnsupportedMediaType,
+			"Content type must be one of application/json, application/merge-patch+json",
+			[]string{`header Content-Type "text/plain" is not supported`}},
+
+		{"invalid path and body", http.MethodPut, "/books/0", "application/json", `{"title":"Dune","page":"many"}`, http.StatusBadRequest, "Bad request",
+			[]string{"body /page must be integer", "path id must be at least 1"}},
+		{"optional body left out", http.MethodPut, "/books/1", "", "", http.StatusOK, "", nil},
+		{"only json", http.MethodPut, "/books/1", "application/xml", "<book/>", http.StatusUnsupportedMediaType,
+			http_errors.ContentType.Error(), []string{`header Content-Type "application/xml" is not supported`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			var restErr http_errors.RestError
+			if err := json.Unmarshal(rec.Body.Bytes(), &restErr); err != nil {
+				t.Fatal(err)
+			}
+			details := make([]string, len(restErr.ErrDetails))
+			for i, detail := range restErr.ErrDetails {
+				details[i] = detail.String()
+			}
+			if !strings.HasPrefix(restErr.ErrError, tt.message+": ") {
+				t.Errorf("message %q, want %q with the details", restErr.ErrError, tt.message)
+			}
+			// object fields are checked in map order
+			sort.Strings(details)
+			if !reflect.DeepEqual(details, tt.details) {
+				t.Errorf("details %q, want %q", details, tt.details)
+			}
+		})
+	}
+}
+
+// TestValidatorKeepsBody checks that the handler reads the body the
+// validator checked.
+func TestValidatorKeepsBody(t *testing.T) {
+	doc, _ := testDocument()
+	var got string
+	r := mux.NewRouter()
+	r.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
+		var book struct{ Title string }
+		json.NewDecoder(r.Body).Decode(&book)
+		got = book.Title
+	}).Methods(http.MethodPost)
+	r.Use(doc.Validator(false))
+
+	req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(`{"title":"Dune"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "Dune" {
+		t.Errorf("handler read title %q, want Dune", got)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	doc, _ := testDocument()
+	op, _ := doc.Operation(http.MethodPost, "/books")
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		problems    []string
+	}{
+		{"valid", http.StatusOK, "application/json", `{"title":"Dune"}`, nil},
+		{"invalid body", http.StatusOK, "application/json", `{"title":"Dune","page":"many"}`, []string{"body /page must be integer"}},
+		{"undescribed status", http.StatusTeapot, "application/json", `{}`, []string{"status 418 is not described"}},
+		{"undescribed content type", http.StatusOK, "text/csv", "title\nDune\n", []string{`content type "text/csv" is not described`}},
+		{"not json", http.StatusOK, "application/json", `{"title"`, []string{"body is not valid json: unexpected EOF"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+			recorder.Header().Set("Content-Type", tt.contentType)
+			recorder.WriteHeader(tt.status)
+			recorder.Write([]byte(tt.body))
+			if problems := doc.checkResponse(op, recorder); strings.Join(problems, "; ") != strings.Join(tt.problems, "; ") {
+				t.Errorf("problems %q, want %q", problems, tt.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Package ratelimit limits the requests of every client with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests per Period. Buckets hold up to Requests
+// tokens, so a client may spend its whole quota at once and then gets a new
+// token every Period/Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses limits written as requests/period, e.g. 100/1m or 5/s.
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("limit %q is not requests/period", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("limit %q needs a positive number of requests", s)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		// 5/s reads as 5/1s
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("limit %q needs a positive period", s)
+	}
+
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// ParseRoutes parses per route limits written as [METHOD ]route=limit, e.g.
+// GET /books/withauthors=10/1m, routes are mux path templates.
+func ParseRoutes(routes []string) (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(routes))
+	for _, route := range routes {
+		name, limit, ok := strings.Cut(route, "=")
+		if !ok {
+			return nil, fmt.Errorf("route limit %q is not route=limit", route)
+		}
+
+		parsed, err := ParseLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", name, err)
+		}
+
+		name = strings.TrimSpace(name)
+		if method, path, ok := strings.Cut(name, " "); ok {
+			name = strings.ToUpper(method) + " " + strings.TrimSpace(path)
+		}
+		if !strings.Contains(name, "/") {
+			return nil, fmt.Errorf("route limit %q has no route", route)
+		}
+		limits[name] = parsed
+	}
+	return limits, nil
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Period.String()
+}
+
+// rate is the number of tokens added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the state of a bucket after a request took a token from it.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of requests the client can still send now.
+	Remaining int
+	// RetryAfter is how long a rejected client has to wait for a token.
+	RetryAfter time.Duration
+	// ResetAfter is how long it takes until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// Store keeps the buckets of the clients. The in-memory store limits each
+// instance on its own, a store shared by all instances, e.g. on redis,
+// can implement Store to enforce the limits across them.
+type Store interface {
+	// Take takes a token from the bucket of key, which allows limit, and
+	// reports whether the request is allowed.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreRefill(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	// a token per second, up to 3
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+
+	tests := []struct {
+		name       string
+		at         time.Duration
+		key        string
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+		resetAfter time.Duration
+	}{
+		{"full bucket", 0, "a", true, 2, 0, time.Second},
+		{"second token", 0, "a", true, 1, 0, 2 * time.Second},
+		{"last token", 0, "a", true, 0, 0, 3 * time.Second},
+		{"empty bucket", 0, "a", false, 0, time.Second, 3 * time.Second},
+		{"other client", 0, "b", true, 2, 0, time.Second},
+		{"half a token", 500 * time.Millisecond, "a", false, 0, 500 * time.Millisecond, 2500 * time.Millisecond},
+		{"refilled token", time.Second, "a", true, 0, 0, 3 * time.Second},
+		{"refill is capped", time.Minute, "a", true, 2, 0, time.Second},
+	}
+	for _, tt := range tests {
+		now = start.Add(tt.at)
+		result, err := store.Take(context.Background(), tt.key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Result{Allowed: tt.allowed, Remaining: tt.remaining, RetryAfter: tt.retryAfter, ResetAfter: tt.resetAfter}
+		if result != want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, result, want)
+		}
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 10, Period: 10 * time.Minute}
+
+	for _, key := range []string{"a", "b"} {
+		if _, err := store.Take(context.Background(), key, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b is still refilling, a is full again and dropped
+	now = start.Add(2 * sweepInterval)
+	for i := 0; i < 9; i++ {
+		store.Take(context.Background(), "b", limit)
+	}
+	now = now.Add(sweepInterval + time.Second)
+	store.Take(context.Background(), "c", limit)
+
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("full bucket a was kept")
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("refilling bucket b was dropped")
+	}
+}
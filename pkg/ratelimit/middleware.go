@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/logging"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+// Limiter limits the requests of every client to the default limit, routes
+// with a limit of their own have a separate bucket.
+type Limiter struct {
+	store  Store
+	limit  Limit
+	routes map[string]Limit
+	exempt map[string]bool
+	// Key identifies the client of a request, it defaults to ClientKey.
+	Key func(r *http.Request) string
+}
+
+// New returns a limiter applying limit to all routes but the ones listed in
+// routes, which are keyed by "METHOD template" or "template".
+func New(store Store, limit Limit, routes map[string]Limit) *Limiter {
+	return &Limiter{
+		store:  store,
+		limit:  limit,
+		routes: routes,
+		exempt: make(map[string]bool),
+		Key:    ClientKey,
+	}
+}
+
+// Exempt turns off limiting for the given route templates, e.g. for the
+// probes of a load balancer.
+func (l *Limiter) Exempt(templates ...string) {
+	for _, template := range templates {
+		l.exempt[template] = true
+	}
+}
+
+// ClientKey identifies clients by their principal once they authenticated
+// and by their ip address otherwise. Credentials which were not verified
+// are never used, a client could pick a new one for every request.
+func ClientKey(r *http.Request) string {
+	if principal, ok := middleware.PrincipalFromContext(r.Context()); ok {
+		return principal.String()
+	}
+	return "ip:" + middleware.ClientIP(r)
+}
+
+// Middleware rejects the requests of clients which ran out of tokens with
+// 429 Too Many Requests. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, rejected ones
+// Retry-After as well. It must be registered on the router with Use so the
+// route is known, after the client was identified.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, limit, ok := l.routeLimit(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := l.store.Take(r.Context(), l.Key(r)+"|"+scope, limit)
+		if err != nil {
+			// a broken shared store must not take the api down with it
+			logging.FromContext(r.Context()).Warn("rate limit store failed", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter.Seconds())))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Period.Seconds())))
+
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter.Seconds())
+			header.Set("Retry-After", strconv.Itoa(retryAfter))
+
+			restErr := http_errors.NewTooManyRequestsError(fmt.Sprintf("rate limit of %s exceeded, retry in %ds", limit, retryAfter))
+			header.Set("Content-Type", "application/json")
+			w.WriteHeader(restErr.Status())
+			json.NewEncoder(w).Encode(http_errors.WithRequestID(restErr, middleware.RequestIDFromContext(r.Context())))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeLimit returns the limit of the matched route and the scope its
+// bucket is kept under, ok is false for exempt routes.
+func (l *Limiter) routeLimit(r *http.Request) (scope string, limit Limit, ok bool) {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			if l.exempt[template] {
+				return "", Limit{}, false
+			}
+			if limit, ok := l.routes[r.Method+" "+template]; ok {
+				return r.Method + " " + template, limit, true
+			}
+			if limit, ok := l.routes[template]; ok {
+				return template, limit, true
+			}
+		}
+	}
+	return "default", l.limit, true
+}
+
+func ceilSeconds(s float64) int {
+	return int(math.Ceil(s))
+}
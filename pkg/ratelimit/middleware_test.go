@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+)
+
+func TestMiddleware(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limiter := New(store, Limit{Requests: 2, Period: time.Minute}, map[string]Limit{
+		"GET /books/{id}": {Requests: 1, Period: time.Minute},
+	})
+	limiter.Exempt("/healthz")
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r := mux.NewRouter()
+	r.HandleFunc("/books", ok).Methods(http.MethodGet)
+	r.HandleFunc("/books/{id}", ok).Methods(http.MethodGet, http.MethodDelete)
+	r.HandleFunc("/healthz", ok).Methods(http.MethodGet)
+	r.Use(limiter.Middleware)
+
+	tests := []struct {
+		name       string
+		at         time.Duration
+		method     string
+		target     string
+		client     string
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{"first request", 0, http.MethodGet, "/books", "192.0.2.1", http.StatusOK, "1", "30", ""},
+		{"second request", 0, http.MethodGet, "/books", "192.0.2.1", http.StatusOK, "0", "60", ""},
+		{"quota spent", 0, http.MethodGet, "/books", "192.0.2.1", http.StatusTooManyRequests, "0", "60", "30"},
+		{"other client", 0, http.MethodGet, "/books", "192.0.2.2", http.StatusOK, "1", "30", ""},
+		{"route limit has its own bucket", 0, http.MethodGet, "/books/1", "192.0.2.1", http.StatusOK, "0", "60", ""},
+		{"route limit spent", 0, http.MethodGet, "/books/2", "192.0.2.1", http.StatusTooManyRequests, "0", "60", "60"},
+		{"other method uses the default", 0, http.MethodDelete, "/books/1", "192.0.2.1", http.StatusTooManyRequests, "0", "60", "30"},
+		{"exempt route", 0, http.MethodGet, "/healthz", "192.0.2.1", http.StatusOK, "", "", ""},
+		{"refilled", 30 * time.Second, http.MethodGet, "/books", "192.0.2.1", http.StatusOK, "0", "60", ""},
+		{"spent again", 45 * time.Second, http.MethodGet, "/books", "192.0.2.1", http.StatusTooManyRequests, "0", "45", "15"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = start.Add(tt.at)
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RemoteAddr = tt.client + ":1234"
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			header := rec.Header()
+			for name, want := range map[string]string{
+				"RateLimit-Remaining": tt.remaining,
+				"RateLimit-Reset":     tt.reset,
+				"Retry-After":         tt.retryAfter,
+			} {
+				if got := header.Get(name); got != want {
+					t.Errorf("%s %q, want %q", name, got, want)
+				}
+			}
+			if tt.status != http.StatusTooManyRequests {
+				return
+			}
+			var restErr http_errors.RestError
+			if err := json.Unmarshal(rec.Body.Bytes(), &restErr); err != nil {
+				t.Fatal(err)
+			}
+			if restErr.ErrStatus != http.StatusTooManyRequests {
+				t.Errorf("code %d, want %d", restErr.ErrStatus, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  Limit
+		err   bool
+	}{
+		{"100/1m", Limit{100, time.Minute}, false},
+		{"5/s", Limit{5, time.Second}, false},
+		{" 10/30s ", Limit{10, 30 * time.Second}, false},
+		{"10/h", Limit{10, time.Hour}, false},
+		{"10", Limit{}, true},
+		{"0/1m", Limit{}, true},
+		{"-1/1m", Limit{}, true},
+		{"ten/1m", Limit{}, true},
+		{"10/0s", Limit{}, true},
+		{"10/", Limit{}, true},
+		{"10/fortnight", Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.limit)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, %v, want %v", tt.limit, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gorilla/mux"
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/middleware"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// record installs a tracer provider keeping the ended spans in memory.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+// newTestDB returns a sqlite database with the books table, traced by
+// GormPlugin.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "tracing.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.Book{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRequestSpans(t *testing.T) {
+	spans := record(t)
+	db := newTestDB(t)
+
+	r := mux.NewRouter()
+	r.Use(Route)
+	r.HandleFunc("/books/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var book models.Book
+		db.WithContext(r.Context()).Find(&book, mux.Vars(r)["id"])
+		db.WithContext(r.Context()).Where("no_such_column = 1").Find(&book)
+	})
+	handler := middleware.RequestID(Middleware(r))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/books/7", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("%d spans ended, want the request's and two statements'", len(ended))
+	}
+	request := ended[2]
+	if request.Name() != "GET /books/{id}" {
+		t.Errorf("request span %q, want it named after the route", request.Name())
+	}
+	if got := request.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("trace id %s, want the caller's %s", got, traceID)
+	}
+	for _, statement := range ended[:2] {
+		if statement.Name() != "gorm.query books" {
+			t.Errorf("statement span %q, want gorm.query books", statement.Name())
+		}
+		if statement.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("statement span %q is not a child of the request's", statement.Name())
+		}
+	}
+	if ended[0].Status().Code == codes.Error {
+		t.Errorf("a found record was traced as an error")
+	}
+	if ended[1].Status().Code != codes.Error {
+		t.Errorf("a failed statement was traced with status %v", ended[1].Status())
+	}
+}
+
+func TestUntracedStatements(t *testing.T) {
+	spans := record(t)
+	db := newTestDB(t)
+
+	// migrations and background jobs run without a span
+	if err := db.WithContext(context.Background()).Find(&[]models.Book{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ended := spans.Ended(); len(ended) != 0 {
+		t.Errorf("%d spans started for a statement outside of a trace", len(ended))
+	}
+}
+
+func TestSetupExporters(t *testing.T) {
+	previous, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		valid bool
+	}{
+		{"none", Config{}, true},
+		{"file", Config{Exporter: "file", File: filepath.Join(t.TempDir(), "spans.json")}, true},
+		{"file without a file", Config{Exporter: "file"}, false},
+		{"unknown", Config{Exporter: "jaeger"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), tt.cfg)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Setup(%+v) error %v, want valid %v", tt.cfg, err, tt.valid)
+			}
+			if err == nil {
+				if err := shutdown(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
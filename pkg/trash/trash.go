@@ -0,0 +1,336 @@
+// Package trash manages soft deleted books and authors: deleting authors
+// according to a policy for their books, purging records for good and
+// purging the records deleted longer than the retention period.
+package trash
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	models "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/entities"
+	http_errors "github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/models/errors"
+	"gorm.io/gorm"
+)
+
+// Policy decides what deleting an author does to the author's books.
+type Policy string
+
+const (
+	// Block refuses to delete authors which still have books.
+	Block Policy = "block"
+	// Cascade deletes the books together with their author.
+	Cascade Policy = "cascade"
+	// Orphan keeps the books without an author, their AuthorID is cleared.
+	Orphan Policy = "orphan"
+)
+
+// Policies lists every valid policy.
+var Policies = []Policy{Block, Cascade, Orphan}
+
+// ErrAuthorHasBooks is returned by the block policy, it is sent as 409
+// Conflict.
+var ErrAuthorHasBooks = http_errors.NewRestError(http.StatusConflict,
+	"Author still has books", "delete or reassign the books first")
+
+// ErrAuthorChanged is returned when the author was changed after it was
+// read.
+var ErrAuthorChanged = http_errors.NewPreconditionFailedError("the author was changed concurrently")
+
+// Trash deletes, restores and purges books and authors.
+type Trash struct {
+	db     *gorm.DB
+	policy Policy
+}
+
+func New(db *gorm.DB, policy Policy) *Trash {
+	return &Trash{db: db, policy: policy}
+}
+
+// Policy is the policy authors are deleted with.
+func (t *Trash) Policy() Policy {
+	return t.policy
+}
+
+// DeleteAuthor soft deletes the author if it still has the version it was
+// read with and handles its books according to the policy.
+func (t *Trash) DeleteAuthor(ctx context.Context, author *models.Author) error {
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := t.releaseBooks(tx, author.ID, false); err != nil {
+			return err
+		}
+		result := tx.Where("version = ?", author.Version).Delete(author)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// rolls the changes of the books back as well
+			return ErrAuthorChanged
+		}
+		return events.Publish(tx, events.AuthorDeleted, author.ID, author)
+	})
+}
+
+// RestoreAuthor brings back a deleted author, with books also the author's
+// deleted books.
+func (t *Trash) RestoreAuthor(ctx context.Context, id uint, books bool) (models.Author, error) {
+	var author models.Author
+	err := t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := restore(tx, &author, id); err != nil {
+			return err
+		}
+		if err := events.Publish(tx, events.AuthorRestored, author.ID, author); err != nil {
+			return err
+		}
+		if !books {
+			return nil
+		}
+
+		var deleted []uint
+		if err := tx.Unscoped().Model(&models.Book{}).
+			Where("author_id = ? AND deleted_at IS NOT NULL", id).Pluck("id", &deleted).Error; err != nil {
+			return err
+		}
+		for _, bookID := range deleted {
+			var book models.Book
+			if err := restore(tx, &book, bookID); err != nil {
+				return err
+			}
+			if err := events.Publish(tx, events.BookRestored, book.ID, book); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return author, err
+}
+
+// RestoreBook brings back a deleted book with the author it was deleted
+// with, an author which is still deleted is not restored along. Purging an
+// author leaves no book pointing at it: with the orphan policy its deleted
+// books lost their AuthorID and are restored without an author, with
+// cascade they were purged as well and cannot be restored.
+func (t *Trash) RestoreBook(ctx context.Context, id uint) (models.Book, error) {
+	var book models.Book
+	err := t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := restore(tx, &book, id); err != nil {
+			return err
+		}
+		return events.Publish(tx, events.BookRestored, book.ID, book)
+	})
+	return book, err
+}
+
+// restore clears the deletion of the record of the given id and reads it
+// into dest, records which are not deleted are not found.
+func restore(tx *gorm.DB, dest interface{}, id uint) error {
+	if result := tx.Unscoped().Where("deleted_at IS NOT NULL").First(dest, id); result.Error != nil {
+		return result.Error
+	}
+	if result := tx.Unscoped().Model(dest).
+		Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")}); result.Error != nil {
+		return result.Error
+	}
+	return tx.First(dest, id).Error
+}
+
+// PurgeBook deletes a book for good, deleted or not.
+func (t *Trash) PurgeBook(ctx context.Context, id uint) error {
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var book models.Book
+		if result := tx.Unscoped().First(&book, id); result.Error != nil {
+			return result.Error
+		}
+		return purgeBooks(tx, tx.Where("id = ?", id))
+	})
+}
+
+// PurgeAuthor deletes an author for good, deleted or not. Its books, also
+// the deleted ones, are handled according to the policy, with cascade they
+// are purged as well.
+func (t *Trash) PurgeAuthor(ctx context.Context, id uint) error {
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var author models.Author
+		if result := tx.Unscoped().First(&author, id); result.Error != nil {
+			return result.Error
+		}
+		if err := t.releaseBooks(tx, id, true); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&author).Error; err != nil {
+			return err
+		}
+		return events.Publish(tx, events.AuthorPurged, author.ID, author)
+	})
+}
+
+// releaseBooks applies the policy to the books of the author, purge handles
+// the deleted books as well and purges instead of deleting them.
+func (t *Trash) releaseBooks(tx *gorm.DB, authorID uint, purge bool) error {
+	books := tx.Model(&models.Book{})
+	if purge {
+		books = books.Unscoped()
+	}
+	// the books are looked up before they are changed, both on the same
+	// conditions
+	books = books.Where("author_id = ?", authorID).Session(&gorm.Session{})
+
+	switch t.policy {
+	case Cascade:
+		if purge {
+			return purgeBooks(tx, tx.Where("author_id = ?", authorID))
+		}
+		var deleted []models.Book
+		if err := books.Find(&deleted).Error; err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+		if err := books.Delete(&models.Book{}).Error; err != nil {
+			return err
+		}
+		for _, book := range deleted {
+			if err := events.Publish(tx, events.BookDeleted, book.ID, book); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Orphan:
+		var orphaned []models.Book
+		if err := books.Find(&orphaned).Error; err != nil {
+			return err
+		}
+		if len(orphaned) == 0 {
+			return nil
+		}
+		if err := books.Updates(map[string]interface{}{"author_id": nil, "version": gorm.Expr("version + 1")}).Error; err != nil {
+			return err
+		}
+		for _, book := range orphaned {
+			book.AuthorID = nil
+			book.Version++
+			if err := events.Publish(tx, events.BookUpdated, book.ID, book); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		var count int64
+		if err := books.Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAuthorHasBooks
+		}
+		return nil
+	}
+}
+
+// purgeBooks deletes the books matching where for good, together with
+// their category and tag links.
+func purgeBooks(tx *gorm.DB, where *gorm.DB) error {
+	var purged []models.Book
+	if err := tx.Unscoped().Where(where).Find(&purged).Error; err != nil {
+		return err
+	}
+	if len(purged) == 0 {
+		return nil
+	}
+
+	ids := tx.Unscoped().Model(&models.Book{}).Select("id").Where(where)
+	if err := tx.Exec("DELETE FROM book_categories WHERE book_id IN (?)", ids).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM book_tags WHERE book_id IN (?)", ids).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where(where).Delete(&models.Book{}).Error; err != nil {
+		return err
+	}
+	for _, book := range purged {
+		if err := events.Publish(tx, events.BookPurged, book.ID, book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeExpired purges the books and authors deleted before the given time.
+// Books go first, so authors whose books were deleted with them are purged
+// as well.
+func (t *Trash) PurgeExpired(ctx context.Context, before time.Time) (books, authors int64, err error) {
+	err = t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Where("deleted_at IS NOT NULL AND deleted_at < ?", before)
+		if err := tx.Unscoped().Model(&models.Book{}).Where(expired).Count(&books).Error; err != nil {
+			return err
+		}
+		if err := purgeBooks(tx, expired); err != nil {
+			return err
+		}
+
+		var ids []uint
+		if err := tx.Unscoped().Model(&models.Author{}).Where(expired).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			var remaining int64
+			if err := tx.Unscoped().Model(&models.Book{}).Where("author_id = ?", id).Count(&remaining).Error; err != nil {
+				return err
+			}
+			// authors are kept while they have books, the scheduled purge
+			// never touches books which were not deleted
+			if remaining > 0 {
+				continue
+			}
+			var author models.Author
+			if err := tx.Unscoped().First(&author, id).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&author).Error; err != nil {
+				return err
+			}
+			if err := events.Publish(tx, events.AuthorPurged, author.ID, author); err != nil {
+				return err
+			}
+			authors++
+		}
+		return nil
+	})
+	return books, authors, err
+}
+
+// Run purges the records deleted longer than retention every interval
+// until ctx is done.
+func (t *Trash) Run(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		books, authors, err := t.PurgeExpired(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			slog.Error("trash cannot be purged", "error", err)
+		case books > 0 || authors > 0:
+			slog.Info("trash purged", "books", books, "authors", authors)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ParsePolicy checks the name of a policy.
+func ParsePolicy(name string) (Policy, error) {
+	for _, policy := range Policies {
+		if Policy(name) == policy {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown policy %q, use block, cascade or orphan", name)
+}
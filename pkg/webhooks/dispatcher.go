@@ -0,0 +1,275 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+// leaseMargin is added to the request timeout while a delivery is being
+// sent, other dispatchers pick it up again only when this one died.
+const leaseMargin = 30 * time.Second
+
+// Options configure a dispatcher.
+type Options struct {
+	// Interval is how often the outbox and the due deliveries are polled.
+	Interval time.Duration
+	// Timeout bounds a single delivery request.
+	Timeout time.Duration
+	// MaxAttempts is how often a delivery is sent before it is dead.
+	MaxAttempts int
+	// RetryBase is the wait after the first failed attempt, it doubles with
+	// every further one up to RetryMax.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// BatchSize is how many events or deliveries are handled per poll.
+	BatchSize int
+}
+
+// Dispatcher hands the events of the outbox to the webhooks subscribed to
+// them and sends the due deliveries. Several instances may run against the
+// same database, on Postgres rows are claimed with SKIP LOCKED.
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+	opts   Options
+}
+
+func NewDispatcher(db *gorm.DB, opts Options) *Dispatcher {
+	return &Dispatcher{
+		db:     db.Clauses(dbresolver.Write).Session(&gorm.Session{}),
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+	}
+}
+
+// Run dispatches every interval until ctx is done. Deliveries interrupted
+// by the shutdown are sent again once their lease expired.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("webhooks cannot be dispatched", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch fans out the new events of the outbox and sends the deliveries
+// which are due.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	if err := d.fanOut(ctx); err != nil {
+		return err
+	}
+	return d.deliverDue(ctx)
+}
+
+// fanOut creates a delivery of every new event for each webhook subscribed
+// to its type.
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var pending []events.Event
+		if err := skipLocked(tx).Where("dispatched_at IS NULL").
+			Order("id").Limit(d.opts.BatchSize).Find(&pending).Error; err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		var hooks []Webhook
+		if err := tx.Find(&hooks).Error; err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		var deliveries []Delivery
+		ids := make([]uint, 0, len(pending))
+		for _, event := range pending {
+			ids = append(ids, event.ID)
+			for _, hook := range hooks {
+				if hook.Receives(event.Type) {
+					deliveries = append(deliveries, Delivery{
+						EventID:       event.ID,
+						WebhookID:     hook.ID,
+						Status:        Pending,
+						NextAttemptAt: now,
+					})
+				}
+			}
+		}
+		if len(deliveries) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&events.Event{}).Where("id IN ?", ids).Update("dispatched_at", now).Error
+	})
+}
+
+// deliverDue claims the due deliveries for the time of a request and sends
+// them concurrently.
+func (d *Dispatcher) deliverDue(ctx context.Context) error {
+	var due []Delivery
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		if err := skipLocked(tx).Where("status = ? AND next_attempt_at <= ?", Pending, now).
+			Order("next_attempt_at").Limit(d.opts.BatchSize).Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(due))
+		for i, delivery := range due {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&Delivery{}).Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(d.opts.Timeout+leaseMargin)).Error
+	})
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := range due {
+		wg.Add(1)
+		go func(delivery *Delivery) {
+			defer wg.Done()
+			if err := d.deliver(ctx, delivery); err != nil && ctx.Err() == nil {
+				slog.Error("webhook delivery cannot be recorded", "delivery", delivery.ID, "error", err)
+			}
+		}(&due[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliver sends a delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) error {
+	var event events.Event
+	var hook Webhook
+	db := d.db.WithContext(ctx)
+	if err := db.First(&event, delivery.EventID).Error; err != nil {
+		return err
+	}
+	err := db.First(&hook, delivery.WebhookID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return d.record(ctx, delivery, 0, errors.New("webhook was removed"), true)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := d.send(ctx, delivery, event, hook)
+	if ctx.Err() != nil {
+		// the shutdown interrupted the attempt, it does not count
+		return nil
+	}
+	return d.record(ctx, delivery, status, err, false)
+}
+
+// send posts the event to the webhook, any status but 2xx is a failure.
+func (d *Dispatcher) send(ctx context.Context, delivery *Delivery, event events.Event, hook Webhook) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "library-webhooks")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return resp.StatusCode, nil
+	}
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, excerpt)
+}
+
+// record stores the outcome of an attempt. Failed deliveries are retried
+// after a backoff until they failed MaxAttempts times, or right away dead
+// when dead is set.
+func (d *Dispatcher) record(ctx context.Context, delivery *Delivery, status int, err error, dead bool) error {
+	now := time.Now().UTC()
+	attempts := delivery.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts, "last_status": status}
+
+	switch {
+	case err == nil:
+		updates["status"] = Delivered
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+	case dead || attempts >= d.opts.MaxAttempts:
+		updates["status"] = Dead
+		updates["last_error"] = err.Error()
+		slog.Warn("webhook delivery is dead", "delivery", delivery.ID, "webhook", delivery.WebhookID,
+			"attempts", attempts, "error", err)
+	default:
+		updates["next_attempt_at"] = now.Add(d.backoff(attempts))
+		updates["last_error"] = err.Error()
+		slog.Info("webhook delivery failed, retrying", "delivery", delivery.ID, "webhook", delivery.WebhookID,
+			"attempts", attempts, "error", err)
+	}
+	return d.db.WithContext(ctx).Model(&Delivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+}
+
+// backoff is the wait after the given number of failed attempts, with up to
+// a fifth of jitter so deliveries failing together spread out.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.opts.RetryBase
+	for i := 1; i < attempts && wait < d.opts.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > d.opts.RetryMax {
+		wait = d.opts.RetryMax
+	}
+	if jitter := int64(wait) / 5; jitter > 0 {
+		wait += time.Duration(rand.Int63n(jitter))
+	}
+	return wait
+}
+
+// skipLocked locks the rows a dispatcher claims, rows claimed by another
+// dispatcher are skipped. Only Postgres supports it, other databases are
+// used by a single dispatcher.
+func skipLocked(tx *gorm.DB) *gorm.DB {
+	if tx.Dialector.Name() != "postgres" {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+}
@@ -0,0 +1,58 @@
+package webhooks
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// computed with openssl dgst -sha256 -hmac
+	const want = "sha256=4ddda12b312e8d3a5701330f1a86f896ffad058ff2224823945df28d297a9de4"
+	if got := Sign("whsec_test", 1700000000, []byte(`{"event":"book.created","id":1}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"event":"book.created","id":1}`)
+	signedAt := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	signature := Sign(secret, signedAt.Unix(), body)
+	tolerance := 5 * time.Minute
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		now       time.Time
+		err       string
+	}{
+		{"valid", secret, signature, timestamp, body, signedAt, ""},
+		{"within tolerance", secret, signature, timestamp, body, signedAt.Add(tolerance), ""},
+		{"clock behind", secret, signature, timestamp, body, signedAt.Add(-tolerance), ""},
+		{"too old", secret, signature, timestamp, body, signedAt.Add(tolerance + time.Second), "delivery was signed 5m1s ago"},
+		{"from the future", secret, signature, timestamp, body, signedAt.Add(-tolerance - time.Second), "delivery was signed -5m1s ago"},
+		{"invalid timestamp", secret, signature, "yesterday", body, signedAt, "invalid X-Webhook-Timestamp"},
+		{"other timestamp", secret, signature, strconv.FormatInt(signedAt.Unix()+1, 10), body, signedAt, "signature does not match"},
+		{"other secret", "whsec_other", signature, timestamp, body, signedAt, "signature does not match"},
+		{"changed body", secret, signature, timestamp, []byte(`{"event":"book.created","id":2}`), signedAt, "signature does not match"},
+		{"other algorithm", secret, "sha1=" + strings.TrimPrefix(signature, "sha256="), timestamp, body, signedAt, "signature is not sha256"},
+		{"missing signature", secret, "", timestamp, body, signedAt, "signature is not sha256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.signature, tt.timestamp, tt.body, tt.now, tolerance)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Verify = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Verify = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
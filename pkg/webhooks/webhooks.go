@@ -0,0 +1,141 @@
+// Package webhooks delivers the domain events of the outbox to the
+// registered webhooks. Every delivery is signed with the webhook's secret,
+// failed deliveries are retried with an exponential backoff and end up as
+// dead letters when they keep failing.
+package webhooks
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/horzu/golang/picus-security-bootcamp/homework-4-week-5-horzu/pkg/events"
+)
+
+// Delivery statuses.
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	// Dead deliveries failed too often, they are only sent again when they
+	// are retried by hand.
+	Dead = "dead"
+)
+
+// Statuses lists every delivery status.
+var Statuses = []string{Pending, Delivered, Dead}
+
+// Webhook is an endpoint events are posted to.
+type Webhook struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	URL       string    `json:"url" gorm:"not null"`
+	// Secret signs the deliveries, it is only sent back when the webhook
+	// is registered.
+	Secret string `json:"-" gorm:"not null"`
+	// Events lists the event types the webhook receives, all when empty.
+	Events EventTypes `json:"events" gorm:"type:text"`
+}
+
+// Receives reports whether the webhook subscribed to events of the type.
+func (w Webhook) Receives(eventType events.Type) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, t := range w.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a webhook before it is registered.
+func (w Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https url", w.URL)
+	}
+	for _, t := range w.Events {
+		if _, err := events.ParseType(string(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewSecret generates a random secret for a webhook.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// EventTypes is stored as a comma separated list.
+type EventTypes []events.Type
+
+func (e EventTypes) Value() (driver.Value, error) {
+	names := make([]string, len(e))
+	for i, t := range e {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ","), nil
+}
+
+func (e *EventTypes) Scan(src interface{}) error {
+	var list string
+	switch value := src.(type) {
+	case nil:
+	case []byte:
+		list = string(value)
+	case string:
+		list = value
+	default:
+		return fmt.Errorf("event types cannot be read from %T", src)
+	}
+
+	*e = nil
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*e = append(*e, events.Type(name))
+		}
+	}
+	return nil
+}
+
+// Delivery is an event to be sent to a webhook.
+type Delivery struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	EventID   uint      `json:"eventId" gorm:"not null;uniqueIndex:idx_webhook_deliveries_event_webhook,priority:1"`
+	WebhookID uint      `json:"webhookId" gorm:"not null;uniqueIndex:idx_webhook_deliveries_event_webhook,priority:2"`
+	Status    string    `json:"status" gorm:"not null;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts  int       `json:"attempts" gorm:"not null"`
+	// NextAttemptAt is when a pending delivery is sent next.
+	NextAttemptAt time.Time  `json:"nextAttemptAt" gorm:"not null;index:idx_webhook_deliveries_due,priority:2"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastStatus    int        `json:"lastStatus,omitempty"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+
+	Event   *events.Event `json:"event,omitempty"`
+	Webhook *Webhook      `json:"webhook,omitempty"`
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// ParseStatus checks the name of a delivery status.
+func ParseStatus(name string) (string, error) {
+	for _, status := range Statuses {
+		if name == status {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("unknown status %q, use %s", name, strings.Join(Statuses, ", "))
+}